@@ -6,22 +6,24 @@ import (
 	"github.com/emicklei/proto"
 )
 
-func loadServiceList(srv *proto.Service) {
-	Visitor.AddSrv(srv.Name, srv)
+func (g *Generator) loadServiceList(srv *proto.Service) {
+	g.Visitor.AddSrv(srv.Name, srv)
 }
 
 // AddRoute 生成路由组
-func AddRoute(pbFile, routeName, api, genTo string) error {
+func (g *Generator) AddRoute(pbFile, routeName, api, genTo string) error {
+	g.reset()
+
 	definition, err := openProtoFile(pbFile)
 	if err != nil {
 		return err
 	}
 
 	proto.Walk(definition,
-		proto.WithService(loadServiceList),
+		proto.WithService(g.loadServiceList),
 	)
 
-	if _, exist := Visitor.SrvMap[routeName]; exist {
+	if _, exist := g.Visitor.SrvMap[routeName]; exist {
 		return fmt.Errorf("%s has been exist", routeName)
 	}
 
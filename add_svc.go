@@ -6,17 +6,19 @@ import (
 )
 
 // AddSvc 生成service
-func AddSvc(pbFile, svcName, genTo string) error {
+func (g *Generator) AddSvc(pbFile, svcName, genTo string) error {
+	g.reset()
+
 	definition, err := openProtoFile(pbFile)
 	if err != nil {
 		return err
 	}
 
 	proto.Walk(definition,
-		proto.WithService(loadServiceList),
+		proto.WithService(g.loadServiceList),
 	)
 
-	if _, exist := Visitor.SrvMap[svcName]; exist {
+	if _, exist := g.Visitor.SrvMap[svcName]; exist {
 		return fmt.Errorf("%s has been exist", svcName)
 	}
 
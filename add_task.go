@@ -14,20 +14,22 @@ import (
 )
 
 // AddTask 添加一个任务
-func AddTask(pbFile, taskSvc, taskName, genTo string) error {
+func (g *Generator) AddTask(pbFile, taskSvc, taskName, genTo string) error {
+	g.reset()
+
 	definition, err := openProtoFile(pbFile)
 	if err != nil {
 		return err
 	}
 
 	proto.Walk(definition,
-		proto.WithService(loadServiceList),
+		proto.WithService(g.loadServiceList),
 	)
 
 	var service *proto.Service
 	var exist bool
 	// 主任务信息不存在的情况
-	_, exist = Visitor.SrvMap[taskSvc]
+	_, exist = g.Visitor.SrvMap[taskSvc]
 	if !exist {
 		// 开始添加
 		service = &proto.Service{
@@ -54,9 +56,9 @@ func AddTask(pbFile, taskSvc, taskName, genTo string) error {
 		return err
 	}
 	proto.Walk(definition,
-		proto.WithService(loadServiceList),
+		proto.WithService(g.loadServiceList),
 	)
-	service, exist = Visitor.SrvMap[taskSvc]
+	service, exist = g.Visitor.SrvMap[taskSvc]
 	if !exist {
 		return fmt.Errorf("task svc name %s not found", taskSvc)
 	}
@@ -65,8 +67,8 @@ func AddTask(pbFile, taskSvc, taskName, genTo string) error {
 	// 检查 service 是否有同名子任务
 	var canSet = true
 	for _, task := range service.Elements {
-		rpc := task.(*proto.RPC)
-		if rpc.Name == taskName {
+		rpc, ok := task.(*proto.RPC)
+		if ok && rpc.Name == taskName {
 			canSet = false
 			break
 		}
@@ -117,13 +119,13 @@ func AddTask(pbFile, taskSvc, taskName, genTo string) error {
 }
 
 // TaskCodeGenTo 任务代码自动生成
-func TaskCodeGenTo() error {
+func (g *Generator) TaskCodeGenTo() error {
 	var KV = struct {
 		PackageName string
 		TaskConfig  map[string]TaskConfig
 	}{
-		PackageName: Visitor.PackageName,
-		TaskConfig:  Visitor.Tasks,
+		PackageName: g.Visitor.PackageName,
+		TaskConfig:  g.Visitor.Tasks,
 	}
 
 	t := template.New("task_funcs")
@@ -143,8 +145,8 @@ func TaskCodeGenTo() error {
 		return err
 	}
 
-	fileDir := path.Dir(PbFilePath)
-	fileName := path.Base(PbFilePath)
+	fileDir := path.Dir(g.pbFilePath)
+	fileName := path.Base(g.pbFilePath)
 	fileSuffix := path.Ext(fileName)
 	filePrefix := strings.Replace(fileName[0:len(fileName)-len(fileSuffix)], "origin_", "", 1)
 
@@ -154,12 +156,12 @@ func TaskCodeGenTo() error {
 	}
 
 	// task func generate
-	for _, config := range Visitor.Tasks {
+	for _, config := range g.Visitor.Tasks {
 		var taskKV = struct {
 			PackageName string
 			TaskNode    map[string]TaskNode
 		}{
-			PackageName: Visitor.PackageName,
+			PackageName: g.Visitor.PackageName,
 			TaskNode:    config.Task,
 		}
 
@@ -1,7 +1,6 @@
 package proto_parser
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"go/ast"
@@ -12,8 +11,10 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
-	"text/template"
+	"text/scanner"
 
 	"github.com/elliotchance/pie/pie"
 	"github.com/emicklei/proto"
@@ -22,16 +23,22 @@ import (
 
 // 用来解析.go文件 识别是否实现路由组 GRPC
 
+// defaultControllerPkgName 新建 gen_to 文件的默认包名
+const defaultControllerPkgName = "controller"
+
 type AstTree struct {
-	pos              *token.FileSet
-	fileTree         *ast.File
-	goPath           string
-	srvName          string
-	pkgName          string
-	groupRouterNode  []*GroupRouterNode
-	needCreate       bool
-	needImplBindFunc bool // 是否需要实现 bind 方法
-	importPackage    string
+	gen             *Generator
+	pos             *token.FileSet
+	fileTree        *ast.File
+	src             []byte
+	goPath          string
+	srvName         string
+	pkgName         string
+	groupRouterNode []*GroupRouterNode
+	needCreate      bool
+	importPackage   string
+	qualifier       string // proto 生成代码的包名前缀 同包时为空
+	coreName        string // core 包在文件中的引用名
 }
 
 // parseGoFile 解析文件
@@ -51,7 +58,6 @@ func (tree *AstTree) parseGoFile(goFile string, srvName string, importPackage st
 	tree.srvName = srvName
 	tree.pkgName = path.Base(path.Dir(goFile))
 	tree.groupRouterNode = routers
-	tree.needImplBindFunc = true
 	tree.importPackage = importPackage
 
 	if tree.pkgName == "." {
@@ -61,11 +67,11 @@ func (tree *AstTree) parseGoFile(goFile string, srvName string, importPackage st
 
 	if tree.needCreate {
 		// 直接创建文件
-		_ = tree.generateFile()
-		return nil
+		return tree.generateFile()
 	}
 
 	// Create the AST by parsing src.
+	tree.src = file
 	tree.pos = token.NewFileSet()
 	tree.fileTree, err = parser.ParseFile(tree.pos, goFile, file, parser.ParseComments)
 	if err != nil {
@@ -73,275 +79,326 @@ func (tree *AstTree) parseGoFile(goFile string, srvName string, importPackage st
 		return err
 	}
 
-	tree.checkAndGenerateRouteStruct()
-
-	// Print the AST.
-	//err = ast.Print(tree.pos, tree.fileTree)
-	//if err != nil {
-	//	logrus.Errorf("print file err: %+v", err)
-	//	return err
-	//}
-
-	return nil
+	return tree.checkAndGenerateRouteStruct()
 }
 
-// checkAndGenerateRouteStruct 检查路由struct 是否全部实现 没有完全实现 则补全 完全没实现 则生成 完全实现了 则不操作
-func (tree *AstTree) checkAndGenerateRouteStruct() {
-	// 检查 srvName 是否存在
-	var existSrvName bool
+// checkAndGenerateRouteStruct 检查路由 struct 是否全部实现 按 ast 的位置在原文件上增量修改 保留用户代码与注释
+// 完全没实现则生成 缺少的导入 Bind 与 rpc 方法会补全 请求响应类型变化的方法会更新签名
+// 接收者上形如路由方法 但 rpc 已从 proto 中删除的方法 默认输出警告 开启 CommentRemovedRPC 后注释掉
+func (tree *AstTree) checkAndGenerateRouteStruct() error {
 	if tree.fileTree == nil {
-		return
+		return nil
 	}
-GetResult:
-	for _, decl := range tree.fileTree.Decls {
-		gd, ok := decl.(*ast.GenDecl)
-		if !ok {
-			continue
+	imports := tree.resolveImports()
+
+	var edits []srcEdit
+	var appendTpl []string
+	if findStructType(tree.fileTree, tree.srvName) == nil {
+		appendTpl = append(appendTpl, CompleteRouteGenerateTpl)
+	} else {
+		implemented := receiverFuncNames(tree.fileTree, tree.srvName)
+		if _, ok := implemented["Bind"]; !ok {
+			appendTpl = append(appendTpl, FuncRouteGenerateBindFuncTpl)
 		}
 
-		for _, spec := range gd.Specs {
-			ts, ok := spec.(*ast.TypeSpec)
+		// 检查 srvName 对应的方法是否全部实现 已实现的检查签名
+		var noImplNode []*GroupRouterNode
+		var routes = make(map[string]bool)
+		for _, node := range tree.groupRouterNode {
+			routes[node.FuncName] = true
+			fd, ok := implemented[node.FuncName]
 			if !ok {
+				noImplNode = append(noImplNode, node)
 				continue
 			}
-
-			if ts.Name.Name == tree.srvName {
-				existSrvName = true
-				break GetResult
-			}
+			tree.gen.Visitor.AddImplRouter(tree.srvName, tree.goPath, node.rpc)
+			edits = append(edits, tree.signatureEdits(fd, node)...)
+		}
+		if len(noImplNode) != 0 {
+			tree.groupRouterNode = noImplNode
+			appendTpl = append(appendTpl, FuncRouteGenerateTpl)
 		}
+		edits = append(edits, tree.removedRouteEdits(implemented, routes)...)
 	}
 
-	if !existSrvName {
-		_ = tree.generateAll()
-		return
+	if len(edits) == 0 && len(appendTpl) == 0 {
+		return nil
 	}
 
-	// 检查 srvName 对应的方法是否全部实现
-	var noImplNode []*GroupRouterNode
-Restart:
-	for _, node := range tree.groupRouterNode {
-		for _, decl := range tree.fileTree.Decls {
-			fd, ok := decl.(*ast.FuncDecl)
-			if !ok {
-				continue
-			}
-
-			if fd.Name != nil {
-				if fd.Name.Name == node.FuncName {
-					// 取注释
-					//if fd.Doc != nil {
-					//	logrus.Infof("value: %+v", fd.Doc.Text())
-					//	split := strings.Split(trim(fd.Doc.Text()), " ")
-					//	logrus.Infof("len: %+v, %+v", len(split), split)
-					//}
-					Visitor.AddImplRouter(tree.srvName, tree.goPath, node.rpc)
-					continue Restart
-				}
-				// Bind 是否实现了
-				if fd.Name.Name == "Bind" {
-					tree.needImplBindFunc = false
-				}
-			}
-
-			//if fd.Doc != nil {
-			//	logrus.Infof("name: %+v, value: %+v", fd.Name.Name, fd.Doc.Text())
-			//}
+	// 追加的内容合并成一次插入 保持模板顺序
+	var appendText strings.Builder
+	for i, tpl := range appendTpl {
+		buf, err := executeTpl(fmt.Sprintf("generate_route_%d", i), tpl, tree.dataMap())
+		if err != nil {
+			return err
 		}
-		noImplNode = append(noImplNode, node)
+		appendText.Write(buf)
 	}
-
-	if tree.needImplBindFunc {
-		_ = tree.generateBindFunc()
+	if appendText.Len() != 0 {
+		edits = append(edits, srcEdit{offset: len(tree.src), text: appendText.String()})
 	}
 
-	if len(noImplNode) == 0 {
-		return
+	// 补全依赖包
+	if edit, ok := missingImportEdit(tree.pos, tree.fileTree, imports); ok {
+		edits = append(edits, edit)
 	}
 
-	tree.groupRouterNode = noImplNode
-
-	_ = tree.generateFunc()
-
-	// 全局替换一拨注释
+	return writeGoFile(tree.goPath, applySrcEdits(tree.src, edits))
 }
 
-func (tree *AstTree) generateFile() error {
-	var importPackagePart string
-	if tree.importPackage != "" {
-		tree.importPackage = strings.Trim(tree.importPackage, "\"")
-		sli := strings.Split(tree.importPackage, "/")
-		if len(sli) > 0 {
-			importPackagePart = sli[len(sli) - 1]
-		}
-		// 外面有判断err，这里就不判断了
-		modName, _ := GetCurrentModuleName()
-		if strings.HasSuffix(modName, sli[0]) {
-			tree.importPackage = "\"" + strings.Trim(modName, sli[0]) + tree.importPackage + "\""
-		} else {
-			tree.importPackage = "\"" + modName + "/" + tree.importPackage + "\""
+// resolveImports 确定 core 与 proto 生成代码在文件中的引用名 返回文件中缺少的导入
+func (tree *AstTree) resolveImports() []string {
+	var imports []string
+	tree.coreName = ""
+	for name := range coreImportNames(tree.fileTree) {
+		if name != "_" && name != "." {
+			tree.coreName = name
 		}
-		//tree.importPackage = "\"" + modName + strings.Trim(tree.importPackage, "\"") + "\""
-	}
-
-	var dataMap = map[string]interface{}{
-		"srvName":     tree.srvName,
-		"pkgName":     tree.pkgName,
-		"implPkgName": Visitor.PackageName,
-		"routerNode":  tree.groupRouterNode,
-		"importPackage": tree.importPackage,
-		"defaultControllerPkgName": "controller",
-		"importPackagePart": importPackagePart,
-	}
-
-	t, err := template.New("generate_all_file").Parse(CompleteRouteGenerateAndPackageTpl)
-	if err != nil {
-		logrus.Errorf("err: %+v", err)
-		return err
 	}
-	t.DefinedTemplates()
-	var buf bytes.Buffer
-	if err = t.Execute(&buf, dataMap); err != nil {
-		logrus.Errorf("err: %+v", err)
-		return err
+	if tree.coreName == "" {
+		tree.coreName = path.Base(corePkgPath)
+		imports = append(imports, corePkgPath)
 	}
 
-	// 文件夹不存在则创建
-	dir := filepath.Dir(tree.goPath)
-	dirExist := IsExist(dir)
-	if !dirExist {
-		// 递归创建文件夹
-		err := os.MkdirAll(dir, os.ModePerm)
-		if err != nil {
-			panic(err)
+	tree.qualifier = ""
+	if tree.importPackage == "" {
+		if implPkgName := tree.gen.Visitor.PackageName; implPkgName != tree.pkgName {
+			tree.qualifier = implPkgName + "."
 		}
+		return imports
 	}
-
-	f, err := os.OpenFile(tree.goPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
-	if err != nil {
-		panic(err)
+	importPath, importPackagePart := fixImportPackage(tree.importPackage)
+	importPath = strings.Trim(importPath, "\"")
+	if name, ok := importName(tree.fileTree, importPath); ok {
+		tree.qualifier = name + "."
+		return imports
 	}
-
-	defer f.Close()
-
-	if _, err = f.Write(buf.Bytes()); err != nil {
-		panic(err)
+	// 与 proto 生成代码同包 不需要导入
+	if tree.fileTree.Name.Name == importPackagePart {
+		return imports
 	}
-
-	return nil
+	tree.qualifier = importPackagePart + "."
+	return append(imports, importPath)
 }
 
-func (tree *AstTree) generateAll() error {
-	var dataMap = map[string]interface{}{
+func (tree *AstTree) dataMap() map[string]interface{} {
+	return map[string]interface{}{
 		"srvName":     tree.srvName,
 		"pkgName":     tree.pkgName,
-		"implPkgName": Visitor.PackageName,
+		"implPkgName": tree.gen.Visitor.PackageName,
 		"routerNode":  tree.groupRouterNode,
+		"qualifier":   tree.qualifier,
+		"coreName":    tree.coreName,
 	}
+}
 
-	t, err := template.New("generate_all_struct").Parse(CompleteRouteGenerateTpl)
-	if err != nil {
-		logrus.Errorf("err: %+v", err)
-		return err
-	}
-	t.DefinedTemplates()
-	var buf bytes.Buffer
-	if err = t.Execute(&buf, dataMap); err != nil {
-		logrus.Errorf("err: %+v", err)
-		return err
-	}
-
-	f, err := os.OpenFile(tree.goPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
-	if err != nil {
-		panic(err)
-	}
-
-	defer f.Close()
-
-	if _, err = f.Write(buf.Bytes()); err != nil {
-		panic(err)
-	}
+// nodeText 节点在源码中的内容
+func (tree *AstTree) nodeText(n ast.Node) string {
+	return string(tree.src[tree.pos.Position(n.Pos()).Offset:tree.pos.Position(n.End()).Offset])
+}
 
-	return nil
+// isRouteFunc 是否为路由方法 第一个参数为 *core.Context
+func (tree *AstTree) isRouteFunc(fd *ast.FuncDecl) bool {
+	params := fd.Type.Params.List
+	return len(params) != 0 && tree.nodeText(params[0].Type) == fmt.Sprintf("*%s.Context", tree.coreName)
 }
 
-func (tree *AstTree) generateFunc() error {
-	var dataMap = map[string]interface{}{
-		"srvName":     tree.srvName,
-		"pkgName":     tree.pkgName,
-		"implPkgName": Visitor.PackageName,
-		"routerNode":  tree.groupRouterNode,
+// signatureEdits 请求响应类型与 proto 不一致时 更新方法签名 以及方法中 new(类型) 与 类型{} 的写法
+// 参数与返回值按位置沿用原方法的命名 原来没有命名的保持不命名 个数不一致时只输出警告
+func (tree *AstTree) signatureEdits(fd *ast.FuncDecl, node *GroupRouterNode) []srcEdit {
+	var wantParams = []string{
+		fmt.Sprintf("*%s.Context", tree.coreName),
+		fmt.Sprintf("*%s%s", tree.qualifier, node.ReqName),
 	}
+	var wantResults = []string{fmt.Sprintf("*%s%s", tree.qualifier, node.RespName), "error"}
+	paramNames, paramTypes := flattenFields(fd.Type.Params)
+	resultNames, resultTypes := flattenFields(fd.Type.Results)
 
-	t, err := template.New("generate_func").Parse(FuncRouteGenerateTpl)
-	if err != nil {
-		logrus.Errorf("err: %+v", err)
-		return err
+	var stale = len(paramTypes) != len(wantParams) || len(resultTypes) != len(wantResults)
+	for i := 0; !stale && i < len(paramTypes); i++ {
+		stale = strings.Replace(tree.nodeText(paramTypes[i]), " ", "", -1) != wantParams[i]
 	}
-	t.DefinedTemplates()
-	var buf bytes.Buffer
-	if err = t.Execute(&buf, dataMap); err != nil {
-		logrus.Errorf("err: %+v", err)
-		return err
+	for i := 0; !stale && i < len(resultTypes); i++ {
+		stale = strings.Replace(tree.nodeText(resultTypes[i]), " ", "", -1) != wantResults[i]
 	}
-
-	f, err := os.OpenFile(tree.goPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
-	if err != nil {
-		panic(err)
+	if !stale {
+		return nil
+	}
+	// 参数个数不一致时无法对应原来的命名 改写会破坏方法体 只输出警告
+	if len(paramTypes) != len(wantParams) || len(resultTypes) != len(wantResults) {
+		p := tree.pos.Position(fd.Pos())
+		pos := scanner.Position{Filename: p.Filename, Line: p.Line, Column: p.Column}
+		tree.gen.warnf(pos, "%s.%s: 参数或返回值个数与 proto 不一致 请手动修改为 func%s %s",
+			tree.srvName, fd.Name.Name, fieldList([]string{"ctx", "req"}, wantParams), fieldList([]string{"resp", "err"}, wantResults))
+		return nil
 	}
 
-	defer f.Close()
-
-	if _, err = f.Write(buf.Bytes()); err != nil {
-		panic(err)
+	var edits = []srcEdit{{
+		offset: tree.pos.Position(fd.Type.Params.Pos()).Offset,
+		end:    tree.pos.Position(fd.Type.End()).Offset,
+		text:   fieldList(paramNames, wantParams) + " " + fieldList(resultNames, wantResults),
+	}}
+	if fd.Body == nil {
+		return edits
 	}
 
-	return nil
+	// 方法中原请求响应类型的 new(类型) 与 类型{} 一并更新
+	var replace = make(map[string]string)
+	if star, ok := paramTypes[1].(*ast.StarExpr); ok {
+		replace[tree.nodeText(star.X)] = strings.TrimPrefix(wantParams[1], "*")
+	}
+	if star, ok := resultTypes[0].(*ast.StarExpr); ok {
+		replace[tree.nodeText(star.X)] = strings.TrimPrefix(wantResults[0], "*")
+	}
+	var replaceEdit = func(expr ast.Expr) {
+		if expr == nil {
+			return
+		}
+		if typ, ok := replace[tree.nodeText(expr)]; ok && typ != tree.nodeText(expr) {
+			edits = append(edits, srcEdit{
+				offset: tree.pos.Position(expr.Pos()).Offset,
+				end:    tree.pos.Position(expr.End()).Offset,
+				text:   typ,
+			})
+		}
+	}
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		switch t := n.(type) {
+		case *ast.CallExpr:
+			if ident, ok := t.Fun.(*ast.Ident); ok && ident.Name == "new" && len(t.Args) == 1 {
+				replaceEdit(t.Args[0])
+			}
+		case *ast.CompositeLit:
+			replaceEdit(t.Type)
+		}
+		return true
+	})
+	return edits
 }
 
-func (tree *AstTree) generateBindFunc() error {
-	var dataMap = map[string]interface{}{
-		"srvName": tree.srvName,
+// fieldList 按位置组合参数或返回值列表 名字为空时不命名
+func fieldList(names, types []string) string {
+	var parts []string
+	for i, typ := range types {
+		if names[i] == "" {
+			parts = append(parts, typ)
+			continue
+		}
+		parts = append(parts, names[i]+" "+typ)
 	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
 
-	t, err := template.New("generate_func").Parse(FuncRouteGenerateBindFuncTpl)
-	if err != nil {
-		logrus.Errorf("err: %+v", err)
-		return err
-	}
-	t.DefinedTemplates()
-	var buf bytes.Buffer
-	if err = t.Execute(&buf, dataMap); err != nil {
-		logrus.Errorf("err: %+v", err)
-		return err
+// flattenFields 展开参数或返回值列表 没有命名的名字为空
+func flattenFields(fl *ast.FieldList) ([]string, []ast.Expr) {
+	if fl == nil {
+		return nil, nil
+	}
+	var names []string
+	var types []ast.Expr
+	for _, field := range fl.List {
+		if len(field.Names) == 0 {
+			names = append(names, "")
+			types = append(types, field.Type)
+			continue
+		}
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+			types = append(types, field.Type)
+		}
 	}
+	return names, types
+}
 
-	f, err := os.OpenFile(tree.goPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
-	if err != nil {
-		panic(err)
+// removedRouteEdits 接收者上 rpc 已从 proto 中删除的路由方法 默认输出警告 开启 CommentRemovedRPC 后注释掉
+func (tree *AstTree) removedRouteEdits(implemented map[string]*ast.FuncDecl, routes map[string]bool) []srcEdit {
+	var names []string
+	for name, fd := range implemented {
+		if name == "Bind" || routes[name] || !ast.IsExported(name) || !tree.isRouteFunc(fd) {
+			continue
+		}
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	defer f.Close()
+	var edits []srcEdit
+	for _, name := range names {
+		fd := implemented[name]
+		p := tree.pos.Position(fd.Pos())
+		pos := scanner.Position{Filename: p.Filename, Line: p.Line, Column: p.Column}
+		if !tree.gen.commentRemovedRPC {
+			tree.gen.warnf(pos, "%s.%s: rpc 已从 proto 中删除 请手动删除该方法或开启 CommentRemovedRPC", tree.srvName, name)
+			continue
+		}
+		tree.gen.warnf(pos, "%s.%s: rpc 已从 proto 中删除 方法已注释", tree.srvName, name)
+		start, end := p.Offset, tree.pos.Position(fd.End()).Offset
+		lines := strings.Split(string(tree.src[start:end]), "\n")
+		for i, line := range lines {
+			lines[i] = "// " + line
+		}
+		edits = append(edits, srcEdit{
+			offset: start,
+			end:    end,
+			text:   fmt.Sprintf("// %s rpc 已从 proto 中删除\n%s", name, strings.Join(lines, "\n")),
+		})
+	}
+	return edits
+}
 
-	if _, err = f.Write(buf.Bytes()); err != nil {
-		panic(err)
+// importName 文件中导入 importPath 时使用的包名
+func importName(f *ast.File, importPath string) (string, bool) {
+	for _, spec := range f.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || p != importPath {
+			continue
+		}
+		if spec.Name != nil {
+			if spec.Name.Name == "_" || spec.Name.Name == "." {
+				continue
+			}
+			return spec.Name.Name, true
+		}
+		return path.Base(p), true
 	}
+	return "", false
+}
 
-	return nil
+// generateFile 创建 gen_to 文件 只写入 package 后与已有文件一样补全 struct 方法与导入
+// 目录名不是 controller 时包名为 目录名_controller 与 proto 生成代码同目录时使用同一个包名
+func (tree *AstTree) generateFile() error {
+	var pkgName = defaultControllerPkgName
+	if tree.importPackage == "" && tree.pkgName == tree.gen.Visitor.PackageName {
+		pkgName = tree.pkgName
+	} else if tree.pkgName != defaultControllerPkgName {
+		pkgName = tree.pkgName + "_" + defaultControllerPkgName
+	}
+	tree.src = []byte(fmt.Sprintf("package %s\n", pkgName))
+	tree.pos = token.NewFileSet()
+	fileTree, err := parser.ParseFile(tree.pos, tree.goPath, tree.src, parser.ParseComments)
+	if err != nil {
+		logrus.Errorf("parse file err: %+v", err)
+		return err
+	}
+	tree.fileTree = fileTree
+	return tree.checkAndGenerateRouteStruct()
 }
 
-func checkRouterErrorCode(srv *proto.Service) {
-	s, exist := Visitor.ImplementedRouter[srv.Name]
+// checkRouterErrorCode 把 gen_to 中用到的错误码注入 rpc 的 @error 返回注释是否有变化
+func (g *Generator) checkRouterErrorCode(srv *proto.Service) bool {
+	s, exist := g.Visitor.ImplementedRouter[srv.Name]
 	if !exist {
-		return
+		return false
 	}
-	var g = genToFile{srvImplName: fmt.Sprintf("%sImpl", srv.Name), srvDetail: s}
-	if err := g.parseGoFile(); err != nil {
+	var gf = genToFile{gen: g, srvImplName: fmt.Sprintf("%sImpl", srv.Name), srvDetail: s}
+	changed, err := gf.parseGoFile()
+	if err != nil {
 		logrus.Errorf("parse go file err: %+v", err)
 	}
+	return changed
 }
 
 type genToFile struct {
+	gen              *Generator
 	srvImplName      string
 	srvDetail        *positionSrv
 	currentInjectRPC *injectRPC
@@ -354,19 +411,43 @@ type injectRPC struct {
 	funcs           []*ast.FuncDecl
 }
 
-// parseGoFile 解析 service 对应的 gen_to 的文件
-func (g *genToFile) parseGoFile() error {
-	f, err := os.Open(g.srvDetail.genTo)
+// parseGoFile 解析 service 对应的 gen_to 的文件 把代码中用到的错误码注入到 rpc 的 @error
+func (g *genToFile) parseGoFile() (bool, error) {
+	rpcCodes, err := g.rpcErrCodes()
+	if err != nil {
+		return false, err
+	}
+	var changed bool
+	for name, ecs := range rpcCodes {
+		if len(ecs) == 0 {
+			continue
+		}
+		rpc := g.srvDetail.rpcMap[name]
+		var before []string
+		if rpc.Comment != nil {
+			before = rpc.Comment.Lines
+		}
+		injectRpcErrorCodeComment(rpc, ecs)
+		if strings.Join(before, "\n") != strings.Join(rpc.Comment.Lines, "\n") {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// rpcErrCodes 解析 gen_to 的文件 返回已实现的 rpc 在代码中用到的错误码 按名字排序
+func (g *genToFile) rpcErrCodes() (map[string][]string, error) {
+	src, err := ioutil.ReadFile(g.srvDetail.genTo)
 	if err != nil {
 		logrus.Errorf("err: %+v", err)
-		return err
+		return nil, err
 	}
 
 	pos := token.NewFileSet()
-	astF, err := parser.ParseFile(pos, g.srvDetail.genTo, f, 0)
+	astF, err := parser.ParseFile(pos, g.srvDetail.genTo, src, 0)
 	if err != nil {
 		logrus.Errorf("err: %+v", err)
-		return err
+		return nil, err
 	}
 	//_ = ast.Print(pos, astF)
 
@@ -397,7 +478,7 @@ func (g *genToFile) parseGoFile() error {
 					continue
 				}
 
-				pkgName = Visitor.PackageName
+				pkgName = g.gen.Visitor.PackageName
 			} else {
 				if defType.Sel.Name != g.srvImplName {
 					continue
@@ -439,8 +520,8 @@ func (g *genToFile) parseGoFile() error {
 	}
 
 	if g.currentInjectRPC == nil {
-		_, _ = fmt.Fprintf(os.Stderr, "get rpc nil, skip...\n")
-		return nil
+		logrus.Debugf("%s: %s not implemented, skip...", g.srvDetail.genTo, g.srvImplName)
+		return nil, nil
 	}
 
 	for _, decl := range astF.Decls {
@@ -469,27 +550,31 @@ func (g *genToFile) parseGoFile() error {
 		}
 	}
 
+	// 优先按类型分析 跟进模块内的函数调用 包加载失败时回退到语法分析
+	analyzer, err := g.gen.errCodePkgs.analyzer(g.srvDetail.genTo, g.currentInjectRPC.protoPkg)
+	if err != nil {
+		logrus.Warnf("load package of %s err: %v, fallback to ast", g.srvDetail.genTo, err)
+	}
+
 	// 收集完了后 解析 funcs 的 body
+	var rpcCodes = make(map[string][]string)
 	for _, decl := range g.currentInjectRPC.funcs {
-		rpc, exist := g.srvDetail.rpcMap[getFuncDeclName(decl)]
-		if !exist {
+		name := getFuncDeclName(decl)
+		if _, exist := g.srvDetail.rpcMap[name]; !exist {
 			continue
 		}
-		ok, res := iterBodyToGetCreateErrorStmt(decl.Body)
-		if !ok {
-			continue
+		var res []ecInfo
+		var ok bool
+		if analyzer != nil {
+			res, ok = analyzer.handlerErrCodes(g.currentInjectRPC.implementStruct, name)
 		}
-		ecs := repackErrorCode(g.currentInjectRPC.protoPkg, res)
-		if len(ecs) == 0 {
-			continue
+		if !ok {
+			_, res = iterBodyToGetCreateErrorStmt(astF, decl)
 		}
-		ecs = pie.Strings(ecs).Unique().Sort()
-		injectRpcErrorCodeComment(rpc, ecs)
-		//logrus.Infof("rpc comment: %+v", rpc.Comment.Lines)
-		//g.srvDetail.rpcMap[getFuncDeclName(decl)] = rpc
+		rpcCodes[name] = pie.Strings(repackErrorCode(g.currentInjectRPC.protoPkg, res)).Sort()
 	}
 
-	return nil
+	return rpcCodes, nil
 }
 
 func injectRpcErrorCodeComment(rpc *proto.RPC, ecs []string) {
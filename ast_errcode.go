@@ -0,0 +1,314 @@
+package proto_parser
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// corePkgPath 创建错误的 core 包
+const corePkgPath = "github.com/actorbuf/iota/core"
+
+// createErrorFuncs core 包中创建错误的函数 第一个参数为错误码
+var createErrorFuncs = map[string]bool{
+	"CreateError":        true,
+	"CreateErrorWithMsg": true,
+}
+
+// errCodeFuncDecl 模块内函数的定义及其所在包的类型信息
+type errCodeFuncDecl struct {
+	decl *ast.FuncDecl
+	info *types.Info
+}
+
+// errCodeAnalyzer 基于 go/packages 与 go/types 分析 handler 中用到的错误码
+// 会跟进模块内的函数调用 闭包 defer go 语句以及所有分支都会被检查
+type errCodeAnalyzer struct {
+	*errCodeLoadedPkg
+	mainPkg  string // 与 proto 对应的包名 其中的错误码不带包名前缀
+	mainPath string // 与 proto 对应的包路径
+}
+
+// errCodeLoadedPkg 加载好的包及模块内函数的定义 同一个目录下的 gen_to 文件共用
+type errCodeLoadedPkg struct {
+	pkg   *packages.Package // gen_to 文件所在的包
+	funcs map[*types.Func]*errCodeFuncDecl
+}
+
+// errCodePkgCache 一次运行中按目录缓存加载的包 目录下的 go 文件内容变化后重新加载
+// 加载包需要类型检查全部依赖 每个路由组都加载一次时耗时很长
+type errCodePkgCache struct {
+	mu   sync.Mutex
+	dirs map[string]*errCodePkgEntry
+}
+
+type errCodePkgEntry struct {
+	mu          sync.Mutex
+	fingerprint string
+	pkg         *errCodeLoadedPkg
+	err         error
+}
+
+func newErrCodePkgCache() *errCodePkgCache {
+	return &errCodePkgCache{dirs: make(map[string]*errCodePkgEntry)}
+}
+
+// analyzer 使用缓存加载 goFile 所在的包 c 为 nil 时不缓存
+func (c *errCodePkgCache) analyzer(goFile, protoPkg string) (*errCodeAnalyzer, error) {
+	if c == nil {
+		return loadErrCodeAnalyzer(goFile, protoPkg)
+	}
+	abs, err := filepath.Abs(goFile)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(abs)
+	c.mu.Lock()
+	entry, ok := c.dirs[dir]
+	if !ok {
+		entry = new(errCodePkgEntry)
+		c.dirs[dir] = entry
+	}
+	c.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	fingerprint, err := goFilesFingerprint(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || entry.fingerprint != fingerprint {
+		entry.fingerprint = fingerprint
+		entry.pkg, entry.err = loadErrCodePackage(dir)
+	}
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return newErrCodeAnalyzer(entry.pkg, abs, protoPkg), nil
+}
+
+// goFilesFingerprint 目录下 go 文件的文件名与内容摘要
+func goFilesFingerprint(dir string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return "", err
+	}
+	h := md5.New()
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		_, _ = fmt.Fprintf(h, "%s\x00%d\x00", filepath.Base(file), len(data))
+		_, _ = h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadErrCodeAnalyzer 加载 goFile 所在的包及其依赖 依赖有错误时返回错误 由调用方回退到语法分析
+func loadErrCodeAnalyzer(goFile, protoPkg string) (*errCodeAnalyzer, error) {
+	abs, err := filepath.Abs(goFile)
+	if err != nil {
+		return nil, err
+	}
+	p, err := loadErrCodePackage(filepath.Dir(abs))
+	if err != nil {
+		return nil, err
+	}
+	return newErrCodeAnalyzer(p, abs, protoPkg), nil
+}
+
+// loadErrCodePackage 加载 dir 下的包及其依赖 收集模块内的函数定义
+func loadErrCodePackage(dir string) (*errCodeLoadedPkg, error) {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps |
+			packages.NeedModule | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir: dir,
+	}
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("load %s: 找到 %d 个包", cfg.Dir, len(pkgs))
+	}
+	var loadErr error
+	packages.Visit(pkgs, nil, func(p *packages.Package) {
+		if len(p.Errors) != 0 && loadErr == nil {
+			loadErr = p.Errors[0]
+		}
+	})
+	if loadErr != nil {
+		return nil, loadErr
+	}
+
+	var res = &errCodeLoadedPkg{
+		pkg:   pkgs[0],
+		funcs: make(map[*types.Func]*errCodeFuncDecl),
+	}
+	// 只跟进主模块内的函数 没有模块信息时只跟进当前包
+	packages.Visit(pkgs, nil, func(p *packages.Package) {
+		if p != res.pkg && (p.Module == nil || !p.Module.Main) {
+			return
+		}
+		for _, f := range p.Syntax {
+			for _, decl := range f.Decls {
+				fd, ok := decl.(*ast.FuncDecl)
+				if !ok || fd.Body == nil {
+					continue
+				}
+				if fn, ok := p.TypesInfo.Defs[fd.Name].(*types.Func); ok {
+					res.funcs[fn] = &errCodeFuncDecl{decl: fd, info: p.TypesInfo}
+				}
+			}
+		}
+	})
+	return res, nil
+}
+
+// newErrCodeAnalyzer 分析绝对路径为 goFile 的文件 proto 对应的包通过 import 引入时 按 import 的包路径识别
+func newErrCodeAnalyzer(p *errCodeLoadedPkg, goFile, protoPkg string) *errCodeAnalyzer {
+	var a = &errCodeAnalyzer{
+		errCodeLoadedPkg: p,
+		mainPkg:          protoPkg,
+		mainPath:         p.pkg.PkgPath,
+	}
+	for _, f := range a.pkg.Syntax {
+		if filepath.Base(a.pkg.Fset.File(f.Pos()).Name()) != filepath.Base(goFile) {
+			continue
+		}
+		for _, spec := range f.Imports {
+			obj := a.pkg.TypesInfo.Implicits[spec]
+			if spec.Name != nil {
+				obj = a.pkg.TypesInfo.Defs[spec.Name]
+			}
+			if pn, ok := obj.(*types.PkgName); ok && pn.Name() == protoPkg {
+				a.mainPath = pn.Imported().Path()
+			}
+		}
+	}
+	return a
+}
+
+// handlerErrCodes implName 实现的 rpc 方法 funcName 中用到的错误码
+func (a *errCodeAnalyzer) handlerErrCodes(implName, funcName string) ([]ecInfo, bool) {
+	tn, ok := a.pkg.Types.Scope().Lookup(implName).(*types.TypeName)
+	if !ok {
+		return nil, false
+	}
+	obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(tn.Type()), true, a.pkg.Types, funcName)
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return nil, false
+	}
+	return a.funcErrCodes(fn, make(map[*types.Func]bool)), true
+}
+
+// funcErrCodes 函数中用到的错误码 被引用的模块内函数也会被检查 visited 防止递归
+func (a *errCodeAnalyzer) funcErrCodes(fn *types.Func, visited map[*types.Func]bool) []ecInfo {
+	if visited[fn] {
+		return nil
+	}
+	visited[fn] = true
+	fd, ok := a.funcs[fn]
+	if !ok {
+		return nil
+	}
+	var res []ecInfo
+	ast.Inspect(fd.decl.Body, func(n ast.Node) bool {
+		switch t := n.(type) {
+		case *ast.CallExpr:
+			callee := calleeFunc(fd.info, t)
+			if callee == nil || callee.Pkg() == nil || callee.Pkg().Path() != corePkgPath || !createErrorFuncs[callee.Name()] {
+				return true
+			}
+			if ec, ok := a.errCodeArg(fd.info, t); ok {
+				res = append(res, ec)
+			}
+		case *ast.Ident:
+			// 调用或者作为值传递的函数 方法都跟进
+			if callee, ok := fd.info.Uses[t].(*types.Func); ok {
+				res = append(res, a.funcErrCodes(callee, visited)...)
+			}
+		}
+		return true
+	})
+	return res
+}
+
+// errCodeArg CreateError 第一个参数引用的错误码常量
+func (a *errCodeAnalyzer) errCodeArg(info *types.Info, call *ast.CallExpr) (ecInfo, bool) {
+	if len(call.Args) == 0 {
+		return ecInfo{}, false
+	}
+	var ident *ast.Ident
+	switch arg := call.Args[0].(type) {
+	case *ast.Ident:
+		ident = arg
+	case *ast.SelectorExpr:
+		ident = arg.Sel
+	default:
+		return ecInfo{}, false
+	}
+	c, ok := info.Uses[ident].(*types.Const)
+	if !ok || c.Pkg() == nil {
+		return ecInfo{}, false
+	}
+	var pkgName = c.Pkg().Name()
+	if c.Pkg().Path() == a.mainPath {
+		pkgName = a.mainPkg
+	}
+	return ecInfo{
+		pkg:      pkgName,
+		name:     c.Name(),
+		fullName: fmt.Sprintf("%s.%s", pkgName, c.Name()),
+	}, true
+}
+
+// calleeFunc 调用的函数或方法 通过函数变量调用时返回 nil
+func calleeFunc(info *types.Info, call *ast.CallExpr) *types.Func {
+	fun := call.Fun
+	for {
+		paren, ok := fun.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		fun = paren.X
+	}
+	var ident *ast.Ident
+	switch t := fun.(type) {
+	case *ast.Ident:
+		ident = t
+	case *ast.SelectorExpr:
+		ident = t.Sel
+	default:
+		return nil
+	}
+	fn, _ := info.Uses[ident].(*types.Func)
+	return fn
+}
+
+// coreImportNames 文件中 core 包的引用名 支持 import 别名
+func coreImportNames(f *ast.File) map[string]bool {
+	var res = make(map[string]bool)
+	for _, spec := range f.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || p != corePkgPath {
+			continue
+		}
+		if spec.Name != nil {
+			res[spec.Name.Name] = true
+			continue
+		}
+		res[filepath.Base(p)] = true
+	}
+	return res
+}
@@ -0,0 +1,202 @@
+package proto_parser
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var errCodeTestFiles = map[string]string{
+	"go.mod": `module example.com/demo
+
+go 1.16
+
+require github.com/actorbuf/iota v0.0.0
+
+replace github.com/actorbuf/iota => ./iota
+`,
+	"iota/go.mod": `module github.com/actorbuf/iota
+
+go 1.16
+`,
+	"iota/core/core.go": `package core
+
+import "errors"
+
+func CreateError(code interface{}) error { return errors.New("err") }
+
+func CreateErrorWithMsg(code interface{}, msg string) error { return errors.New(msg) }
+`,
+	"user/errcode.go": `package user
+
+type ErrCode int32
+
+const (
+	ErrCode_None ErrCode = 0
+	ErrElse      ErrCode = 1
+	ErrCase      ErrCode = 2
+	ErrRange     ErrCode = 3
+	ErrClosure   ErrCode = 4
+	ErrDefer     ErrCode = 5
+	ErrGo        ErrCode = 6
+	ErrHelper    ErrCode = 7
+	ErrMethod    ErrCode = 8
+	ErrOther     ErrCode = 9
+)
+
+type UserAPIImpl interface {
+	CreateUser() error
+}
+`,
+	"other/other.go": `package other
+
+type Code int32
+
+const ErrOther Code = 1
+`,
+	"util/util.go": `package util
+
+import (
+	"example.com/demo/user"
+	c "github.com/actorbuf/iota/core"
+)
+
+func Check(ok bool) error {
+	if !ok {
+		return c.CreateError(user.ErrHelper)
+	}
+	return Check(true)
+}
+`,
+	"handler/user.go": `package handler
+
+import (
+	pb "example.com/demo/user"
+	"example.com/demo/other"
+	"example.com/demo/util"
+	ec "github.com/actorbuf/iota/core"
+)
+
+var _ pb.UserAPIImpl = (*UserAPI)(nil)
+
+type UserAPI struct{}
+
+func (s *UserAPI) CreateUser() error {
+	var err error
+	if err != nil {
+		return nil
+	} else {
+		err = ec.CreateError(pb.ErrElse)
+	}
+	switch {
+	case err == nil:
+		err = ec.CreateErrorWithMsg(pb.ErrCase, "case")
+	}
+	for range []int{1} {
+		err = ec.CreateError(pb.ErrRange)
+	}
+	fn := func() error { return ec.CreateError(pb.ErrClosure) }
+	defer func() { _ = ec.CreateError(pb.ErrDefer) }()
+	go func() { _ = ec.CreateError(pb.ErrGo) }()
+	_ = fn
+	_ = util.Check(false)
+	_ = s.check()
+	return ec.CreateError(other.ErrOther)
+}
+
+func (s *UserAPI) check() error {
+	return ec.CreateError(pb.ErrMethod)
+}
+`,
+}
+
+func writeErrCodeTestFiles(t *testing.T, dir string) {
+	for name, src := range errCodeTestFiles {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestErrCodeAnalyzer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "errcode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeErrCodeTestFiles(t, dir)
+	goFile := filepath.Join(dir, "handler", "user.go")
+
+	a, err := loadErrCodeAnalyzer(goFile, "pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, ok := a.handlerErrCodes("UserAPI", "CreateUser")
+	if !ok {
+		t.Fatal("handler not found")
+	}
+	ecs := repackErrorCode("pb", res)
+	sort.Strings(ecs)
+	want := []string{"ErrCase", "ErrClosure", "ErrDefer", "ErrElse", "ErrGo", "ErrHelper", "ErrMethod", "ErrRange", "other.ErrOther"}
+	if !reflect.DeepEqual(ecs, want) {
+		t.Errorf("unexpected error codes: %v", ecs)
+	}
+
+	// 回退的语法分析不跟进其他包 但要识别别名与所有分支
+	f, err := parser.ParseFile(token.NewFileSet(), goFile, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := receiverFuncNames(f, "UserAPI")["CreateUser"]
+	_, res = iterBodyToGetCreateErrorStmt(f, fd)
+	ecs = repackErrorCode("pb", res)
+	sort.Strings(ecs)
+	want = []string{"ErrCase", "ErrClosure", "ErrDefer", "ErrElse", "ErrGo", "ErrMethod", "ErrRange", "other.ErrOther"}
+	if !reflect.DeepEqual(ecs, want) {
+		t.Errorf("unexpected error codes: %v", ecs)
+	}
+}
+
+func TestErrCodePkgCache(t *testing.T) {
+	dir := t.TempDir()
+	writeErrCodeTestFiles(t, dir)
+	goFile := filepath.Join(dir, "handler", "user.go")
+
+	c := newErrCodePkgCache()
+	a1, err := c.analyzer(goFile, "pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, err := c.analyzer(goFile, "pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a1.errCodeLoadedPkg != a2.errCodeLoadedPkg {
+		t.Error("expect the package to be loaded once per directory")
+	}
+
+	// 目录下的 go 文件变化后重新加载
+	src := "package handler\n\nfunc (s *UserAPI) DeleteUser() error { return nil }\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "handler", "delete.go"), []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	a3, err := c.analyzer(goFile, "pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a3.errCodeLoadedPkg == a1.errCodeLoadedPkg {
+		t.Error("expect the package to be reloaded after files change")
+	}
+	if _, ok := a3.handlerErrCodes("UserAPI", "DeleteUser"); !ok {
+		t.Error("expect the new method to be found after reload")
+	}
+}
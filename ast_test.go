@@ -0,0 +1,173 @@
+package proto_parser
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/proto"
+)
+
+func TestAstTree_checkAndGenerateRouteStruct(t *testing.T) {
+	goFile := filepath.Join(t.TempDir(), "user_controller.go")
+	src := `package controller
+
+import (
+	pb "example.com/pb/user"
+	ic "github.com/actorbuf/iota/core"
+)
+
+type UserAPI struct{}
+
+// GetUser 获取用户 用户注释
+func (r *UserAPI) GetUser(c *ic.Context, in *pb.GetUserOldReq) (*pb.GetUserOldResp, error) {
+	// 用户代码
+	resp := new(pb.GetUserOldResp)
+	return resp, nil
+}
+
+// DelUser 删除用户
+func (r *UserAPI) DelUser(c *ic.Context, in *pb.DelUserReq) (*pb.DelUserResp, error) {
+	return &pb.DelUserResp{}, nil
+}
+
+// ListUser 参数个数与 proto 不一致 只警告不改写
+func (r *UserAPI) ListUser(c *ic.Context) (*pb.ListUserResp, error) {
+	return &pb.ListUserResp{}, nil
+}
+
+func (r *UserAPI) helper() {}
+`
+	if err := ioutil.WriteFile(goFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var g = NewGenerator()
+	g.Visitor.PackageName = "pb"
+	g.commentRemovedRPC = true
+	var routers = []*GroupRouterNode{
+		{FuncName: "GetUser", ReqName: "GetUserReq", RespName: "GetUserResp", rpc: &proto.RPC{Name: "GetUser"}},
+		{FuncName: "CreateUser", ReqName: "CreateUserReq", RespName: "CreateUserResp", Describe: "创建用户", rpc: &proto.RPC{Name: "CreateUser"}},
+		{FuncName: "ListUser", ReqName: "ListUserReq", RespName: "ListUserResp", rpc: &proto.RPC{Name: "ListUser"}},
+	}
+	var tree = &AstTree{gen: g}
+	if err := tree.parseGoFile(goFile, "UserAPI", "", routers); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(goFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := string(content)
+	if _, err := parser.ParseFile(token.NewFileSet(), goFile, content, 0); err != nil {
+		t.Fatalf("generated file does not parse: %v\n%s", err, res)
+	}
+	for _, want := range []string{
+		"// GetUser 获取用户 用户注释",
+		"func (r *UserAPI) GetUser(c *ic.Context, in *pb.GetUserReq) (*pb.GetUserResp, error) {",
+		"// 用户代码",
+		"resp := new(pb.GetUserResp)",
+		"func (receiver *UserAPI) CreateUser(ctx *ic.Context, req *pb.CreateUserReq) (resp *pb.CreateUserResp, err error) {",
+		"func (receiver *UserAPI) Bind() string {",
+		"// func (r *UserAPI) DelUser(",
+		"func (r *UserAPI) ListUser(c *ic.Context) (*pb.ListUserResp, error) {",
+		"func (r *UserAPI) helper() {}",
+	} {
+		if !strings.Contains(res, want) {
+			t.Errorf("want %q in:\n%s", want, res)
+		}
+	}
+	if strings.Count(res, "iota/core") != 1 {
+		t.Errorf("core should not be imported again:\n%s", res)
+	}
+	var warnings int
+	for _, d := range g.Diagnostics() {
+		if d.Severity == SeverityWarning {
+			warnings++
+		}
+	}
+	if len(g.Diagnostics()) != 2 || warnings != 2 {
+		t.Errorf("unexpected diagnostics: %v", g.Diagnostics())
+	}
+
+	// 再次生成时不改动
+	tree = &AstTree{gen: g}
+	if err := tree.parseGoFile(goFile, "UserAPI", "", routers); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := ioutil.ReadFile(goFile)
+	if string(again) != res {
+		t.Errorf("second run changed file:\n%s", again)
+	}
+}
+
+func TestAstTree_missingCoreImport(t *testing.T) {
+	goFile := filepath.Join(t.TempDir(), "user_controller.go")
+	src := `package controller
+
+type UserAPI struct{}
+`
+	if err := ioutil.WriteFile(goFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var g = NewGenerator()
+	g.Visitor.PackageName = "pb"
+	var routers = []*GroupRouterNode{
+		{FuncName: "GetUser", ReqName: "GetUserReq", RespName: "GetUserResp", rpc: &proto.RPC{Name: "GetUser"}},
+	}
+	var tree = &AstTree{gen: g}
+	if err := tree.parseGoFile(goFile, "UserAPI", "", routers); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := ioutil.ReadFile(goFile)
+	f, err := parser.ParseFile(token.NewFileSet(), goFile, content, 0)
+	if err != nil {
+		t.Fatalf("generated file does not parse: %v\n%s", err, content)
+	}
+	if _, ok := importName(f, corePkgPath); !ok {
+		t.Errorf("missing core import:\n%s", content)
+	}
+	if !strings.Contains(string(content), "func (receiver *UserAPI) GetUser(ctx *core.Context, req *pb.GetUserReq)") {
+		t.Errorf("missing rpc method:\n%s", content)
+	}
+}
+
+func TestAstTree_generateFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "pb")
+	goFile := filepath.Join(dir, "user_controller.go")
+
+	var g = NewGenerator()
+	g.Visitor.PackageName = "pb"
+	var routers = []*GroupRouterNode{
+		{FuncName: "GetUser", ReqName: "GetUserReq", RespName: "GetUserResp", rpc: &proto.RPC{Name: "GetUser"}},
+	}
+	// 没有 go_package 且与 proto 生成代码同目录
+	var tree = &AstTree{gen: g}
+	if err := tree.parseGoFile(goFile, "UserAPI", "", routers); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(goFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), goFile, content, 0); err != nil {
+		t.Fatalf("generated file does not parse: %v\n%s", err, content)
+	}
+	for _, want := range []string{
+		"package pb\n",
+		`"github.com/actorbuf/iota/core"`,
+		"var _ UserAPIImpl = (*UserAPI)(nil)",
+		"func (receiver *UserAPI) GetUser(ctx *core.Context, req *GetUserReq) (resp *GetUserResp, err error) {",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("want %q in:\n%s", want, content)
+		}
+	}
+}
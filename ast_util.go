@@ -1,8 +1,20 @@
 package proto_parser
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
 )
 
 // ecInfo 是 errorCode info 的简称
@@ -22,88 +34,264 @@ func getFuncDeclName(f *ast.FuncDecl) string {
 // 	return f.Body
 // }
 
-// iterBodyToGetCreateErrorStmt 获取 CreateError
-func iterBodyToGetCreateErrorStmt(body interface{}) (bool, []ecInfo) {
-	var res []ecInfo
-	switch t := body.(type) {
-	case *ast.BlockStmt:
-		// 是 body 取行 再递归
-		for _, stmt := range t.List {
-			ok, r := iterBodyToGetCreateErrorStmt(stmt)
-			if ok {
-				res = append(res, r...)
-			}
+// iterBodyToGetCreateErrorStmt 语法分析获取 CreateError 类型分析失败时使用
+// 检查所有分支 闭包 defer go 语句 并跟进同一文件中的函数与接收者的方法
+func iterBodyToGetCreateErrorStmt(f *ast.File, decl *ast.FuncDecl) (bool, []ecInfo) {
+	var w = &createErrorWalker{
+		coreNames: coreImportNames(f),
+		funcs:     make(map[string]*ast.FuncDecl),
+		methods:   receiverFuncNames(f, getRecvTypeName(decl)),
+		visited:   make(map[*ast.FuncDecl]bool),
+	}
+	for _, d := range f.Decls {
+		if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv == nil {
+			w.funcs[getFuncDeclName(fd)] = fd
 		}
-	case *ast.AssignStmt:
-		// 是申明
-		for _, rh := range t.Rhs {
-			ok, r := iterBodyToGetCreateErrorStmt(rh)
-			if ok {
-				res = append(res, r...)
-			}
+	}
+	res := w.walk(decl)
+	return len(res) != 0, res
+}
+
+// createErrorWalker 语法分析时的上下文
+type createErrorWalker struct {
+	coreNames map[string]bool          // core 包的引用名
+	funcs     map[string]*ast.FuncDecl // 文件中的函数
+	methods   map[string]*ast.FuncDecl // 接收者的方法
+	visited   map[*ast.FuncDecl]bool
+}
+
+func (w *createErrorWalker) walk(decl *ast.FuncDecl) []ecInfo {
+	if decl.Body == nil || w.visited[decl] {
+		return nil
+	}
+	w.visited[decl] = true
+	var recvName string
+	if decl.Recv != nil && len(decl.Recv.List) != 0 && len(decl.Recv.List[0].Names) != 0 {
+		recvName = decl.Recv.List[0].Names[0].Name
+	}
+	var res []ecInfo
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
 		}
-	case *ast.CallExpr:
-		fun, ok := t.Fun.(*ast.SelectorExpr)
-		if ok {
-			x, xok := fun.X.(*ast.Ident)
-			if !xok {
-				return false, nil
+		switch fun := call.Fun.(type) {
+		case *ast.Ident:
+			if fd, ok := w.funcs[fun.Name]; ok {
+				res = append(res, w.walk(fd)...)
 			}
-			if x.Name != "core" {
-				return false, nil
+		case *ast.SelectorExpr:
+			x, ok := fun.X.(*ast.Ident)
+			if !ok {
+				return true
 			}
-			if fun.Sel.Name != "CreateError" && fun.Sel.Name != "CreateErrorWithMsg" {
-				return false, nil
+			if recvName != "" && x.Name == recvName {
+				if fd, ok := w.methods[fun.Sel.Name]; ok {
+					res = append(res, w.walk(fd)...)
+				}
+				return true
 			}
-			if len(t.Args) < 1 {
-				return false, nil
+			if !w.coreNames[x.Name] || !createErrorFuncs[fun.Sel.Name] || len(call.Args) < 1 {
+				return true
 			}
-			val, aok := t.Args[0].(*ast.SelectorExpr)
-			if !aok {
-				return false, nil
+			val, ok := call.Args[0].(*ast.SelectorExpr)
+			if !ok {
+				return true
 			}
-			pkg, pok := val.X.(*ast.Ident)
-			if !pok {
-				return false, nil
+			pkg, ok := val.X.(*ast.Ident)
+			if !ok {
+				return true
 			}
-			code := val.Sel.Name
 			res = append(res, ecInfo{
 				pkg:      pkg.Name,
-				name:     code,
-				fullName: fmt.Sprintf("%s.%s", pkg.Name, code),
+				name:     val.Sel.Name,
+				fullName: fmt.Sprintf("%s.%s", pkg.Name, val.Sel.Name),
 			})
-			return true, res
 		}
-	case *ast.ReturnStmt:
-		for _, result := range t.Results {
-			ok, r := iterBodyToGetCreateErrorStmt(result)
-			if ok {
-				res = append(res, r...)
+		return true
+	})
+	return res
+}
+
+// srcEdit 源码插入点
+type srcEdit struct {
+	offset int    // 插入位置 字节偏移
+	end    int    // 替换结束位置 小于等于 offset 时仅插入
+	text   string // 插入内容
+}
+
+// applySrcEdits 按偏移量从后往前插入或替换 避免偏移量失效
+func applySrcEdits(src []byte, edits []srcEdit) []byte {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].offset > edits[j].offset
+	})
+	var res = append([]byte(nil), src...)
+	for _, edit := range edits {
+		if edit.offset < 0 || edit.offset > len(res) || edit.end > len(res) {
+			continue
+		}
+		var end = edit.offset
+		if edit.end > edit.offset {
+			end = edit.end
+		}
+		var buf bytes.Buffer
+		buf.Write(res[:edit.offset])
+		buf.WriteString(edit.text)
+		buf.Write(res[end:])
+		res = buf.Bytes()
+	}
+	return res
+}
+
+// findStructType 查找文件中名为 name 的 struct 定义
+func findStructType(f *ast.File, name string) *ast.StructType {
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts, ok := spec.(*ast.TypeSpec)
+			if !ok || ts.Name.Name != name {
+				continue
+			}
+			if st, ok := ts.Type.(*ast.StructType); ok {
+				return st
 			}
 		}
-	case *ast.IfStmt:
-		ok, r := iterBodyToGetCreateErrorStmt(t.Body)
-		if ok {
-			res = append(res, r...)
+	}
+	return nil
+}
+
+// hasEmbeddedField struct 是否内嵌了名为 name 的类型 支持 pkg.Name 和 *pkg.Name
+func hasEmbeddedField(st *ast.StructType, name string) bool {
+	for _, field := range st.Fields.List {
+		if len(field.Names) != 0 {
+			continue
 		}
-	case *ast.ForStmt:
-		ok, r := iterBodyToGetCreateErrorStmt(t.Body)
-		if ok {
-			res = append(res, r...)
+		typ := field.Type
+		if star, ok := typ.(*ast.StarExpr); ok {
+			typ = star.X
 		}
-	case *ast.SwitchStmt:
-		ok, r := iterBodyToGetCreateErrorStmt(t.Body)
-		if ok {
-			res = append(res, r...)
+		switch t := typ.(type) {
+		case *ast.Ident:
+			if t.Name == name {
+				return true
+			}
+		case *ast.SelectorExpr:
+			if t.Sel.Name == name {
+				return true
+			}
 		}
-	case *ast.SelectStmt:
-		ok, r := iterBodyToGetCreateErrorStmt(t.Body)
-		if ok {
-			res = append(res, r...)
+	}
+	return false
+}
+
+// getRecvTypeName 获取方法接收者的类型名称
+func getRecvTypeName(f *ast.FuncDecl) string {
+	if f.Recv == nil || len(f.Recv.List) == 0 {
+		return ""
+	}
+	typ := f.Recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	if ident, ok := typ.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// receiverFuncNames 获取接收者为 recvName 的所有方法
+func receiverFuncNames(f *ast.File, recvName string) map[string]*ast.FuncDecl {
+	var res = make(map[string]*ast.FuncDecl)
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		if getRecvTypeName(fd) != recvName {
+			continue
 		}
+		res[getFuncDeclName(fd)] = fd
+	}
+	return res
+}
+
+// hasFuncDecl 是否存在名为 name 的函数 不包括方法
+func hasFuncDecl(f *ast.File, name string) bool {
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Recv != nil {
+			continue
+		}
+		if getFuncDeclName(fd) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// missingImportEdit 生成缺失导入包的插入点 插入在 package 声明之后
+func missingImportEdit(fset *token.FileSet, f *ast.File, paths []string) (srcEdit, bool) {
+	var exist = make(map[string]struct{})
+	for _, spec := range f.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		exist[p] = struct{}{}
+	}
+	var missing []string
+	for _, p := range paths {
+		if _, ok := exist[p]; ok {
+			continue
+		}
+		exist[p] = struct{}{}
+		missing = append(missing, strconv.Quote(p))
+	}
+	if len(missing) == 0 {
+		return srcEdit{}, false
+	}
+	return srcEdit{
+		offset: fset.Position(f.Name.End()).Offset,
+		text:   fmt.Sprintf("\n\nimport (\n\t%s\n)", strings.Join(missing, "\n\t")),
+	}, true
+}
+
+// executeTpl 渲染模板
+func executeTpl(name, tpl string, data interface{}) ([]byte, error) {
+	t, err := template.New(name).Parse(tpl)
+	if err != nil {
+		logrus.Errorf("err: %+v", err)
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, data); err != nil {
+		logrus.Errorf("err: %+v", err)
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeGoFile 格式化后写入 go 文件 文件夹不存在则创建
+func writeGoFile(goPath string, src []byte) error {
+	dir := filepath.Dir(goPath)
+	if !IsExist(dir) {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			logrus.Errorf("mkdir err: %+v", err)
+			return err
+		}
+	}
+	formatted, err := format.Source(src)
+	if err != nil {
+		// 格式化失败 原样写入 方便用户排查
+		logrus.Errorf("format %s err: %+v", goPath, err)
+		formatted = src
 	}
-	if len(res) != 0 {
-		return true, res
+	if err := ioutil.WriteFile(goPath, formatted, 0666); err != nil {
+		logrus.Errorf("write file err: %+v", err)
+		return err
 	}
-	return false, nil
+	return nil
 }
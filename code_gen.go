@@ -1,14 +1,18 @@
 package proto_parser
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
-	"regexp"
+	"path/filepath"
 	"strings"
+	"sync"
 
+	"github.com/actorbuf/iota/core"
 	"github.com/elliotchance/pie/pie"
 	"github.com/emicklei/proto"
 	log "github.com/sirupsen/logrus"
@@ -59,14 +63,21 @@ func execGoFmt(genTo string) {
 // 自动注入bson; 自动抽离 model 的字段
 // 自定义注入json; 自定义表名 table_name
 // 自动生成路由->请求结构体映射; 自动生成mongo index
-func CodeGen(config *CodeGenConfig) error {
+// 失败时返回 CodeGenErrors 本次运行的所有诊断 包括宽松模式下的错误 通过 Diagnostics 获取
+func (g *Generator) CodeGen(config *CodeGenConfig) error {
 	if config == nil {
 		return fmt.Errorf("配置项为空")
 	}
 
-	// 预先需要处理的全局变量
-	ModelTplNotGenerateGetScopeFunc = config.NoGetScopeFunc
-	FreqRuleOutput = config.FreqOutput
+	g.reset()
+
+	// 本次运行的配置
+	g.noGetScopeFunc = config.NoGetScopeFunc
+	g.freqRuleOutput = config.FreqOutput
+	g.mergeErrCode = true
+	g.commentRemovedRPC = config.CommentRemovedRPC
+	g.rewriteErrCode = config.RewriteErrorCode
+	g.errCodePkgs = newErrCodePkgCache()
 
 	var pbFileList []string
 	fi, err := os.Stat(config.PbFilePath)
@@ -98,107 +109,353 @@ func CodeGen(config *CodeGenConfig) error {
 
 	pbFileList = pie.Strings(pbFileList).Unique()
 
+	// 旧版本中断后残留的 origin_ 文件 源 proto 已被改写 需先恢复
+	for _, pbPath := range pbFileList {
+		originFile := fmt.Sprintf("%s/origin_%s", path.Dir(pbPath), path.Base(pbPath))
+		if IsExist(originFile) {
+			return fmt.Errorf("found leftover %s, run RecoverOriginProto first", originFile)
+		}
+	}
+
 	if len(pbFileList) == 0 {
 		return nil
 	}
 
-	for _, pbPath := range pbFileList {
-		Visitor = &ProtoVisitor{dbDriver: config.DbDriveType}
-		// 格式化后 转移源文件
-		oriDir := path.Dir(pbPath)
-		oriName := path.Base(pbPath)
-		midName := fmt.Sprintf("%s/origin_%s", oriDir, oriName)
-		if err := renameOriginFile(pbPath, midName); err != nil {
-			log.Errorf("err: %+v", err)
-			return err
+	var results = make([]*protoFileResult, len(pbFileList))
+	for i, pbPath := range pbFileList {
+		results[i] = &protoFileResult{pbPath: pbPath}
+	}
+
+	// 先检查所有文件的注解 有错误时不写入任何文件
+	g.eachProtoFile(config, results, func(child *Generator, res *protoFileResult) {
+		res.err = child.checkProto(res.pbPath)
+		res.routes = child.routeEntries()
+		res.errCodes = child.errCodeFile(res.pbPath)
+	})
+	var errs CodeGenErrors
+	var routeTable = NewRouteTable()
+	for _, res := range results {
+		g.printDiags(res.diags)
+		if res.err != nil {
+			errs = append(errs, &CodeGenError{File: res.pbPath, Err: res.err})
+			continue
+		}
+		// 注解诊断 非宽松模式下有错误则中断
+		var ds = res.diags.Errors()
+		// 所有文件的路由放在同一张表中检查冲突
+		for _, route := range res.routes {
+			if d := routeTable.Add(route); d != nil {
+				g.printDiags(Diagnostics{d})
+				ds = append(ds, d)
+			}
 		}
+		if len(ds) != 0 && !config.Lenient {
+			errs = append(errs, &CodeGenError{File: res.pbPath, Err: ds})
+		}
+	}
 
-		var args []string
+	// 同一目录的错误码合并成一个文件 包含目录下不在本次运行中的 proto 冲突的错误码不会输出
+	errCodePkgs, errCodeDiags, err := g.mergeDirErrCodes(config, results)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range errCodePkgs {
+		for _, pbPath := range pkg.files {
+			ds := errCodeDiags[pbPath]
+			if len(ds) == 0 {
+				continue
+			}
+			g.printDiags(ds)
+			if !config.Lenient {
+				errs = append(errs, &CodeGenError{File: pbPath, Err: ds})
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
 
-		injPath, err := ParseProto(midName)
-		if err != nil {
-			log.Errorf("err: %+v", err)
-			return err
+	g.eachProtoFile(config, results, func(child *Generator, res *protoFileResult) {
+		res.outputPath, res.err = child.genProtoFile(config, res.pbPath, &res.log)
+		res.freqMap = child.Visitor.FreqMap
+	})
+
+	var outputPaths []string
+	var freqMap = make(core.FreqMap)
+	for _, res := range results {
+		g.printDiags(res.diags)
+		if res.err != nil {
+			errs = append(errs, &CodeGenError{File: res.pbPath, Err: res.err})
+			continue
 		}
-		// 格式化工作放在 parse proto 之后
-		_ = format.Format(midName)
+		outputPaths = append(outputPaths, res.outputPath)
+		// 按文件顺序合并 保证输出稳定
+		for key, c := range res.freqMap {
+			freqMap[key] = c
+		}
+	}
 
-		if config.OutputPath == "" {
-			config.OutputPath = path.Dir(injPath)
+	for _, pkg := range errCodePkgs {
+		if err := genErrCodePackage(pkg); err != nil {
+			errs = append(errs, &CodeGenError{File: pkg.dir, Err: err})
 		}
-		goOut := fmt.Sprintf("--go_out=%s", config.OutputPath)
-		args = append(args, goOut)
+	}
 
-		// grpc 开启
-		if config.GrpcOutputPath != "" {
-			grpcOut := fmt.Sprintf("--go-grpc_out=%s", config.GrpcOutputPath)
-			args = append(args, grpcOut)
+	if config.RouteManifest != "" {
+		if err := routeTable.WriteManifest(config.RouteManifest); err != nil {
+			errs = append(errs, &CodeGenError{File: config.RouteManifest, Err: err})
 		}
+	}
 
-		args = append(args, injPath)
+	// 指定了限频输出路径 合并所有文件的限频规则统一输出
+	if g.freqRuleOutput != "" && len(freqMap) != 0 {
+		if err := g.genFreqRuleOutput(freqMap); err != nil {
+			errs = append(errs, &CodeGenError{File: g.freqRuleOutput, Err: err})
+		}
+	}
 
-		var incs []string
-		for _, s := range config.IncludePbFiles {
-			incs = append(incs, fmt.Sprintf("-I%s", s))
+	// 格式化一次 输出路径
+	if config.OutputNeedFormat {
+		for _, outputPath := range pie.Strings(outputPaths).Unique().Sort() {
+			execGoFmt(outputPath)
 		}
+	}
 
-		args = append(args, incs...)
+	if len(errs) != 0 {
+		return errs
+	}
 
-		protocCmd := exec.Command("protoc", args...)
-		_, _ = fmt.Fprintf(os.Stdout, "run cmd: %s\n", protocCmd.String())
-		protocOut, err := protocCmd.CombinedOutput()
-		if err != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "run err: %+v\nrun out: %+v\n", err, string(protocOut))
-			_ = removeInjectBsonFile(injPath)
-			return err
+	return nil
+}
+
+// CodeGenError 单个 proto 文件生成失败的错误
+type CodeGenError struct {
+	File string
+	Err  error
+}
+
+func (e *CodeGenError) Error() string {
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+func (e *CodeGenError) Unwrap() error {
+	return e.Err
+}
+
+// CodeGenErrors 批量生成时汇总的错误 按文件顺序排列
+type CodeGenErrors []*CodeGenError
+
+func (es CodeGenErrors) Error() string {
+	var lines = make([]string, 0, len(es))
+	for _, e := range es {
+		lines = append(lines, e.Error())
+	}
+	return fmt.Sprintf("%d file(s) generate failed:\n%s", len(es), strings.Join(lines, "\n"))
+}
+
+// Diagnostics 汇总所有文件的注解诊断
+func (es CodeGenErrors) Diagnostics() Diagnostics {
+	var res Diagnostics
+	for _, e := range es {
+		if ds, ok := e.Err.(Diagnostics); ok {
+			res = append(res, ds...)
 		}
+	}
+	return res
+}
+
+// protoFileResult 单个 proto 文件的生成结果
+type protoFileResult struct {
+	pbPath     string
+	outputPath string
+	freqMap    core.FreqMap
+	routes     []*RouteEntry
+	errCodes   *errCodeFile
+	diags      Diagnostics
+	log        bytes.Buffer
+	err        error
+}
 
-		baseName := path.Base(injPath)
-		filePrefix := baseName[0 : len(baseName)-len(path.Ext(injPath))]
-		pbCodeFile := fmt.Sprintf("%s/%s.pb.go", path.Dir(injPath), filePrefix)
+// eachProtoFile 对每个文件使用独立的 Generator 执行 fn 日志与诊断按文件顺序收集
+// config.Concurrency 大于1时并发执行 源 proto 不会被改写 文件之间只需要对共同的 @gen_to 文件加锁
+func (g *Generator) eachProtoFile(config *CodeGenConfig, results []*protoFileResult, fn func(child *Generator, res *protoFileResult)) {
+	var parallel = config.Concurrency > 1 && len(results) > 1
+	if parallel && g.fileLocker == nil {
+		g.fileLocker = newFileLocker()
+	}
+	var run = func(res *protoFileResult) {
+		child := g.fork()
+		child.Visitor = &ProtoVisitor{dbDriver: config.DbDriveType, IncludePaths: config.IncludePbFiles}
+		res.log.Reset()
+		fn(child, res)
+		res.diags = child.diags
+	}
 
-		target := fmt.Sprintf("-input=%s", pbCodeFile)
-		args = []string{target}
-		injTagCmd := exec.Command("protoc-go-inject-tag", args...)
-		_, _ = fmt.Fprintf(os.Stdout, "run cmd: %s\n", injTagCmd.String())
-		injTagOut, err := injTagCmd.CombinedOutput()
-		if err != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "run err: %v\nrun out: %v\n", err, string(injTagOut))
-			_ = removeInjectBsonFile(injPath)
-			return err
+	if !parallel {
+		for _, res := range results {
+			run(res)
+			_, _ = os.Stdout.Write(res.log.Bytes())
 		}
+		return
+	}
 
-		_ = removeInjectBsonFile(injPath)
-		_, _ = fmt.Fprintf(os.Stdout, "%s code generate complete!\n", pbPath)
+	var wg sync.WaitGroup
+	var sem = make(chan struct{}, config.Concurrency)
+	for _, res := range results {
+		wg.Add(1)
+		go func(res *protoFileResult) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			run(res)
+		}(res)
 	}
+	wg.Wait()
 
-	// 格式化一次 输出路径
-	if config.OutputNeedFormat {
-		execGoFmt(config.OutputPath)
+	// 按文件顺序输出日志
+	for _, res := range results {
+		_, _ = os.Stdout.Write(res.log.Bytes())
 	}
+}
 
-	return nil
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
 }
 
-func removeInjectBsonFile(src string) error {
-	// 将中间文件删除
-	if err := os.Remove(src); err != nil {
-		return err
+// genProtoFile 生成单个 proto 文件 返回代码输出路径
+func (g *Generator) genProtoFile(config *CodeGenConfig, pbPath string, out io.Writer) (string, error) {
+	// 注入后的 proto 写入临时目录 源文件保持不变
+	tmpDir, err := ioutil.TempDir("", "proto_parser_")
+	if err != nil {
+		log.Errorf("err: %+v", err)
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	virtualPath := protoVirtualPath(pbPath, config.IncludePbFiles)
+	injPath := filepath.Join(tmpDir, virtualPath)
+	if err := g.parseProtoTo(pbPath, injPath); err != nil {
+		log.Errorf("err: %+v", err)
+		return "", err
 	}
-	// 将源文件名复原
-	dir := path.Dir(src)
-	base := path.Base(src)
-	oldFile := fmt.Sprintf("%s/origin_%s", dir, base)
 
-	return rename.Atomic(oldFile, src)
+	outputPath := config.OutputPath
+	if outputPath == "" {
+		outputPath = path.Dir(pbPath)
+	}
+
+	// 临时目录放在最前 保证注入后的 proto 优先于源文件被找到
+	var importPaths = append([]string{tmpDir}, config.IncludePbFiles...)
+	// 未指定 include 时保持 protoc 默认的当前目录
+	if len(config.IncludePbFiles) == 0 {
+		importPaths = append(importPaths, ".")
+	}
+
+	if config.NativeCompile {
+		err = nativeCompile(config, importPaths, virtualPath, outputPath, out)
+	} else {
+		err = runProtoc(config, importPaths, injPath, outputPath, out)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	baseName := path.Base(pbPath)
+	filePrefix := baseName[0 : len(baseName)-len(path.Ext(pbPath))]
+	pbCodeFile := fmt.Sprintf("%s/%s.pb.go", path.Dir(pbPath), filePrefix)
+
+	_, _ = fmt.Fprintf(out, "inject tag: %s\n", pbCodeFile)
+	if err := injectGoTags(pbCodeFile); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "inject tag err:\n%v\n", err)
+		return "", err
+	}
+
+	_, _ = fmt.Fprintf(out, "%s code generate complete!\n", pbPath)
+	return outputPath, nil
 }
 
-func renameOriginFile(src string, dst string) error {
-	return rename.Atomic(src, dst)
+// RecoverOriginProto 恢复旧版本生成中断后残留的 origin_*.proto
+// origin_ 文件是用户的源文件 同名 proto 是注入后的中间文件 恢复时覆盖中间文件
+// 返回恢复的源文件列表
+func RecoverOriginProto(dir string) ([]string, error) {
+	var recovered []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, "origin_") || !strings.HasSuffix(name, ".proto") {
+			return nil
+		}
+		src := filepath.Join(filepath.Dir(p), strings.TrimPrefix(name, "origin_"))
+		if err := rename.Atomic(p, src); err != nil {
+			log.Errorf("recover %s err: %+v", p, err)
+			return err
+		}
+		_, _ = fmt.Fprintf(os.Stdout, "recover %s -> %s\n", p, src)
+		recovered = append(recovered, src)
+		return nil
+	})
+	if err != nil {
+		return recovered, err
+	}
+	return recovered, nil
+}
+
+// runProtoc 调用 protoc 生成 .pb.go
+func runProtoc(config *CodeGenConfig, importPaths []string, injPath, outputPath string, out io.Writer) error {
+	var args []string
+
+	goOut := fmt.Sprintf("--go_out=%s", outputPath)
+	args = append(args, goOut)
+
+	// grpc 开启
+	if config.GrpcOutputPath != "" {
+		grpcOut := fmt.Sprintf("--go-grpc_out=%s", config.GrpcOutputPath)
+		args = append(args, grpcOut)
+	}
+
+	args = append(args, injPath)
+
+	var incs []string
+	for _, s := range importPaths {
+		incs = append(incs, fmt.Sprintf("-I%s", s))
+	}
+
+	args = append(args, incs...)
+
+	protocCmd := exec.Command("protoc", args...)
+	_, _ = fmt.Fprintf(out, "run cmd: %s\n", protocCmd.String())
+	protocOut, err := protocCmd.CombinedOutput()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "run err: %+v\nrun out: %+v\n", err, string(protocOut))
+		return fmt.Errorf("protoc: %w: %s", err, strings.TrimSpace(string(protocOut)))
+	}
+	return nil
+}
+
+// protoVirtualPath proto 文件相对 include 路径的文件名 与 protoc 中的文件名保持一致
+func protoVirtualPath(pbPath string, includes []string) string {
+	if len(includes) == 0 {
+		includes = []string{"."}
+	}
+	abs := absPath(pbPath)
+	for _, inc := range includes {
+		rel, err := filepath.Rel(absPath(inc), abs)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.Base(pbPath)
 }
 
 // AddAPI 生成一个API 并自动生成Req/Resp
-func AddAPI(pbFile, groupRouter, apiName, method string) error {
+func (g *Generator) AddAPI(pbFile, groupRouter, apiName, method string) error {
+	g.reset()
+
 	reader, err := os.Open(pbFile)
 	if err != nil {
 		log.Errorf("open pb file: %+v, get err: %+v", pbFile, err)
@@ -213,11 +470,11 @@ func AddAPI(pbFile, groupRouter, apiName, method string) error {
 		return err
 	}
 
-	proto.Walk(definition, proto.WithService(addAPI))
+	proto.Walk(definition, proto.WithService(g.addAPI))
 
 	var srv *proto.Service
 	var existSrv bool
-	if srv, existSrv = Visitor.APIGroupSrvMap[groupRouter]; !existSrv {
+	if srv, existSrv = g.Visitor.APIGroupSrvMap[groupRouter]; !existSrv {
 		return fmt.Errorf("group router name: %s not found", groupRouter)
 	}
 
@@ -270,48 +527,16 @@ func AddAPI(pbFile, groupRouter, apiName, method string) error {
 	return nil
 }
 
-func addAPI(srv *proto.Service) {
-	var needGen bool
-	if strings.HasSuffix(srv.Name, NameAPIGroup) {
-		needGen = true
-	}
-	if !needGen {
-		if srv.Comment == nil {
-			return
-		}
-
-		if len(srv.Comment.Lines) == 0 {
-			return
-		}
-
-		var doc = srv.Comment.Lines
-
-		for _, com := range doc {
-			var gr = regexp.MustCompile(RegexpGroupRouter)
-			res := gr.FindAllStringSubmatch(com, -1)
-			if len(res) == 0 {
-				continue
-			}
-			if len(res[0]) != 2 {
-				continue
-			}
-
-			if res[0][1] != "true" {
-				return
-			}
-
-			// 是一个路由组
-			needGen = true
-		}
-	}
-
-	if needGen {
-		Visitor.AddApiSrv(srv.Name, srv)
+func (g *Generator) addAPI(srv *proto.Service) {
+	if isRouteGroup(srv) {
+		g.Visitor.AddApiSrv(srv.Name, srv)
 	}
 }
 
 // AddRPC 生成一个RPC 并自动生成Req/Resp
-func AddRPC(pbFile, srvName, apiName string) error {
+func (g *Generator) AddRPC(pbFile, srvName, apiName string) error {
+	g.reset()
+
 	reader, err := os.Open(pbFile)
 	if err != nil {
 		log.Errorf("open pb file: %+v, get err: %+v", pbFile, err)
@@ -326,11 +551,11 @@ func AddRPC(pbFile, srvName, apiName string) error {
 		return err
 	}
 
-	proto.Walk(definition, proto.WithService(addRPC))
+	proto.Walk(definition, proto.WithService(g.addRPC))
 
 	var srv *proto.Service
 	var existSrv bool
-	if srv, existSrv = Visitor.SrvMap[srvName]; !existSrv {
+	if srv, existSrv = g.Visitor.SrvMap[srvName]; !existSrv {
 		return fmt.Errorf("service name: %s not found", srvName)
 	}
 
@@ -369,6 +594,6 @@ func AddRPC(pbFile, srvName, apiName string) error {
 	return nil
 }
 
-func addRPC(srv *proto.Service) {
-	Visitor.AddSrv(srv.Name, srv)
+func (g *Generator) addRPC(srv *proto.Service) {
+	g.Visitor.AddSrv(srv.Name, srv)
 }
@@ -1,11 +1,17 @@
 package proto_parser
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"text/scanner"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	format "github.com/actorbuf/proto-format"
@@ -59,7 +65,7 @@ func TestOutputMD(t *testing.T) {
 }
 
 func TestParseGoFile(t *testing.T) {
-	var ag = new(AstTree)
+	var ag = &AstTree{gen: NewGenerator()}
 	err := ag.parseGoFile("./freq_controller.go", "Freq", "", nil)
 	if err != nil {
 		panic(err)
@@ -104,3 +110,277 @@ func TestAddTask(t *testing.T) {
 		panic(err)
 	}
 }
+
+func TestRecoverOriginProto(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_ = os.MkdirAll(filepath.Join(dir, "sub"), os.ModePerm)
+	_ = ioutil.WriteFile(filepath.Join(dir, "sub", "origin_a.proto"), []byte("source"), 0666)
+	_ = ioutil.WriteFile(filepath.Join(dir, "sub", "a.proto"), []byte("injected"), 0666)
+
+	recovered, err := RecoverOriginProto(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 1 {
+		t.Fatalf("expect 1 recovered file, got %v", recovered)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "sub", "a.proto"))
+	if string(data) != "source" || IsExist(filepath.Join(dir, "sub", "origin_a.proto")) {
+		t.Errorf("origin file not recovered")
+	}
+}
+
+func TestProtoVirtualPath(t *testing.T) {
+	if p := protoVirtualPath("./proto/code.proto", nil); p != "proto/code.proto" {
+		t.Errorf("unexpected path: %s", p)
+	}
+	if p := protoVirtualPath("./proto/code.proto", []string{"./proto"}); p != "code.proto" {
+		t.Errorf("unexpected path: %s", p)
+	}
+}
+
+// writeCodeGenFixture 写入 n 个带路由组与限频注解的 proto broken 中的文件引用了不存在的类型
+func writeCodeGenFixture(t *testing.T, dir string, n int, broken ...int) {
+	for i := 0; i < n; i++ {
+		var reqType = fmt.Sprintf("Get%dReq", i)
+		for _, b := range broken {
+			if b == i {
+				reqType = "Missing"
+			}
+		}
+		src := fmt.Sprintf(`syntax = "proto3";
+package svc;
+option go_package = "./;svc";
+
+message Get%[1]dReq {
+    int64 id = 1;
+}
+
+message Get%[1]dResp {
+    string name = 1;
+}
+
+// @route_group: true
+// @route_api: /api/s%[1]d
+// @gen_to: %[3]s
+service S%[1]dAPI {
+    // @desc: 获取
+    // @method: GET
+    // @api: /get/:id
+    // @freq: %[1]d 10 100
+    rpc Get (%[2]s) returns (Get%[1]dResp);
+}
+`, i, reqType, filepath.Join(dir, "controller", fmt.Sprintf("s%d.go", i)))
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("s%d.proto", i)), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readGenOutput 读取目录下生成的文件 内容中的目录替换掉 便于比较不同目录的输出
+func readGenOutput(t *testing.T, dir string) map[string]string {
+	var res = make(map[string]string)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || path.Ext(p) == ".proto" {
+			return err
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		res[rel] = strings.ReplaceAll(string(data), dir, "")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestCodeGenConcurrency(t *testing.T) {
+	var outputs []map[string]string
+	for _, concurrency := range []int{1, 4} {
+		dir := t.TempDir()
+		writeCodeGenFixture(t, dir, 6)
+		freqDir := filepath.Join(dir, "freq")
+		if err := os.MkdirAll(freqDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		err := NewGenerator().CodeGen(&CodeGenConfig{
+			PbFilePath:     dir,
+			IncludePbFiles: []string{dir},
+			NativeCompile:  true,
+			Concurrency:    concurrency,
+			FreqOutput:     freqDir,
+			RouteManifest:  filepath.Join(dir, "routes.csv"),
+		})
+		if err != nil {
+			t.Fatalf("concurrency %d: %v", concurrency, err)
+		}
+		outputs = append(outputs, readGenOutput(t, dir))
+	}
+
+	// 并发生成与逐个生成的输出一致
+	serial, parallel := outputs[0], outputs[1]
+	for _, name := range []string{"s0.pb.go", "s5.pb.go", "autogen_router_s0.go", "controller/s5.go", "routes.csv", "freq/freq_rule.go"} {
+		if _, ok := serial[name]; !ok {
+			t.Errorf("missing output %s", name)
+		}
+	}
+	if len(serial) != len(parallel) {
+		t.Errorf("expect %d files, got %d", len(serial), len(parallel))
+	}
+	for name, data := range serial {
+		if parallel[name] != data {
+			t.Errorf("%s differs between serial and parallel generation", name)
+		}
+	}
+}
+
+func TestCodeGenConcurrencyErrors(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		dir := t.TempDir()
+		writeCodeGenFixture(t, dir, 5, 1, 3)
+		err := NewGenerator().CodeGen(&CodeGenConfig{
+			PbFilePath:     dir,
+			IncludePbFiles: []string{dir},
+			NativeCompile:  true,
+			Concurrency:    concurrency,
+		})
+
+		// 两种模式都生成其余的文件 并按文件顺序汇总错误
+		var errs CodeGenErrors
+		if !errors.As(err, &errs) || len(errs) != 2 {
+			t.Fatalf("concurrency %d: expect 2 errors, got: %v", concurrency, err)
+		}
+		if path.Base(errs[0].File) != "s1.proto" || path.Base(errs[1].File) != "s3.proto" {
+			t.Errorf("concurrency %d: unexpected error order: %v", concurrency, errs)
+		}
+		for _, name := range []string{"s0.pb.go", "s2.pb.go", "s4.pb.go"} {
+			if !IsExist(filepath.Join(dir, name)) {
+				t.Errorf("concurrency %d: missing %s", concurrency, name)
+			}
+		}
+	}
+}
+
+func TestEachProtoFileBounded(t *testing.T) {
+	var results = make([]*protoFileResult, 8)
+	for i := range results {
+		results[i] = &protoFileResult{pbPath: fmt.Sprintf("%d.proto", i)}
+	}
+	var running, peak int32
+	NewGenerator().eachProtoFile(&CodeGenConfig{Concurrency: 3}, results, func(child *Generator, res *protoFileResult) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		child.warnf(scanner.Position{Filename: res.pbPath}, "done")
+	})
+	if peak > 3 || peak < 2 {
+		t.Errorf("expect at most 3 workers, got %d", peak)
+	}
+	// 诊断按文件收集
+	for _, res := range results {
+		if len(res.diags) != 1 || res.diags[0].Pos.Filename != res.pbPath {
+			t.Errorf("unexpected diagnostics for %s: %v", res.pbPath, res.diags)
+		}
+	}
+}
+
+func TestCodeGenKeepSourceProto(t *testing.T) {
+	dir := t.TempDir()
+	writeCodeGenFixture(t, dir, 2)
+	model := `syntax = "proto3";
+package svc;
+option go_package = "./;svc";
+
+// @table_name: user
+message ModelUser {
+    // @index: user_name asc
+    string name = 1;
+    // @json: user_age
+    int64 age = 2;
+}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "model.proto"), []byte(model), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := readProtoFiles(t, dir)
+
+	// 第二次生成时路由组已有实现 源文件仍保持不变
+	for i := 0; i < 2; i++ {
+		err := NewGenerator().CodeGen(&CodeGenConfig{
+			PbFilePath:     dir,
+			IncludePbFiles: []string{dir},
+			NativeCompile:  true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		after := readProtoFiles(t, dir)
+		for name, data := range before {
+			if after[name] != data {
+				t.Errorf("run %d: source %s changed:\n%s", i, name, after[name])
+			}
+		}
+	}
+}
+
+func TestCodeGenRewriteErrorCode(t *testing.T) {
+	dir := t.TempDir()
+	writeCodeGenFixture(t, dir, 1)
+	config := &CodeGenConfig{PbFilePath: dir, IncludePbFiles: []string{dir}, NativeCompile: true}
+	if err := NewGenerator().CodeGen(config); err != nil {
+		t.Fatal(err)
+	}
+	goFile := filepath.Join(dir, "controller", "s0.go")
+	data, err := ioutil.ReadFile(goFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.Replace(string(data), "return resp, nil", "return resp, core.CreateError(svc.ErrNotFound)", 1))
+	if err := ioutil.WriteFile(goFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 默认不改写源文件
+	before := readProtoFiles(t, dir)
+	if err := NewGenerator().CodeGen(config); err != nil {
+		t.Fatal(err)
+	}
+	if after := readProtoFiles(t, dir); after["s0.proto"] != before["s0.proto"] {
+		t.Errorf("source changed without RewriteErrorCode:\n%s", after["s0.proto"])
+	}
+
+	config.RewriteErrorCode = true
+	if err := NewGenerator().CodeGen(config); err != nil {
+		t.Fatal(err)
+	}
+	if after := readProtoFiles(t, dir); !strings.Contains(after["s0.proto"], "ErrNotFound") {
+		t.Errorf("expect @error to be rewritten:\n%s", after["s0.proto"])
+	}
+}
+
+func readProtoFiles(t *testing.T, dir string) map[string]string {
+	var res = make(map[string]string)
+	for _, pbPath := range listProtoFile(dir) {
+		data, err := ioutil.ReadFile(pbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[path.Base(pbPath)] = string(data)
+	}
+	return res
+}
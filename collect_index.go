@@ -1,115 +1,201 @@
 package proto_parser
 
 import (
-	"github.com/emicklei/proto"
-	"github.com/sirupsen/logrus"
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
+	"text/scanner"
+
+	"github.com/emicklei/proto"
+	"github.com/sirupsen/logrus"
 )
 
 // injectValidatorTag 注入tag
-func collectIndex(msg *proto.Message) {
+func (g *Generator) collectIndex(msg *proto.Message) {
 	if !strings.HasPrefix(msg.Name, NameModel) {
 		return
 	}
+	g.collectMessageIndex(msg.Name, "", msg, map[*proto.Message]bool{msg: true}, make(map[indexLine]string))
+}
 
+// indexLine 注释中的一行索引注解
+type indexLine struct {
+	comment *proto.Comment
+	line    int
+}
+
+// collectMessageIndex 收集 message 字段上声明的索引 字段名取注入后的 bson 字段名
+// mongodb 的 model 继续收集子文档字段上的索引 路径用 . 连接 visited 防止递归定义
+// 同一个子文档被多个字段引用时 其中的索引注解会按不同路径收集到同名索引里 此时报错 paths 记录注解第一次收集时的路径
+func (g *Generator) collectMessageIndex(modelName, prefix string, msg *proto.Message, visited map[*proto.Message]bool, paths map[indexLine]string) {
 	for _, element := range msg.Elements {
-		if field, ok := element.(*proto.NormalField); ok {
-			if field.Comment == nil {
-				continue
-			}
-			if err := doCollectIndex(field.Name, field.Comment.Lines); err != nil {
-				logrus.Errorf("collect index err: %+v", err)
-			}
+		field, ok := element.(*proto.NormalField)
+		if !ok {
+			continue
+		}
+		path := prefix + g.bsonFieldName(field)
+		if field.Comment != nil {
+			g.doCollectIndex(modelName, path, field.Comment, paths)
 		}
 
-		// 目前先不支持设置子文档索引
-		//if field, ok := element.(*proto.Message); ok {
-		//	collectIndex(field)
-		//}
+		if g.Visitor.dbDriver == "gdbc" || isBuiltInType(field.Type) {
+			continue
+		}
+		if pm := g.fieldMessage(msg, field.Type); pm != nil && !visited[pm] {
+			visited[pm] = true
+			g.collectMessageIndex(modelName, path+".", pm, visited, paths)
+			delete(visited, pm)
+		}
 	}
 }
 
-// doCollectIndex todo 取bson字段的field才行
-func doCollectIndex(fieldName string, doc []string) error {
-	if len(doc) == 0 {
-		return nil
+var (
+	indexReg       = regexp.MustCompile(`@(index|unique_index):\s*([\w]{5,})\s+(?i:(asc|desc|text|2dsphere|hashed))\b(.*)`)
+	ttlIndexReg    = regexp.MustCompile(`@ttl_index:\s*([\w]{5,})\s+(?i:(asc|desc))\s+(\d*)(.*)`)
+	indexUsage     = map[string]string{"index": "@index: 索引名 asc|desc|text|2dsphere|hashed [选项]", "unique_index": "@unique_index: 索引名 asc|desc [选项]"}
+	ttlIndexUsage  = "@ttl_index: 索引名 asc|desc 秒数 [选项]"
+	indexSortValue = map[string]int{"asc": 1, "desc": -1}
+)
+
+// doCollectIndex 收集字段注释中声明的索引 fieldName 为字段的 bson 路径
+// 索引名后可以跟选项: sparse partial:{JSON} collation:语言[:强度]
+// 同名索引的类型 ttl 与选项前后不一致时按行报错 继续收集其他注解
+func (g *Generator) doCollectIndex(modelName, fieldName string, comment *proto.Comment, paths map[indexLine]string) {
+	if comment == nil || len(comment.Lines) == 0 {
+		return
 	}
 
-	for _, d := range doc {
-		if strings.Contains(d, "@index:") {
-			var reg = regexp.MustCompile(`@index:\s*([\w]{5,})\s+(asc|desc|ASC|DESC)`)
-			rules := reg.FindAllStringSubmatch(d, -1)
-			if len(rules) > 0 && len(rules[0]) == 3 {
-				res := rules[0]
-				if strings.ToLower(res[2]) == "asc" {
-					if err := Visitor.AddIndexField(res[1], &IndexField{
-						Field: fieldName,
-						Sort:  1,
-					}); err != nil {
-						return err
-					}
-					continue
+	for i, d := range comment.Lines {
+		var (
+			name, kind, rest string
+			ttl              int64
+			add              func() error
+		)
+		var field = &IndexField{Field: fieldName, Sort: 1}
+		switch {
+		case strings.Contains(d, "@index:"), strings.Contains(d, "@unique_index:"):
+			res := indexReg.FindStringSubmatch(d)
+			if len(res) == 0 {
+				var typ = "index"
+				if strings.Contains(d, "@unique_index:") {
+					typ = "unique_index"
 				}
-				if err := Visitor.AddIndexField(res[1], &IndexField{
-					Field: fieldName,
-					Sort:  -1,
-				}); err != nil {
-					return err
+				g.indexAnnotationErr(commentLinePos(comment, i), fieldName, d, indexUsage[typ])
+				continue
+			}
+			name, kind, rest = res[2], strings.ToLower(res[3]), res[4]
+			if res[1] == "unique_index" {
+				if _, ok := indexSortValue[kind]; !ok {
+					g.errorf(commentLinePos(comment, i), "field %s: %s 索引不能是唯一索引: %s", fieldName, kind, name)
+					continue
 				}
+				add = func() error { return g.Visitor.AddUniqueIndexField(modelName, name, field) }
+			} else {
+				add = func() error { return g.Visitor.AddIndexField(modelName, name, field) }
 			}
+		case strings.Contains(d, "@ttl_index:"):
+			res := ttlIndexReg.FindStringSubmatch(d)
+			if len(res) == 0 {
+				g.indexAnnotationErr(commentLinePos(comment, i), fieldName, d, ttlIndexUsage)
+				continue
+			}
+			name, kind, ttl, rest = res[1], strings.ToLower(res[2]), string2int(res[3]), res[4]
+			add = func() error { return g.Visitor.AddTTLIndexField(modelName, name, ttl, field) }
+		default:
+			continue
+		}
+		if first, ok := paths[indexLine{comment, i}]; ok && first != fieldName {
+			g.errorf(commentLinePos(comment, i), "field %s: 子文档的索引 %s 已按 %s 收集 子文档被多个字段引用时请在 model 中分别声明索引", fieldName, name, first)
 			continue
 		}
+		paths[indexLine{comment, i}] = fieldName
 
-		if strings.Contains(d, "@unique_index:") {
-			var reg = regexp.MustCompile(`@unique_index:\s*([\w]{5,})\s+(asc|desc|ASC|DESC)`)
-			rules := reg.FindAllStringSubmatch(d, -1)
-			if len(rules) > 0 && len(rules[0]) == 3 {
-				res := rules[0]
-				if strings.ToLower(res[2]) == "asc" {
-					if err := Visitor.AddUniqueIndexField(res[1], &IndexField{
-						Field: fieldName,
-						Sort:  1,
-					}); err != nil {
-						return err
-					}
-					continue
-				}
-				if err := Visitor.AddUniqueIndexField(res[1], &IndexField{
-					Field: fieldName,
-					Sort:  -1,
-				}); err != nil {
-					return err
-				}
+		if sort, ok := indexSortValue[kind]; ok {
+			field.Sort = sort
+		} else {
+			field.Kind = kind
+		}
+		opt, unknown, err := parseIndexOptions(rest)
+		if err != nil {
+			g.errorf(commentLinePos(comment, i), "field %s: 索引 %s 的选项错误: %v", fieldName, name, err)
+			continue
+		}
+		if len(unknown) != 0 {
+			g.warnf(commentLinePos(comment, i), "field %s: 忽略索引 %s 不认识的选项 %s", fieldName, name, strings.Join(unknown, " "))
+		}
+		if err := add(); err != nil {
+			g.errorf(commentLinePos(comment, i), "field %s: 索引 %s 与之前的定义冲突: %v", fieldName, name, err)
+			continue
+		}
+		if err := g.Visitor.SetIndexOptions(modelName, name, opt); err != nil {
+			g.errorf(commentLinePos(comment, i), "field %s: 索引 %s 的选项与之前的定义冲突: %v", fieldName, name, err)
+		}
+	}
+}
+
+// parseIndexOptions 解析索引注解末尾的选项 返回不认识的选项
+func parseIndexOptions(s string) (*IndexOptions, []string, error) {
+	var opt = &IndexOptions{}
+	var unknown []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		// partial 的 JSON 中可以有空格 按 JSON 读取一个值
+		if strings.HasPrefix(s, "partial:") {
+			var raw json.RawMessage
+			dec := json.NewDecoder(strings.NewReader(s[len("partial:"):]))
+			if err := dec.Decode(&raw); err != nil || raw[0] != '{' {
+				return nil, nil, fmt.Errorf("partial 需要 JSON 对象: %s", s)
+			}
+			var buf bytes.Buffer
+			if err := json.Compact(&buf, raw); err != nil {
+				return nil, nil, err
 			}
+			opt.PartialFilter = buf.String()
+			s = s[len("partial:")+int(dec.InputOffset()):]
 			continue
 		}
-		if strings.Contains(d, "@ttl_index:") {
-			var reg = regexp.MustCompile(`@ttl_index:\s*([\w]{5,})\s+(asc|desc|ASC|DESC)\s+(\d*)`)
-			rules := reg.FindAllStringSubmatch(d, -1)
-			if len(rules) > 0 && len(rules[0]) == 4 {
-				res := rules[0]
-				if strings.ToLower(res[2]) == "asc" {
-					if err := Visitor.AddTTLIndexField(res[1], string2int(res[3]), &IndexField{
-						Field: fieldName,
-						Sort:  1,
-					}); err != nil {
-						return err
-					}
-					continue
-				}
-				if err := Visitor.AddTTLIndexField(res[1], string2int(res[3]), &IndexField{
-					Field: fieldName,
-					Sort:  -1,
-				}); err != nil {
-					return err
+
+		var tok = s
+		if i := strings.IndexAny(s, " \t"); i >= 0 {
+			tok, s = s[:i], s[i:]
+		} else {
+			s = ""
+		}
+		switch {
+		case tok == "sparse":
+			opt.Sparse = true
+		case strings.HasPrefix(tok, "collation:"):
+			arr := strings.SplitN(strings.TrimPrefix(tok, "collation:"), ":", 2)
+			if arr[0] == "" {
+				return nil, nil, fmt.Errorf("collation 需要语言: %s", tok)
+			}
+			opt.Collation = &IndexCollation{Locale: arr[0]}
+			if len(arr) == 2 {
+				strength, err := strconv.Atoi(arr[1])
+				if err != nil || strength < 1 || strength > 5 {
+					return nil, nil, fmt.Errorf("collation 的强度应为 1-5: %s", tok)
 				}
+				opt.Collation.Strength = strength
 			}
-			continue
+		default:
+			unknown = append(unknown, tok)
 		}
 	}
-	return nil
+	return opt, unknown, nil
+}
+
+var indexNameReg = regexp.MustCompile(`@(?:index|unique_index|ttl_index):\s*(\S*)`)
+
+// indexAnnotationErr 索引注解格式错误 区分索引名不合法与其他格式问题
+func (g *Generator) indexAnnotationErr(pos scanner.Position, fieldName, d, usage string) {
+	res := indexNameReg.FindStringSubmatch(d)
+	if len(res) == 2 && !regexp.MustCompile(`^\w{5,}$`).MatchString(res[1]) {
+		g.errorf(pos, "field %s: 索引名 %q 不合法 至少5个字符且只能包含字母数字下划线", fieldName, res[1])
+		return
+	}
+	g.errorf(pos, "field %s: 索引格式错误 应为 %s: %s", fieldName, usage, trim(d))
 }
 
 func string2int(src string) int64 {
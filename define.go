@@ -1,15 +1,5 @@
 package proto_parser
 
-// 全局变量相关
-var (
-	// ModelTplNotGenerateGetScopeFunc 控制 ModelTpl 模板不生成 GetScope 函数
-	ModelTplNotGenerateGetScopeFunc bool
-	// FreqRuleOutput 限频规则输出路径
-	FreqRuleOutput string
-	// PbFilePath proto 文件位置
-	PbFilePath string
-)
-
 // Message 名字前后缀相关
 const (
 	ErrCodeName  = "ErrCode"
@@ -24,7 +14,7 @@ const (
 const (
 	RegexpBson              = "@bson:(\\s)*([a-zA-Z0-9_-]+)"
 	RegexpJson              = "@json:[\\s]*([a-zA-Z0-9_-]+)"
-	RegexpGorm              = "@gorm:[\\s]*([a-zA-Z0-9_\\-:<>;\\(\\)\\s]+)"
+	RegexpGorm              = "@gorm:[\\s]*(.+)"
 	RegexpJsonStyle         = "@json_style:\\s*([a-zA-Z0-9_]+)"
 	RegexpGroupRouter       = "@route_group:\\s*([\\w]*)"
 	RegexpGroupRouterAPI    = "@route_api:\\s*([\\w|/]*)"
@@ -32,7 +22,7 @@ const (
 	RegexpRouterRpcAuthor   = "@author:\\s*(.*)"
 	RegexpRouterRpcDesc     = "@desc:\\s*(.*)"
 	RegexpRouterRpcMethod   = "@method:\\s*([\\w]*)"
-	RegexpRouterRpcURL      = "@api:\\s*([\\w|/]*)"
+	RegexpRouterRpcURL      = "@api:\\s*([\\w|/|:|\\*]*)"
 	RegexpAddModel          = "@model:\\s*true"
 	RegexpMiddlewareContent = "@middleware:[\\s]*([^\\s].*)"
 	RegexpMiddlewareFunc    = "([a-zA-Z0-9_/\\-]*)\\[(.*?)*\\]"
@@ -42,7 +32,13 @@ const (
 	RegexpTaskTimeSpec      = "@t:\\s*(.*)"
 	RegexpTaskTimes         = "@times:\\s*(\\d*)"
 	RegexpTaskRange         = "@range:\\s*([\\d]* [\\d]*)"
-	RegexpTaskType          = "@type:\\s*(\\d)"
+	RegexpTaskType          = "@type:\\s*(\\d+)"
+	RegexpExample           = "@example:\\s*(.*)"
+	RegexpErrHTTP           = "@http:\\s*(\\d+)"
+	RegexpErrGRPC           = "@grpc:\\s*([a-zA-Z_]+)"
+	RegexpErrMsg            = "@msg_([a-zA-Z_]+):\\s*(.*)"
+	RegexpErrCodeRange      = "@errcode_range:\\s*(\\d+)\\s+(\\d+)"
+	RegexpSQLType           = "@sql_type:\\s*(.+)"
 )
 
 type ModelFieldStruct struct {
@@ -52,12 +48,67 @@ type ModelFieldStruct struct {
 }
 
 type CodeGenConfig struct {
-	PbFilePath       string   // 需要生成的proto文件路径 支持目录但不支持正则
-	OutputPath       string   // 代码需要生成到什么位置
-	GrpcOutputPath   string   // grpc代码需要生成到什么位置
-	IncludePbFiles   []string // 生成的代码引用到的其他proto文件列表
-	OutputNeedFormat bool     // 生成位置的代码是否需要用 gofmt 格式化一下
-	NoGetScopeFunc   bool     // 生成的代码不要包含 mdbc 的 GetScope 函数
-	DbDriveType      string   // 数据库驱动类型
-	FreqOutput       string   // 限频文件输出路径
+	PbFilePath        string   // 需要生成的proto文件路径 支持目录但不支持正则
+	OutputPath        string   // 代码需要生成到什么位置
+	GrpcOutputPath    string   // grpc代码需要生成到什么位置
+	IncludePbFiles    []string // 生成的代码引用到的其他proto文件列表
+	OutputNeedFormat  bool     // 生成位置的代码是否需要用 gofmt 格式化一下
+	NoGetScopeFunc    bool     // 生成的代码不要包含 mdbc 的 GetScope 函数
+	DbDriveType       string   // 数据库驱动类型
+	FreqOutput        string   // 限频文件输出路径
+	Concurrency       int      // 目录模式下同时生成的文件数 小于等于1时逐个生成 两种模式下单个文件失败都继续生成其他文件 错误按文件顺序汇总
+	NativeCompile     bool     // 不依赖 protoc 使用 protoparse 编译 进程内生成 .pb.go
+	Lenient           bool     // 宽松模式 注解错误只输出不中断生成
+	RouteManifest     string   // 路由清单输出路径 .json 或 .csv 为空不输出
+	CommentRemovedRPC bool     // 路由组 @gen_to 文件中 rpc 已从 proto 删除的方法注释掉 默认只输出警告
+	RewriteErrorCode  bool     // 把 @gen_to 中用到的错误码写回源 proto 的 @error 默认不改写源文件 可以用 CheckErrCode 检查
+}
+
+// 文档输出格式
+const (
+	DocFormatMarkdown = "md"
+	DocFormatHTML     = "html"
+	DocFormatAsciiDoc = "adoc"
+)
+
+// 内置文档模板语言
+const (
+	DocLocaleZh = "zh"
+	DocLocaleEn = "en"
+)
+
+// SQL 方言
+const (
+	SQLDialectMySQL    = "mysql"
+	SQLDialectPostgres = "postgres"
+	SQLDialectSQLite   = "sqlite"
+)
+
+// SQLConfig gdbc model 建表语句与迁移的输出配置
+type SQLConfig struct {
+	Dialect      string // mysql(默认) postgres sqlite
+	Output       string // 建表语句输出路径 为空时输出到 stdout
+	Migrate      bool   // 与迁移目录下的表结构快照比较 有变化时输出下一个版本的迁移并更新快照
+	MigrationDir string // 迁移文件目录 默认为 proto 所在目录下的 migrations/方言 目录下的 proto 共用快照与版本序列
+}
+
+// mongodb 索引与校验的输出格式
+const (
+	MongoFormatJSON = "json"
+	MongoFormatGo   = "go"
+)
+
+// MongoConfig mongodb model 的索引与 $jsonSchema 校验输出配置
+type MongoConfig struct {
+	Format string // json(默认) 按 collection 输出 createIndexes 与 collMod 命令; go 输出 model 的 EnsureIndexes EnsureValidator 方法
+	Output string // 输出文件路径 为空时输出到 stdout
+}
+
+// DocConfig 文档输出配置
+type DocConfig struct {
+	Template     string // 自定义 text/template 模板内容 优先于 TemplateFile
+	TemplateFile string // 自定义模板文件路径
+	Locale       string // 内置模板的语言 zh(默认) en
+	Format       string // 输出格式 md(默认) html adoc; html 使用 html/template 渲染
+	Output       string // 输出文件路径 为空时输出到 stdout
 }
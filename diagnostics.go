@@ -0,0 +1,119 @@
+package proto_parser
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/scanner"
+
+	"github.com/emicklei/proto"
+)
+
+// Severity 诊断级别
+type Severity int
+
+const (
+	SeverityError   Severity = iota // 错误 默认中断生成
+	SeverityWarning                 // 警告 仅输出
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Diagnostic 一条注解诊断 位置精确到 proto 文件的行列
+type Diagnostic struct {
+	Pos      scanner.Position
+	Severity Severity
+	Msg      string
+}
+
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.Pos.Filename, d.Pos.Line, d.Pos.Column, d.Severity, d.Msg)
+}
+
+// Diagnostics 收集到的诊断 作为 error 返回时包含所有条目
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	var lines = make([]string, 0, len(ds))
+	for _, d := range ds {
+		lines = append(lines, d.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HasError 是否包含错误级别的诊断
+func (ds Diagnostics) HasError() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors 只保留错误级别的诊断
+func (ds Diagnostics) Errors() Diagnostics {
+	var res Diagnostics
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// commentLinePos 注释中第 i 行的位置
+func commentLinePos(c *proto.Comment, i int) scanner.Position {
+	pos := c.Position
+	pos.Line += i
+	return pos
+}
+
+// Diagnostics 本次运行收集到的诊断
+func (g *Generator) Diagnostics() Diagnostics {
+	return g.diags
+}
+
+func (g *Generator) addDiag(pos scanner.Position, severity Severity, format string, args ...interface{}) {
+	d := &Diagnostic{Pos: pos, Severity: severity, Msg: fmt.Sprintf(format, args...)}
+	// 同一个文件可能被多次遍历 相同的诊断只记录一次
+	if g.hasDiag(d) {
+		return
+	}
+	g.diags = append(g.diags, d)
+}
+
+func (g *Generator) hasDiag(d *Diagnostic) bool {
+	for _, exist := range g.diags {
+		if exist.Error() == d.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+// printDiags 合并子 Generator 的诊断 只输出之前没有记录过的条目
+func (g *Generator) printDiags(ds Diagnostics) {
+	for _, d := range ds {
+		if g.hasDiag(d) {
+			continue
+		}
+		g.diags = append(g.diags, d)
+		_, _ = fmt.Fprintf(os.Stdout, "%s\n", d)
+	}
+}
+
+// errorf 记录一条错误
+func (g *Generator) errorf(pos scanner.Position, format string, args ...interface{}) {
+	g.addDiag(pos, SeverityError, format, args...)
+}
+
+// warnf 记录一条警告
+func (g *Generator) warnf(pos scanner.Position, format string, args ...interface{}) {
+	g.addDiag(pos, SeverityWarning, format, args...)
+}
@@ -0,0 +1,158 @@
+package proto_parser
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/proto"
+)
+
+func TestDiagnostics(t *testing.T) {
+	src := `syntax = "proto3";
+package test;
+
+message ModelUser {
+    // @index: abc asc
+    string name = 1;
+    // @index: user_age up
+    int64 age = 2;
+}
+
+service User {
+    // @freq: 1 2
+    rpc Get (GetReq) returns (GetResp);
+}
+`
+	parser := proto.NewParser(strings.NewReader(src))
+	parser.Filename("test.proto")
+	definition, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewGenerator()
+	proto.Walk(definition,
+		proto.WithMessage(g.collectIndex),
+		proto.WithService(g.injectFreqMap),
+	)
+
+	diags := g.Diagnostics()
+	if !diags.HasError() || len(diags) != 3 {
+		t.Fatalf("expect 3 errors, got:\n%v", diags)
+	}
+	var lines = []int{5, 7, 12}
+	for i, d := range diags {
+		if d.Pos.Filename != "test.proto" || d.Pos.Line != lines[i] {
+			t.Errorf("unexpected position: %s", d)
+		}
+	}
+	if !strings.Contains(diags[0].Msg, `"abc"`) {
+		t.Errorf("expect index name error, got: %s", diags[0])
+	}
+}
+
+func TestIndexConflictDiagnostics(t *testing.T) {
+	src := `syntax = "proto3";
+package test;
+
+message ModelUser {
+    // @unique_index: idx_name_age asc
+    // @ttl_index: idx_expire asc 60
+    string name = 1;
+    // @index: idx_name_age asc
+    // @ttl_index: idx_expire asc 60
+    // @index: idx_status asc collation:zh
+    int64 age = 2;
+    // @index: idx_status asc collation:en
+    int32 status = 3;
+}
+`
+	parser := proto.NewParser(strings.NewReader(src))
+	parser.Filename("test.proto")
+	definition, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewGenerator()
+	proto.Walk(definition, proto.WithMessage(g.collectIndex))
+
+	// 冲突的注解逐行报错 同一注释中后面的注解仍然收集
+	diags := g.Diagnostics()
+	var lines = []int{8, 9, 12}
+	if len(diags) != len(lines) {
+		t.Fatalf("expect %d errors, got:\n%v", len(lines), diags)
+	}
+	for i, d := range diags {
+		if d.Severity != SeverityError || d.Pos.Line != lines[i] {
+			t.Errorf("unexpected diagnostic: %s", d)
+		}
+	}
+	if g.Visitor.ModelIndexMap["ModelUser"]["idx_status"] == nil {
+		t.Error("expect idx_status to be collected after a conflicting line")
+	}
+}
+
+func TestCodeGenDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	var good = `syntax = "proto3";
+package test;
+option go_package = "./;test";
+
+message ModelUser {
+    // @index: user_name asc
+    string name = 1;
+}
+`
+	var bad = `syntax = "proto3";
+package test;
+option go_package = "./;test";
+
+message ModelOrder {
+    // @index: order_no up
+    string no = 1;
+}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.proto"), []byte(good), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "b.proto"), []byte(bad), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := &CodeGenConfig{
+		PbFilePath:     dir,
+		IncludePbFiles: []string{dir},
+		NativeCompile:  true,
+	}
+
+	// 有错误时返回 CodeGenErrors 且不写入任何文件
+	g := NewGenerator()
+	err := g.CodeGen(config)
+	var errs CodeGenErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || !strings.HasSuffix(errs[0].File, "b.proto") {
+		t.Fatalf("expect b.proto error, got: %v", err)
+	}
+	if ds := errs.Diagnostics(); len(ds) != 1 || ds[0].Pos.Line != 6 {
+		t.Fatalf("unexpected diagnostics: %v", ds)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Fatalf("expect no output before validation passed, got %d files", len(files))
+	}
+
+	// 宽松模式继续生成 诊断通过 Diagnostics 返回
+	config.Lenient = true
+	g = NewGenerator()
+	if err := g.CodeGen(config); err != nil {
+		t.Fatal(err)
+	}
+	if ds := g.Diagnostics(); len(ds) != 1 || !ds.HasError() {
+		t.Fatalf("expect lenient diagnostics, got: %v", ds)
+	}
+	if !IsExist(filepath.Join(dir, "a.pb.go")) || !IsExist(filepath.Join(dir, "b.pb.go")) {
+		t.Fatal("expect generated code in lenient mode")
+	}
+}
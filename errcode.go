@@ -0,0 +1,360 @@
+package proto_parser
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/scanner"
+	"text/template"
+
+	"github.com/elliotchance/pie/pie"
+	"github.com/emicklei/proto"
+	log "github.com/sirupsen/logrus"
+)
+
+// grpcCodeNames grpc 状态码 支持 NOT_FOUND 与 NotFound 两种写法 值为 codes 包中的名字
+var grpcCodeNames = map[string]string{
+	"OK":                  "OK",
+	"CANCELLED":           "Canceled",
+	"UNKNOWN":             "Unknown",
+	"INVALID_ARGUMENT":    "InvalidArgument",
+	"DEADLINE_EXCEEDED":   "DeadlineExceeded",
+	"NOT_FOUND":           "NotFound",
+	"ALREADY_EXISTS":      "AlreadyExists",
+	"PERMISSION_DENIED":   "PermissionDenied",
+	"RESOURCE_EXHAUSTED":  "ResourceExhausted",
+	"FAILED_PRECONDITION": "FailedPrecondition",
+	"ABORTED":             "Aborted",
+	"OUT_OF_RANGE":        "OutOfRange",
+	"UNIMPLEMENTED":       "Unimplemented",
+	"INTERNAL":            "Internal",
+	"UNAVAILABLE":         "Unavailable",
+	"DATA_LOSS":           "DataLoss",
+	"UNAUTHENTICATED":     "Unauthenticated",
+}
+
+// grpcCodeName 注解中的 grpc 状态码转成 codes 包中的名字 不认识的返回空
+func grpcCodeName(name string) string {
+	if v, ok := grpcCodeNames[strings.ToUpper(name)]; ok {
+		return v
+	}
+	for _, v := range grpcCodeNames {
+		if v == name {
+			return v
+		}
+	}
+	return ""
+}
+
+// errCodeInfo 解析错误码 行内注释为默认说明 头部注释中可以声明
+// @http: 404 @grpc: NOT_FOUND @msg_en: not found @msg_zh: 未找到
+func (g *Generator) errCodeInfo(field *proto.EnumField) *ErrCodeInfo {
+	var eci = &ErrCodeInfo{
+		ErrCode: field.Integer,
+		ErrName: trim(field.Name),
+		ErrMsg:  trim(field.Name),
+		pos:     field.Position,
+	}
+	if field.InlineComment != nil && len(field.InlineComment.Lines) != 0 {
+		eci.ErrMsg = trim(field.InlineComment.Message())
+	}
+	if field.Comment == nil {
+		return eci
+	}
+
+	httpReg := regexp.MustCompile(RegexpErrHTTP)
+	grpcReg := regexp.MustCompile(RegexpErrGRPC)
+	msgReg := regexp.MustCompile(RegexpErrMsg)
+	for i, line := range field.Comment.Lines {
+		if res := httpReg.FindStringSubmatch(line); len(res) == 2 {
+			status, _ := strconv.Atoi(res[1])
+			if status < 100 || status > 599 {
+				g.errorf(commentLinePos(field.Comment, i), "errcode %s: 不合法的 http 状态码 %s", field.Name, res[1])
+				continue
+			}
+			eci.HTTPStatus = status
+			continue
+		}
+		if res := grpcReg.FindStringSubmatch(line); len(res) == 2 {
+			code := grpcCodeName(res[1])
+			if code == "" {
+				g.errorf(commentLinePos(field.Comment, i), "errcode %s: 不支持的 grpc 状态码 %q", field.Name, res[1])
+				continue
+			}
+			eci.GRPCCode = code
+			continue
+		}
+		if res := msgReg.FindStringSubmatch(line); len(res) == 3 {
+			if eci.Msgs == nil {
+				eci.Msgs = make(map[string]string)
+			}
+			eci.Msgs[res[1]] = trim(res[2])
+		}
+	}
+	return eci
+}
+
+// errCodeLocales 错误码中声明过的所有语言
+func errCodeLocales(list []*ErrCodeInfo) []string {
+	var res []string
+	for _, eci := range list {
+		for locale := range eci.Msgs {
+			res = append(res, locale)
+		}
+	}
+	sort.Strings(res)
+	var locales []string
+	for i, locale := range res {
+		if i == 0 || res[i-1] != locale {
+			locales = append(locales, locale)
+		}
+	}
+	return locales
+}
+
+// errCodeHasGRPC 是否有错误码声明了 grpc 状态码 没有时生成的代码不依赖 grpc
+func errCodeHasGRPC(list []*ErrCodeInfo) bool {
+	for _, eci := range list {
+		if eci.GRPCCode != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// errCodeRange 解析 ErrCode 枚举上的 @errcode_range: 最小值 最大值
+func (g *Generator) errCodeRange(e *proto.Enum) []int {
+	if e.Comment == nil {
+		return nil
+	}
+	reg := regexp.MustCompile(RegexpErrCodeRange)
+	for i, line := range e.Comment.Lines {
+		if !strings.Contains(line, "@errcode_range") {
+			continue
+		}
+		res := reg.FindStringSubmatch(line)
+		if len(res) != 3 {
+			g.errorf(commentLinePos(e.Comment, i), "errcode 范围格式错误 应为 @errcode_range: 最小值 最大值")
+			return nil
+		}
+		min, _ := strconv.Atoi(res[1])
+		max, _ := strconv.Atoi(res[2])
+		if min > max {
+			g.errorf(commentLinePos(e.Comment, i), "errcode 范围 %d %d 最小值大于最大值", min, max)
+			return nil
+		}
+		return []int{min, max}
+	}
+	return nil
+}
+
+// writeErrCodeFile 按 ErrCodeTpl 输出错误码文件
+func writeErrCodeFile(file, packageName string, list []*ErrCodeInfo) error {
+	var KV = struct {
+		PackageName string
+		ErrCodeList []*ErrCodeInfo
+		ErrLocales  []string
+		ErrHasGRPC  bool
+	}{
+		PackageName: packageName,
+		ErrCodeList: list,
+		ErrLocales:  errCodeLocales(list),
+		ErrHasGRPC:  errCodeHasGRPC(list),
+	}
+	t, err := template.New("errcode").Parse(ErrCodeTpl)
+	if err != nil {
+		log.Errorf("err: %+v", err)
+		return err
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, KV); err != nil {
+		log.Errorf("err: %+v", err)
+		return err
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0666); err != nil {
+		log.Errorf("err: %+v", err)
+		return err
+	}
+	return nil
+}
+
+// errCodeFile 单个 proto 文件的错误码
+type errCodeFile struct {
+	pbPath  string
+	pkgName string
+	list    []*ErrCodeInfo
+	rng     []int
+}
+
+// errCodeFile 当前文件的错误码 用于按目录合并
+func (g *Generator) errCodeFile(pbPath string) *errCodeFile {
+	return &errCodeFile{
+		pbPath:  pbPath,
+		pkgName: g.Visitor.PackageName,
+		list:    g.Visitor.ErrCodeList,
+		rng:     g.Visitor.ErrCodeRange,
+	}
+}
+
+// mergeDirErrCodes 合并本次运行涉及的目录的错误码
+// 目录下不在本次运行中的 proto 只解析错误码 保证只生成一个文件时不会丢掉其他文件的错误码
+func (g *Generator) mergeDirErrCodes(config *CodeGenConfig, results []*protoFileResult) ([]*errCodePackage, map[string]Diagnostics, error) {
+	var files []*errCodeFile
+	var inRun = make(map[string]bool)
+	var dirs []string
+	for _, res := range results {
+		if res.errCodes != nil {
+			files = append(files, res.errCodes)
+		}
+		inRun[filepath.Clean(res.pbPath)] = true
+		dirs = append(dirs, path.Dir(res.pbPath))
+	}
+
+	for _, dir := range pie.Strings(dirs).Unique() {
+		fi, err := ioutil.ReadDir(dir)
+		if err != nil {
+			log.Errorf("err: %+v", err)
+			return nil, nil, err
+		}
+		for _, file := range fi {
+			name := file.Name()
+			if file.IsDir() || !strings.HasSuffix(name, ".proto") || strings.HasPrefix(name, "autogen") || strings.HasPrefix(name, "origin") {
+				continue
+			}
+			pbPath := dir + "/" + name
+			if inRun[filepath.Clean(pbPath)] {
+				continue
+			}
+			definition, err := openProtoFile(pbPath)
+			if err != nil {
+				return nil, nil, err
+			}
+			child := g.fork()
+			child.Visitor = &ProtoVisitor{dbDriver: config.DbDriveType, IncludePaths: config.IncludePbFiles}
+			proto.Walk(definition,
+				proto.WithPackage(child.loadPackage),
+				proto.WithEnum(child.loadErrCodeEnum),
+			)
+			files = append(files, child.errCodeFile(pbPath))
+		}
+	}
+
+	// 按文件名合并 只生成部分文件时输出也保持一致
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].pbPath < files[j].pbPath
+	})
+	pkgs, diags := mergeErrCodes(files)
+	return pkgs, diags, nil
+}
+
+// errCodePackage 同一目录(同一个 go 包)下合并后的错误码
+type errCodePackage struct {
+	dir     string
+	pkgName string
+	files   []string
+	list    []*ErrCodeInfo
+}
+
+// mergeErrCodes 按目录合并错误码 检查跨文件重复的名字与数值 以及重叠的 @errcode_range
+// 冲突的错误码不会加入合并结果 诊断按 proto 文件返回
+func mergeErrCodes(files []*errCodeFile) ([]*errCodePackage, map[string]Diagnostics) {
+	var diags = make(map[string]Diagnostics)
+	var pkgMap = make(map[string]*errCodePackage)
+	var ranges = make(map[string][]*errCodeFile)
+	var dirs []string
+	for _, f := range files {
+		dir := path.Dir(f.pbPath)
+		pkg, ok := pkgMap[dir]
+		if !ok {
+			pkg = &errCodePackage{dir: dir}
+			pkgMap[dir] = pkg
+			dirs = append(dirs, dir)
+		}
+		pkg.files = append(pkg.files, f.pbPath)
+		// 没有错误码的文件也需要记录 目录下的错误码全部删除后要删除合并文件
+		if len(f.list) == 0 {
+			continue
+		}
+		if pkg.pkgName == "" {
+			pkg.pkgName = f.pkgName
+		}
+
+		var report = func(pos scanner.Position, format string, args ...interface{}) {
+			diags[f.pbPath] = append(diags[f.pbPath], &Diagnostic{Pos: pos, Severity: SeverityError, Msg: fmt.Sprintf(format, args...)})
+		}
+		if f.pkgName != pkg.pkgName {
+			report(f.list[0].pos, "errcode: package %s 与同目录的 package %s 不一致", f.pkgName, pkg.pkgName)
+			continue
+		}
+		if f.rng != nil {
+			for _, other := range ranges[dir] {
+				if f.rng[0] <= other.rng[1] && other.rng[0] <= f.rng[1] {
+					report(f.list[0].pos, "errcode: @errcode_range %d %d 与 %s 的 %d %d 重叠", f.rng[0], f.rng[1], other.pbPath, other.rng[0], other.rng[1])
+				}
+			}
+			ranges[dir] = append(ranges[dir], f)
+		}
+
+	Next:
+		for _, eci := range f.list {
+			for _, exist := range pkg.list {
+				// 同名的默认值 0 只保留一个
+				if exist.ErrName == eci.ErrName && exist.ErrCode == 0 && eci.ErrCode == 0 {
+					continue Next
+				}
+				if exist.ErrName == eci.ErrName {
+					report(eci.pos, "errcode: 重复的错误码名 %s 已在 %s:%d 定义", eci.ErrName, exist.pos.Filename, exist.pos.Line)
+					continue Next
+				}
+				// 0 为每个枚举的默认值 允许重复
+				if exist.ErrCode == eci.ErrCode && eci.ErrCode != 0 {
+					report(eci.pos, "errcode: %s 的错误码 %d 与 %s(%s:%d) 重复", eci.ErrName, eci.ErrCode, exist.ErrName, exist.pos.Filename, exist.pos.Line)
+					continue Next
+				}
+			}
+			pkg.list = append(pkg.list, eci)
+		}
+	}
+
+	var pkgs []*errCodePackage
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		pkg := pkgMap[dir]
+		sort.SliceStable(pkg.list, func(i, j int) bool {
+			return pkg.list[i].ErrCode < pkg.list[j].ErrCode
+		})
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, diags
+}
+
+// genErrCodePackage 输出目录下合并后的错误码文件 并删除旧版本逐个文件生成的错误码文件
+// 目录下已经没有错误码时删除合并文件
+func genErrCodePackage(pkg *errCodePackage) error {
+	var stale []string
+	for _, pbPath := range pkg.files {
+		fileName := path.Base(pbPath)
+		stale = append(stale, fmt.Sprintf("%s/autogen_errcode_%s.go", pkg.dir, strings.TrimSuffix(fileName, path.Ext(fileName))))
+	}
+	file := fmt.Sprintf("%s/autogen_errcode.go", pkg.dir)
+	if len(pkg.list) == 0 {
+		stale = append(stale, file)
+	}
+	for _, old := range stale {
+		if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+			log.Errorf("err: %+v", err)
+			return err
+		}
+	}
+	if len(pkg.list) == 0 {
+		return nil
+	}
+	return writeErrCodeFile(file, pkg.pkgName, pkg.list)
+}
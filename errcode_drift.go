@@ -0,0 +1,139 @@
+package proto_parser
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/emicklei/proto"
+	"github.com/sirupsen/logrus"
+)
+
+// CheckErrCode 只读检查 rpc 注释中的 @error 与 @gen_to 代码中用到的错误码是否一致 不改写 proto
+// 按 rpc 报告代码中使用但没有声明的 声明了但代码中没有使用的 以及不在任何已知 ErrCode 枚举中的错误码
+// 有差异时返回 Diagnostics 调用方据此以非 0 状态退出
+func (g *Generator) CheckErrCode(pbFiles []string) error {
+	g.reset()
+	g.errCodePkgs = newErrCodePkgCache()
+
+	var visitors = make([]*ProtoVisitor, 0, len(pbFiles))
+	// 同目录(同一个 go 包)下所有文件的错误码 可以不带包名直接引用
+	var dirCodes = make(map[string][]string)
+	for _, pbFile := range pbFiles {
+		definition, err := openProtoFile(pbFile)
+		if err != nil {
+			return err
+		}
+		g.Visitor = &ProtoVisitor{}
+		g.pbFilePath = pbFile
+		proto.Walk(definition,
+			proto.WithImport(g.loadImportPackage),
+			proto.WithPackage(g.loadPackage),
+			proto.WithService(g.parseSrvGenRouter),
+			proto.WithEnum(g.loadErrCodeEnum),
+		)
+		visitors = append(visitors, g.Visitor)
+		for _, eci := range g.Visitor.ErrCodeList {
+			dirCodes[path.Dir(pbFile)] = append(dirCodes[path.Dir(pbFile)], eci.ErrName)
+		}
+	}
+
+	var diags Diagnostics
+	for i, pbFile := range pbFiles {
+		g.Visitor = visitors[i]
+		g.pbFilePath = pbFile
+
+		var known = make(map[string]bool)
+		for _, name := range dirCodes[path.Dir(pbFile)] {
+			known[name] = true
+		}
+		for name := range g.Visitor.ErrCodeEnumFieldMap {
+			known[name] = true
+		}
+
+		for _, srvName := range g.groupRouterNames() {
+			group := g.Visitor.GroupRouterMap[srvName]
+			if group.GenTo == "" || !IsExist(group.GenTo) {
+				continue
+			}
+			var srv = &positionSrv{genTo: group.GenTo, rpcMap: make(map[string]*proto.RPC)}
+			for _, node := range group.Apis {
+				if node.rpc != nil {
+					srv.rpcMap[node.FuncName] = node.rpc
+				}
+			}
+			gf := genToFile{gen: g, srvImplName: fmt.Sprintf("%sImpl", srvName), srvDetail: srv}
+			rpcCodes, err := gf.rpcErrCodes()
+			if err != nil {
+				logrus.Errorf("parse go file err: %+v", err)
+				return err
+			}
+			for _, node := range group.Apis {
+				used, ok := rpcCodes[node.FuncName]
+				if !ok {
+					continue
+				}
+				var documented []string
+				if node.rpc.Comment != nil {
+					documented = rpcErrCodeNames(node.rpc.Comment.Lines)
+				}
+				diags = append(diags, errCodeDrift(node.rpc, srvName, used, documented, known)...)
+			}
+		}
+	}
+
+	if len(diags) == 0 {
+		return nil
+	}
+	sort.SliceStable(diags, func(i, j int) bool {
+		if diags[i].Pos.Filename != diags[j].Pos.Filename {
+			return diags[i].Pos.Filename < diags[j].Pos.Filename
+		}
+		return diags[i].Pos.Line < diags[j].Pos.Line
+	})
+	return diags
+}
+
+// errCodeDrift 比较一个 rpc 代码中用到的与 @error 中声明的错误码
+func errCodeDrift(rpc *proto.RPC, srvName string, used, documented []string, known map[string]bool) Diagnostics {
+	var diags Diagnostics
+	var report = func(format string, codes []string) {
+		if len(codes) == 0 {
+			return
+		}
+		diags = append(diags, &Diagnostic{
+			Pos:      rpc.Position,
+			Severity: SeverityError,
+			Msg:      fmt.Sprintf("rpc %s.%s: "+format, srvName, rpc.Name, strings.Join(codes, ", ")),
+		})
+	}
+	report("代码中使用了 @error 中没有声明的错误码 %s", errCodeMissing(used, documented))
+	report("@error 中声明的错误码 %s 在代码中没有使用", errCodeMissing(documented, used))
+
+	var unknown []string
+	for _, name := range errCodeMissing(append(append([]string(nil), used...), documented...), nil) {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	report("错误码 %s 不在已知的 ErrCode 枚举中", unknown)
+	return diags
+}
+
+// errCodeMissing a 中有而 b 中没有的错误码 去重并保持顺序
+func errCodeMissing(a, b []string) []string {
+	var exclude = make(map[string]bool)
+	for _, name := range b {
+		exclude[name] = true
+	}
+	var res []string
+	for _, name := range a {
+		if exclude[name] {
+			continue
+		}
+		exclude[name] = true
+		res = append(res, name)
+	}
+	return res
+}
@@ -0,0 +1,110 @@
+package proto_parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckErrCode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "errcode_drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeErrCodeTestFiles(t, dir)
+
+	// import 的错误码在代码中以 go_package 的包名 other 引用
+	commonFile := filepath.Join(dir, "user", "common", "errs.proto")
+	if err := os.MkdirAll(filepath.Dir(commonFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	commonSrc := `syntax = "proto3";
+package demo.common;
+option go_package = "example.com/demo/other";
+
+enum ErrCode {
+    ErrCode_None = 0;
+    ErrOther = 20001;
+}
+`
+	if err := ioutil.WriteFile(commonFile, []byte(commonSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	pbFile := filepath.Join(dir, "user", "user.proto")
+	src := fmt.Sprintf(`syntax = "proto3";
+package user;
+
+import "common/errs.proto";
+
+enum ErrCode {
+    ErrCode_None = 0;
+    ErrElse = 1;
+    ErrCase = 2;
+    ErrRange = 3;
+    ErrClosure = 4;
+    ErrDefer = 5;
+    ErrGo = 6;
+    ErrHelper = 7;
+    ErrMethod = 8;
+    ErrDocOnly = 10;
+}
+
+message CreateUserReq {}
+
+message CreateUserResp {}
+
+// @route_group: true
+// @route_api: /api/user
+// @gen_to: %s
+service UserAPI {
+    // @desc: 创建用户
+    // @method: POST
+    // @api: /create
+    // @error:
+    // ErrElse
+    // ErrCase
+    // ErrRange
+    // ErrClosure
+    // ErrDefer
+    // ErrGo
+    // ErrHelper
+    // ErrDocOnly
+    // ErrMissing
+    rpc CreateUser (CreateUserReq) returns (CreateUserResp);
+}
+`, filepath.Join(dir, "handler", "user.go"))
+	if err := ioutil.WriteFile(pbFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := ioutil.ReadFile(pbFile)
+
+	err = CheckErrCode([]string{pbFile})
+	diags, ok := err.(Diagnostics)
+	if !ok {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	var want = []string{
+		"代码中使用了 @error 中没有声明的错误码 ErrMethod, other.ErrOther",
+		"@error 中声明的错误码 ErrDocOnly, ErrMissing 在代码中没有使用",
+		"错误码 ErrMissing 不在已知的 ErrCode 枚举中",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("unexpected diagnostics:\n%v", diags)
+	}
+	for i, d := range diags {
+		if !strings.HasSuffix(d.Msg, want[i]) {
+			t.Errorf("unexpected diagnostic: %s", d.Msg)
+		}
+	}
+
+	// 只读 不改写 proto
+	after, _ := ioutil.ReadFile(pbFile)
+	if string(before) != string(after) {
+		t.Errorf("proto file changed:\n%s", after)
+	}
+}
@@ -0,0 +1,162 @@
+package proto_parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/scanner"
+
+	"github.com/emicklei/proto"
+)
+
+func TestErrCodeInfo(t *testing.T) {
+	src := `syntax = "proto3";
+package test;
+
+enum ErrCode {
+    None = 0;
+    // @http: 404
+    // @grpc: NOT_FOUND
+    // @msg_en: user not found
+    // @msg_zh: 用户不存在
+    ErrUserNotFound = 10001; // 用户不存在
+    // @http: 999
+    // @grpc: Nope
+    ErrBad = 10002;
+}
+`
+	parser := proto.NewParser(strings.NewReader(src))
+	parser.Filename("test.proto")
+	definition, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGenerator()
+	proto.Walk(definition, proto.WithEnum(g.loadErrCodeEnum))
+
+	list := g.Visitor.ErrCodeList
+	if len(list) != 3 {
+		t.Fatalf("expect 3 errcodes, got %d", len(list))
+	}
+	eci := list[1]
+	if eci.ErrMsg != "用户不存在" || eci.HTTPStatus != 404 || eci.GRPCCode != "NotFound" || eci.Msgs["en"] != "user not found" {
+		t.Errorf("unexpected errcode: %+v", eci)
+	}
+	if locales := errCodeLocales(list); strings.Join(locales, ",") != "en,zh" || !errCodeHasGRPC(list) {
+		t.Errorf("unexpected locales: %v", locales)
+	}
+	if diags := g.Diagnostics(); len(diags) != 2 || diags[0].Pos.Line != 11 {
+		t.Errorf("expect 2 errors, got:\n%v", diags)
+	}
+}
+
+func TestMergeErrCodes(t *testing.T) {
+	pos := func(file string, line int) scanner.Position {
+		return scanner.Position{Filename: file, Line: line}
+	}
+	files := []*errCodeFile{
+		{pbPath: "api/user.proto", pkgName: "api", rng: []int{10000, 10999}, list: []*ErrCodeInfo{
+			{ErrCode: 0, ErrName: "None", pos: pos("api/user.proto", 5)},
+			{ErrCode: 10001, ErrName: "ErrUserNotFound", pos: pos("api/user.proto", 6)},
+		}},
+		{pbPath: "api/order.proto", pkgName: "api", rng: []int{10500, 11999}, list: []*ErrCodeInfo{
+			{ErrCode: 0, ErrName: "None", pos: pos("api/order.proto", 5)},
+			{ErrCode: 11001, ErrName: "ErrUserNotFound", pos: pos("api/order.proto", 6)},
+			{ErrCode: 10001, ErrName: "ErrOrderNotFound", pos: pos("api/order.proto", 7)},
+			{ErrCode: 11002, ErrName: "ErrOrderPaid", pos: pos("api/order.proto", 8)},
+		}},
+		{pbPath: "admin/admin.proto", pkgName: "admin", list: []*ErrCodeInfo{
+			{ErrCode: 10001, ErrName: "ErrUserNotFound", pos: pos("admin/admin.proto", 5)},
+		}},
+	}
+
+	pkgs, diags := mergeErrCodes(files)
+	if len(pkgs) != 2 || pkgs[0].dir != "admin" || pkgs[1].dir != "api" {
+		t.Fatalf("unexpected packages: %+v", pkgs)
+	}
+	var names []string
+	for _, eci := range pkgs[1].list {
+		names = append(names, eci.ErrName)
+	}
+	if strings.Join(names, ",") != "None,ErrUserNotFound,ErrOrderPaid" {
+		t.Errorf("unexpected merged errcodes: %v", names)
+	}
+	// 范围重叠 重复的名字 重复的数值
+	if ds := diags["api/order.proto"]; len(ds) != 3 {
+		t.Errorf("expect 3 errors, got:\n%v", ds)
+	}
+	if len(diags["api/user.proto"]) != 0 || len(diags["admin/admin.proto"]) != 0 {
+		t.Errorf("unexpected errors: %v", diags)
+	}
+}
+
+func TestCodeGenErrCodeSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	var writeProto = func(name, codes string) {
+		src := fmt.Sprintf(`syntax = "proto3";
+package svc;
+option go_package = "./;svc";
+%s
+message %sReq {
+    int64 id = 1;
+}
+`, codes, strings.Title(name))
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".proto"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var gen = func(pbFile string) string {
+		err := NewGenerator().CodeGen(&CodeGenConfig{
+			PbFilePath:     filepath.Join(dir, pbFile),
+			IncludePbFiles: []string{dir},
+			NativeCompile:  true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "autogen_errcode.go"))
+		return string(data)
+	}
+	writeProto("user", `
+enum ErrCode {
+    None = 0;
+    ErrUserNotFound = 10001; // 用户不存在
+}
+`)
+	writeProto("order", `
+enum ErrCode {
+    None = 0;
+    ErrOrderNotFound = 20001; // 订单不存在
+    ErrOrderPaid = 20002; // 订单已支付
+}
+`)
+
+	all := gen("")
+	if !strings.Contains(all, "ErrUserNotFound") || !strings.Contains(all, "ErrOrderPaid") {
+		t.Fatalf("unexpected errcode file:\n%s", all)
+	}
+	// 只生成一个文件时 同目录其他文件的错误码保留
+	if single := gen("order.proto"); single != all {
+		t.Errorf("single file generate differs:\n%s", single)
+	}
+
+	// 删除的错误码不再输出
+	writeProto("order", `
+enum ErrCode {
+    None = 0;
+    ErrOrderNotFound = 20001; // 订单不存在
+}
+`)
+	if res := gen("order.proto"); strings.Contains(res, "ErrOrderPaid") || !strings.Contains(res, "ErrUserNotFound") {
+		t.Errorf("unexpected errcode file:\n%s", res)
+	}
+
+	// 目录下的错误码枚举全部删除后 删除合并文件
+	writeProto("order", "")
+	writeProto("user", "")
+	if res := gen("user.proto"); res != "" {
+		t.Errorf("expect stale errcode file removed, got:\n%s", res)
+	}
+}
@@ -4,38 +4,76 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
 	"os"
+	"path"
 	"regexp"
 	"strings"
 	"text/template"
 
 	"github.com/elliotchance/pie/pie"
 	"github.com/emicklei/proto"
-	"github.com/golang/protobuf/protoc-gen-go/descriptor"
 	"github.com/sirupsen/logrus"
 
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/desc/protoparse"
 )
 
-var (
-	srvName string
-	rpcName string
-)
-
 // OutputMD 输出markdown文档
-func OutputMD(pbFile, srv, rpc string, includes []string) error {
+// 可传入 DocConfig 使用自定义模板 英文模板 或输出 html asciidoc 格式
+func (g *Generator) OutputMD(pbFile, srv, rpc string, includes []string, config ...*DocConfig) error {
+	var c = &DocConfig{}
+	if len(config) != 0 && config[0] != nil {
+		c = config[0]
+	}
+
+	mdoc, err := g.buildMDoc(pbFile, srv, rpc, includes)
+	if err != nil {
+		return err
+	}
+	out, err := renderDoc(mdoc, c)
+	if err != nil {
+		return err
+	}
+
+	if c.Output != "" {
+		if err := ioutil.WriteFile(c.Output, []byte(out), 0666); err != nil {
+			logrus.Errorf("write %s err: %+v", c.Output, err)
+			return err
+		}
+		return nil
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "==========\n%s", out)
+
+	return nil
+}
+
+// buildMDoc 解析单个 rpc 的文档数据
+func (g *Generator) buildMDoc(pbFile, srv, rpc string, includes []string) (*MDocs, error) {
+	g.reset()
+
 	reader, err := os.Open(pbFile)
 	if err != nil {
 		logrus.Errorf("open pb file: %+v, get err: %+v", pbFile, err)
-		return err
+		return nil, err
 	}
 	defer reader.Close()
 	parser := proto.NewParser(reader)
 	definition, err := parser.Parse()
 	if err != nil {
 		logrus.Errorf("parse pb file err: %+v", err)
-		return err
+		return nil, err
+	}
+
+	// 递归加载 import 的文件 查找路径取依赖文件所在目录
+	for _, include := range includes {
+		g.Visitor.IncludePaths = append(g.Visitor.IncludePaths, path.Dir(include))
+	}
+	g.Visitor.IncludePaths = pie.Strings(g.Visitor.IncludePaths).Unique()
+	if err := g.Visitor.GetSymbols().AddFile(pbFile, definition); err != nil {
+		logrus.Errorf("load import err: %+v", err)
 	}
 
 	// 写入依赖message
@@ -45,98 +83,98 @@ func OutputMD(pbFile, srv, rpc string, includes []string) error {
 		reader, err := os.Open(include)
 		if err != nil {
 			logrus.Errorf("open pb file: %+v, get err: %+v", pbFile, err)
-			return err
+			return nil, err
 		}
 		parser := proto.NewParser(reader)
 		definition, err := parser.Parse()
 		if err != nil {
 			logrus.Errorf("parse pb file err: %+v", err)
-			return err
+			return nil, err
 		}
 		// 拿包名
-		proto.Walk(definition, proto.WithPackage(mdLoadPackage))
+		proto.Walk(definition, proto.WithPackage(g.mdLoadPackage))
 
 		// 扫一拨所有message
 		proto.Walk(definition,
-			proto.WithEnum(getAllEnum),
-			proto.WithMessage(mdLoadAndGetAllMessage),
+			proto.WithEnum(g.getAllEnum),
+			proto.WithMessage(g.mdLoadAndGetAllMessage),
 		)
 
-		Visitor.MDocDepPkgName = ""
+		g.Visitor.MDocDepPkgName = ""
 		_ = reader.Close()
 	}
 
-	srvName = srv
-	rpcName = rpc
+	g.srvName = srv
+	g.rpcName = rpc
 
 	// 第一轮 数据初始化
 	proto.Walk(definition,
-		proto.WithPackage(loadPackage),
-		proto.WithEnum(getAllEnum),
-		proto.WithService(parseSrvAndGetMsg),
+		proto.WithPackage(g.loadPackage),
+		proto.WithEnum(g.getAllEnum),
+		proto.WithService(g.parseSrvAndGetMsg),
 	)
 
 	// 判断是否存在
-	if Visitor.MDoc == nil {
-		return fmt.Errorf("not found srv or rpc")
+	if g.Visitor.MDoc == nil {
+		return nil, fmt.Errorf("not found srv or rpc")
 	}
 
-	if Visitor.MDoc.Node == nil {
-		return fmt.Errorf("not found srv or rpc")
+	if g.Visitor.MDoc.Node == nil {
+		return nil, fmt.Errorf("not found srv or rpc")
 	}
 
-	if Visitor.MDoc.ReqName == "" {
-		return fmt.Errorf("not found request message")
+	if g.Visitor.MDoc.ReqName == "" {
+		return nil, fmt.Errorf("not found request message")
 	}
 
-	if Visitor.MDoc.RspName == "" {
-		return fmt.Errorf("not found response message")
+	if g.Visitor.MDoc.RspName == "" {
+		return nil, fmt.Errorf("not found response message")
 	}
 
 	// 获取请求体 响应体
-	proto.Walk(definition, proto.WithMessage(getSrvMsg))
+	proto.Walk(definition, proto.WithMessage(g.getSrvMsg))
 
-	if Visitor.MDoc.Req == nil {
-		return fmt.Errorf("not found request message")
+	if g.Visitor.MDoc.Req == nil {
+		return nil, fmt.Errorf("not found request message")
 	}
 
-	if Visitor.MDoc.Rsp == nil {
-		return fmt.Errorf("not found response message")
+	if g.Visitor.MDoc.Rsp == nil {
+		return nil, fmt.Errorf("not found response message")
 	}
 
-	getSrvMsgDetail(Visitor.MDoc.Req)
-	getSrvMsgDetail(Visitor.MDoc.Rsp)
+	g.getSrvMsgDetail(g.Visitor.MDoc.Req)
+	g.getSrvMsgDetail(g.Visitor.MDoc.Rsp)
 
 	// 输出 req
-	reqBody, err := pbMsgToJSON(pbFile, fmt.Sprintf("%s.%s", Visitor.PackageName, Visitor.MDoc.ReqName))
+	reqBody, err := g.pbMsgToJSON(pbFile, fmt.Sprintf("%s.%s", g.Visitor.PackageName, g.Visitor.MDoc.ReqName))
 	if err != nil {
 		logrus.Errorf("proto message to json err: %+v", err)
-		return err
+		return nil, err
 	}
-	Visitor.MDoc.ReqBody = string(reqBody)
+	g.Visitor.MDoc.ReqBody = string(reqBody)
 
 	// 输出 resp
-	respBody, err := pbMsgToJSON(pbFile, fmt.Sprintf("%s.%s", Visitor.PackageName, Visitor.MDoc.RspName))
+	respBody, err := g.pbMsgToJSON(pbFile, fmt.Sprintf("%s.%s", g.Visitor.PackageName, g.Visitor.MDoc.RspName))
 	if err != nil {
 		logrus.Errorf("proto message to json err: %+v", err)
-		return err
+		return nil, err
 	}
-	Visitor.MDoc.RespBody = string(respBody)
+	g.Visitor.MDoc.RespBody = string(respBody)
 
 	// 注册错误码
-	if len(Visitor.MDoc.ErrCodeMap) != 0 && Visitor.ErrCodeEnum != nil {
+	if len(g.Visitor.MDoc.ErrCodeMap) != 0 && g.Visitor.ErrCodeEnum != nil {
 		var otherErrCodeList MDocsErrCodes
-		for key := range Visitor.MDoc.ErrCodeMap {
-			if v, ok := Visitor.ErrCodeEnumFieldMap[key]; ok {
-				Visitor.MDoc.ErrCodeList = append(Visitor.MDoc.ErrCodeList, MDocsErrCodeField{
+		for key := range g.Visitor.MDoc.ErrCodeMap {
+			if v, ok := g.Visitor.ErrCodeEnumFieldMap[key]; ok {
+				g.Visitor.MDoc.ErrCodeList = append(g.Visitor.MDoc.ErrCodeList, MDocsErrCodeField{
 					Code: v.Code,
 					Name: key,
 					Desc: v.Desc,
 				})
 			} else {
-				name := fmt.Sprintf("%s.%s", Visitor.PackageName, key)
-				if vv, ook := Visitor.ErrCodeEnumFieldMap[name]; ook {
-					Visitor.MDoc.ErrCodeList = append(Visitor.MDoc.ErrCodeList, MDocsErrCodeField{
+				name := fmt.Sprintf("%s.%s", g.Visitor.PackageName, key)
+				if vv, ook := g.Visitor.ErrCodeEnumFieldMap[name]; ook {
+					g.Visitor.MDoc.ErrCodeList = append(g.Visitor.MDoc.ErrCodeList, MDocsErrCodeField{
 						Code: vv.Code,
 						Name: key,
 						Desc: vv.Desc,
@@ -148,78 +186,120 @@ func OutputMD(pbFile, srv, rpc string, includes []string) error {
 				}
 			}
 		}
-		Visitor.MDoc.ErrCodeList = Visitor.MDoc.ErrCodeList.SortStableUsing(func(a, b MDocsErrCodeField) bool {
+		g.Visitor.MDoc.ErrCodeList = g.Visitor.MDoc.ErrCodeList.SortStableUsing(func(a, b MDocsErrCodeField) bool {
 			return a.Code < b.Code
 		})
 		if len(otherErrCodeList) != 0 {
-			Visitor.MDoc.ErrCodeList = append(Visitor.MDoc.ErrCodeList, otherErrCodeList...)
+			g.Visitor.MDoc.ErrCodeList = append(g.Visitor.MDoc.ErrCodeList, otherErrCodeList...)
 		}
 	}
 
-	t := template.New("md")
-	t.Funcs(template.FuncMap{
-		"length":         Len,
-		"is_body_empty":  IsBodyEmpty,
-		"not_body_empty": NotBodyEmpty,
-	})
-	t, err = t.Parse(OutputMDTpl)
-	if err != nil {
-		logrus.Errorf("err: %+v", err)
-		return err
+	return g.Visitor.MDoc, nil
+}
+
+// docTemplate 文档模板 自定义模板优先 否则按输出格式选择内置模板 文案按语言由 i18n 函数切换
+func docTemplate(c *DocConfig) (string, error) {
+	if c.Template != "" {
+		return c.Template, nil
 	}
-	//t.DefinedTemplates()
-	var buf bytes.Buffer
-	if err = t.Execute(&buf, Visitor.MDoc); err != nil {
-		logrus.Errorf("err: %+v", err)
-		return err
+	if c.TemplateFile != "" {
+		data, err := ioutil.ReadFile(c.TemplateFile)
+		if err != nil {
+			logrus.Errorf("read template %s err: %+v", c.TemplateFile, err)
+			return "", err
+		}
+		return string(data), nil
 	}
-
-	_, _ = fmt.Fprintf(os.Stdout, "==========\n%s", buf.String())
-
-	return nil
+	switch c.Format {
+	case "", DocFormatMarkdown:
+		return OutputMDTpl, nil
+	case DocFormatHTML:
+		return OutputHTMLTpl, nil
+	case DocFormatAsciiDoc:
+		return OutputAsciiDocTpl, nil
+	}
+	return "", fmt.Errorf("unsupported doc format: %s", c.Format)
 }
 
-func parseSrvAndGetMsg(srv *proto.Service) {
-	if srv.Name != srvName {
-		return
+// docLocaleLabels 语言对应的模板文案 为空时使用中文
+func docLocaleLabels(locale string) (map[string]string, error) {
+	if locale == "" {
+		locale = DocLocaleZh
 	}
-
-	if srv.Comment == nil {
-		return
+	labels, ok := docLabels[locale]
+	if !ok {
+		err := fmt.Errorf("unsupported doc locale: %s", locale)
+		logrus.Errorf("err: %+v", err)
+		return nil, err
 	}
+	return labels, nil
+}
 
-	if len(srv.Comment.Lines) == 0 {
-		return
+// renderDoc 按模板渲染单个 rpc 的文档 html 格式使用 html/template 转义
+func renderDoc(mdoc *MDocs, c *DocConfig) (string, error) {
+	labels, err := docLocaleLabels(c.Locale)
+	if err != nil {
+		return "", err
+	}
+	tpl, err := docTemplate(c)
+	if err != nil {
+		return "", err
 	}
 
-	var doc = srv.Comment.Lines
-
-	for _, com := range doc {
-		var gr = regexp.MustCompile(RegexpGroupRouter)
-		res := gr.FindAllStringSubmatch(com, -1)
-		if len(res) == 0 {
-			continue
+	var funcs = map[string]interface{}{
+		"length":         Len,
+		"is_body_empty":  IsBodyEmpty,
+		"not_body_empty": NotBodyEmpty,
+		"doc_rows":       DocRows,
+		"indent":         DocIndent,
+		"i18n": func(key string) string {
+			return labels[key]
+		},
+	}
+	var buf bytes.Buffer
+	if c.Format == DocFormatHTML {
+		t, err := htmltemplate.New("doc").Funcs(funcs).Parse(tpl)
+		if err != nil {
+			logrus.Errorf("err: %+v", err)
+			return "", err
 		}
-		if len(res[0]) != 2 {
-			continue
+		err = t.Execute(&buf, mdoc)
+		if err != nil {
+			logrus.Errorf("err: %+v", err)
+			return "", err
 		}
+		return buf.String(), nil
+	}
 
-		if res[0][1] != "true" {
-			return
-		}
+	t, err := template.New("md").Funcs(funcs).Parse(tpl)
+	if err != nil {
+		logrus.Errorf("err: %+v", err)
+		return "", err
+	}
+	if err = t.Execute(&buf, mdoc); err != nil {
+		logrus.Errorf("err: %+v", err)
+		return "", err
+	}
+	return buf.String(), nil
+}
 
-		// 是一个路由组
-		genMD(srv)
+func (g *Generator) parseSrvAndGetMsg(srv *proto.Service) {
+	if srv.Name != g.srvName || !isRouteGroup(srv) {
 		return
 	}
+	// 是一个路由组
+	g.genMD(srv)
 }
 
-func genMD(srv *proto.Service) {
+func (g *Generator) genMD(srv *proto.Service) {
 	var node = new(GroupRouterNode)
 	var apiPrefix string
 	var reqName, rspName string
-	// 当开启了自定义组前缀
-	var doc = srv.Comment.Lines
+	// 当开启了自定义组前缀 以 API 结尾的 service 可以没有注释
+	var doc []string
+	if srv.Comment != nil {
+		doc = srv.Comment.Lines
+	}
 	for _, com := range doc {
 		var gra = regexp.MustCompile(RegexpGroupRouterAPI)
 		res := gra.FindAllStringSubmatch(com, -1)
@@ -229,8 +309,8 @@ func genMD(srv *proto.Service) {
 	}
 
 	for _, rpc := range srv.Elements {
-		sv := rpc.(*proto.RPC)
-		if sv.Name != rpcName {
+		sv, ok := rpc.(*proto.RPC)
+		if !ok || sv.Name != g.rpcName {
 			continue
 		}
 
@@ -240,8 +320,11 @@ func genMD(srv *proto.Service) {
 		node = &GroupRouterNode{
 			FuncName: sv.Name,
 		}
-		comment := sv.Comment.Lines
-		getRpcErrCodeMap(comment)
+		var comment []string
+		if sv.Comment != nil {
+			comment = sv.Comment.Lines
+		}
+		g.getRpcErrCodeMap(comment)
 		var isDefaultAPI, isDefaultMethod = true, true
 		for _, line := range comment {
 			// 检测author
@@ -318,259 +401,246 @@ func genMD(srv *proto.Service) {
 	if apiPrefix != "" {
 		node.RouterPath = apiPrefix + node.RouterPath
 	}
-	if Visitor.MDoc == nil {
-		Visitor.MDoc = &MDocs{
+	if g.Visitor.MDoc == nil {
+		g.Visitor.MDoc = &MDocs{
 			Node:    node,
 			ReqName: reqName,
 			RspName: rspName,
 		}
 	} else {
-		Visitor.MDoc.Node = node
-		Visitor.MDoc.ReqName = reqName
-		Visitor.MDoc.RspName = rspName
+		g.Visitor.MDoc.Node = node
+		g.Visitor.MDoc.ReqName = reqName
+		g.Visitor.MDoc.RspName = rspName
 	}
 }
 
-func getAllEnum(e *proto.Enum) {
+func (g *Generator) getAllEnum(e *proto.Enum) {
 	if e.Name == "ErrCode" {
-		Visitor.ErrCodeEnum = e
-		getErrorCodeField(e)
+		g.Visitor.ErrCodeEnum = e
+		g.getErrorCodeField(e)
 	}
-	Visitor.AddEnum(e.Name, e)
+	g.Visitor.AddEnum(e.Name, e)
 }
 
-func getSrvMsg(msg *proto.Message) {
-	Visitor.AddMsg(getOuterForefathersNameJoin(msg), msg)
+func (g *Generator) getSrvMsg(msg *proto.Message) {
+	g.Visitor.AddMsg(getOuterForefathersNameJoin(msg), msg)
 
 	for _, elem := range msg.Elements {
 		nestedMsg, ok := elem.(*proto.Message)
 		if !ok {
 			continue
 		}
-		getSrvMsg(nestedMsg)
+		g.getSrvMsg(nestedMsg)
 	}
 
-	if msg.Name == Visitor.MDoc.ReqName {
-		Visitor.MDoc.Req = msg
+	if msg.Name == g.Visitor.MDoc.ReqName {
+		g.Visitor.MDoc.Req = msg
 		return
 	}
 
-	if msg.Name == Visitor.MDoc.RspName {
-		Visitor.MDoc.Rsp = msg
+	if msg.Name == g.Visitor.MDoc.RspName {
+		g.Visitor.MDoc.Rsp = msg
 	}
 }
 
-func getFieldDOC(msg *proto.Message, parentName string) []*MDocsField {
+// getFieldDOC message 的字段文档 message 类型的字段展开到 Children 中
+// path 为当前展开路径上的 message 递归引用时不再展开
+func (g *Generator) getFieldDOC(msg *proto.Message, path []*proto.Message) []*MDocsField {
 	if msg == nil {
 		return nil
 	}
-
-	var typePrefix string
-	if parentName != "" {
-		typePrefix = parentName + "::"
+	for _, m := range path {
+		if m == msg {
+			return nil
+		}
 	}
+	path = append(path[:len(path):len(path)], msg)
 
 	var docField []*MDocsField
 	for _, elem := range msg.Elements {
-		var field *proto.NormalField
-		var exist bool
-		tPrefix := typePrefix
-		if field, exist = elem.(*proto.NormalField); exist {
-			var doc = new(MDocsField)
-			if field.Repeated {
-				tPrefix = fmt.Sprintf("Array::%s", tPrefix)
-			}
-			switch field.Type {
-			case "uint32", "uint64", "int32", "int64", "sint32", "sint64", "fixed32", "fixed64", "sfixed32", "sfixed64":
-				doc.FieldType = fmt.Sprintf("%sinteger", tPrefix)
-			case "double", "float":
-				doc.FieldType = fmt.Sprintf("%sfloat", tPrefix)
-			case "string":
-				doc.FieldType = fmt.Sprintf("%sstring", tPrefix)
-			case "bool":
-				doc.FieldType = fmt.Sprintf("%sbool", tPrefix)
-			default:
-				// 如果是枚举
-				if e, ok := Visitor.AllEnumMap[field.Type]; ok {
-					enumName := e.Name
-					var edoc []*MDocsField
-					for _, elem := range e.Elements {
-						ee, assert := elem.(*proto.EnumField)
-						if assert {
-							//logrus.Infof("name: %+v, val: %+v", ee.Name, ee.Integer)
-							var inlineMsg string
-							if ee.InlineComment != nil {
-								inlineMsg = ee.InlineComment.Message()
-							}
-							doc := &MDocsField{
-								FieldName:  ee.Name,
-								FieldDesc:  inlineMsg,
-								FieldValue: ee.Integer,
-							}
-							edoc = append(edoc, doc)
-						}
-					}
-					Visitor.AddDocEnum(enumName, edoc)
-					doc.FieldType = fmt.Sprintf("%s%s(integer枚举)", tPrefix, field.Type)
-				} else {
-					doc.FieldType = fmt.Sprintf("%s%s(object对象)", tPrefix, field.Type)
-					// 如果时外部导入的字段
-					if strings.Contains(field.Type, ".") {
-						t := strings.Split(field.Type, ".")
-						if len(t) == 2 {
-							pkg := t[0]
-							name := t[1]
-							if _, exist := Visitor.MDocDepMessageMap[pkg]; exist {
-								if depMessage, exist1 := Visitor.MDocDepMessageMap[pkg][name]; exist1 {
-									docField = append(docField, getFieldDOC(depMessage, field.Type)...)
-								}
-							}
-						}
-					}
-				}
-			}
-
-			// 分析这个字段头部注释
-			if field.Comment == nil || len(field.Comment.Lines) == 0 {
-				doc.FieldName = calm2CaseBSON(field.Name)
-				if field.InlineComment != nil && field.InlineComment.Message() != "" {
-					doc.FieldDesc = strings.TrimLeft(field.InlineComment.Message(), " ")
-				} else {
-					doc.FieldDesc = "-"
+		switch field := elem.(type) {
+		case *proto.NormalField:
+			docField = append(docField, g.fieldDoc(msg, field.Field, field.Repeated, path))
+		case *proto.MapField:
+			docField = append(docField, g.mapFieldDoc(msg, field, path))
+		case *proto.Oneof:
+			for _, oe := range field.Elements {
+				of, ok := oe.(*proto.OneOfField)
+				if !ok {
+					continue
 				}
+				doc := g.fieldDoc(msg, of.Field, false, path)
+				doc.Oneof = field.Name
 				docField = append(docField, doc)
-				// 这个字段是否是内嵌msg
-				if !isBuiltInType(field.Type) {
-					realMsg, ok := Visitor.AllMsgMap[field.Type]
-					if !ok {
-						realMsg, ok = Visitor.AllMsgMap[fmt.Sprintf("%s_%s", getOuterForefathersNameJoin(msg), field.Type)]
-						if !ok {
-							continue
-						}
-					}
-					docField = append(docField, getFieldDOC(realMsg, field.Type)...)
-				}
-				continue
 			}
+		}
+	}
 
-			coms := field.Comment.Lines
+	return docField
+}
 
-			for _, line := range coms {
-				descReg := regexp.MustCompile(`@desc:\s*(.*)`)
-				descRes := descReg.FindAllStringSubmatch(line, -1)
-				if len(descRes) == 1 && len(descRes[0]) == 2 {
-					doc.FieldDesc = descRes[0][1]
-				} else {
-					if field.InlineComment != nil && field.InlineComment.Message() != "" {
-						doc.FieldDesc = strings.TrimLeft(field.InlineComment.Message(), " ")
-					}
-				}
+// fieldDoc 普通字段与 oneof 中字段的文档
+func (g *Generator) fieldDoc(msg *proto.Message, field *proto.Field, repeated bool, path []*proto.Message) *MDocsField {
+	var doc = new(MDocsField)
+	g.fieldDocComment(doc, field)
+	doc.FieldType, doc.Children = g.fieldDocType(msg, field.Type, path)
+	if repeated {
+		doc.FieldType = fmt.Sprintf("Array::%s", doc.FieldType)
+	}
+	return doc
+}
 
-				requireReg := regexp.MustCompile(`@v:\s*(.*)`)
-				requireRes := requireReg.FindAllStringSubmatch(line, -1)
-				if len(requireRes) == 1 && len(requireRes[0]) == 2 {
-					rule := requireRes[0][1]
-					if strings.Contains(rule, "required") {
-						doc.IsRequire = true
-					}
-				}
+// mapFieldDoc map 字段的文档 value 为 message 时展开到 Children 中
+func (g *Generator) mapFieldDoc(msg *proto.Message, field *proto.MapField, path []*proto.Message) *MDocsField {
+	var doc = new(MDocsField)
+	g.fieldDocComment(doc, field.Field)
+	doc.MapKey, _ = g.fieldDocType(msg, field.KeyType, path)
+	doc.MapValue, doc.Children = g.fieldDocType(msg, field.Type, path)
+	doc.FieldType = fmt.Sprintf("Map<%s, %s>", doc.MapKey, doc.MapValue)
+	return doc
+}
 
-				jsonReg := regexp.MustCompile(`@json:\s*(.*)`)
-				jsonRes := jsonReg.FindAllStringSubmatch(line, -1)
-				if len(jsonRes) == 1 && len(jsonRes[0]) == 2 {
-					doc.FieldName = jsonRes[0][1]
-					Visitor.AddDocJSONMap(field.Name, doc.FieldName)
-				}
-			}
+// fieldDocType 字段类型说明 message 类型同时返回其字段文档
+func (g *Generator) fieldDocType(msg *proto.Message, typ string, path []*proto.Message) (string, []*MDocsField) {
+	switch typ {
+	case "uint32", "uint64", "int32", "int64", "sint32", "sint64", "fixed32", "fixed64", "sfixed32", "sfixed64":
+		return "integer", nil
+	case "double", "float":
+		return "float", nil
+	case "string":
+		return "string", nil
+	case "bool":
+		return "bool", nil
+	}
+
+	// 如果是枚举
+	if e := g.Visitor.LookupEnum(typ); e != nil {
+		g.addDocEnum(e)
+		return fmt.Sprintf("%s(integer枚举)", typ), nil
+	}
+	return fmt.Sprintf("%s(object对象)", typ), g.getFieldDOC(g.lookupDocMessage(msg, typ), path)
+}
 
-			if len(coms) == 0 && field.InlineComment != nil && field.InlineComment.Message() != "" {
-				doc.FieldDesc = strings.TrimLeft(field.InlineComment.Message(), " ")
+// lookupDocMessage 查找字段类型对应的 message
+func (g *Generator) lookupDocMessage(msg *proto.Message, typ string) *proto.Message {
+	// 如果时外部导入的字段
+	if strings.Contains(typ, ".") {
+		t := strings.Split(typ, ".")
+		if len(t) == 2 {
+			if depMessage, exist := g.Visitor.MDocDepMessageMap[t[0]][t[1]]; exist {
+				return depMessage
 			}
+		}
+		// 没有手动传入依赖 从 import 的符号表中查找
+		return g.Visitor.LookupMessage(typ)
+	}
+
+	if realMsg, ok := g.Visitor.AllMsgMap[typ]; ok {
+		return realMsg
+	}
+	return g.Visitor.AllMsgMap[fmt.Sprintf("%s_%s", getOuterForefathersNameJoin(msg), typ)]
+}
+
+// addDocEnum 记录字段用到的枚举
+func (g *Generator) addDocEnum(e *proto.Enum) {
+	var edoc []*MDocsField
+	for _, elem := range e.Elements {
+		ee, assert := elem.(*proto.EnumField)
+		if !assert {
+			continue
+		}
+		var inlineMsg string
+		if ee.InlineComment != nil {
+			inlineMsg = trim(ee.InlineComment.Message())
+		}
+		edoc = append(edoc, &MDocsField{
+			FieldName:  ee.Name,
+			FieldDesc:  inlineMsg,
+			FieldValue: ee.Integer,
+		})
+	}
+	g.Visitor.AddDocEnum(e.Name, edoc)
+}
+
+// fieldDocComment 分析字段注释 获取字段名 说明 是否必须
+func (g *Generator) fieldDocComment(doc *MDocsField, field *proto.Field) {
+	if field.InlineComment != nil && field.InlineComment.Message() != "" {
+		doc.FieldDesc = strings.TrimLeft(field.InlineComment.Message(), " ")
+	}
 
-			if doc.FieldName == "" {
-				doc.FieldName = calm2CaseBSON(field.Name)
+	if field.Comment != nil {
+		for _, line := range field.Comment.Lines {
+			descReg := regexp.MustCompile(`@desc:\s*(.*)`)
+			descRes := descReg.FindAllStringSubmatch(line, -1)
+			if len(descRes) == 1 && len(descRes[0]) == 2 {
+				doc.FieldDesc = descRes[0][1]
 			}
 
-			// 这个字段是否是内嵌msg
-			if !isBuiltInType(field.Type) {
-				//logrus.Infof("comment father: %+v, field type: %+v", getOuterForefathersNameJoin(msg), field.Type)
-				realMsg, ok := Visitor.AllMsgMap[field.Type]
-				if !ok {
-					realMsg = Visitor.AllMsgMap[fmt.Sprintf("%s_%s", getOuterForefathersNameJoin(msg), field.Type)]
+			requireReg := regexp.MustCompile(`@v:\s*(.*)`)
+			requireRes := requireReg.FindAllStringSubmatch(line, -1)
+			if len(requireRes) == 1 && len(requireRes[0]) == 2 {
+				rule := requireRes[0][1]
+				if strings.Contains(rule, "required") {
+					doc.IsRequire = true
 				}
-				docField = append(docField, getFieldDOC(realMsg, field.Type)...)
 			}
 
-			docField = append(docField, doc)
-			continue
+			jsonReg := regexp.MustCompile(`@json:\s*(.*)`)
+			jsonRes := jsonReg.FindAllStringSubmatch(line, -1)
+			if len(jsonRes) == 1 && len(jsonRes[0]) == 2 {
+				doc.FieldName = jsonRes[0][1]
+				g.Visitor.AddDocJSONMap(field.Name, doc.FieldName)
+			}
 		}
 	}
 
-	return docField
+	if doc.FieldName == "" {
+		doc.FieldName = calm2CaseBSON(field.Name)
+	}
+	if doc.FieldDesc == "" {
+		doc.FieldDesc = "-"
+	}
 }
 
-func getSrvMsgDetail(msg *proto.Message) {
-	docField := getFieldDOC(msg, "")
+func (g *Generator) getSrvMsgDetail(msg *proto.Message) {
+	docField := g.getFieldDOC(msg, nil)
 
 	if strings.HasSuffix(msg.Name, NameReq) {
-		Visitor.MDoc.ReqFields = docField
+		g.Visitor.MDoc.ReqFields = docField
 		return
 	}
 
 	if strings.HasSuffix(msg.Name, NameResp) {
-		Visitor.MDoc.RespFields = docField
+		g.Visitor.MDoc.RespFields = docField
 		return
 	}
 }
 
 // PbToJson 传入proto的数据，返回它对应的json数据
-func pbMsgToJSON(protoPath, messageName string) ([]byte, error) {
-	fd := getProtoFileDescriptor(protoPath)
+func (g *Generator) pbMsgToJSON(protoPath, messageName string) ([]byte, error) {
+	fd := g.getProtoFileDescriptor(protoPath)
+	if fd == nil {
+		return nil, fmt.Errorf("parse proto file %s failed", protoPath)
+	}
 
 	msg := fd.FindMessage(messageName)
+	if msg == nil {
+		return nil, fmt.Errorf("message %s not found", messageName)
+	}
 
-	data := convertMessageToMap(msg)
+	data := newMDExample(g.Visitor.MDoc.FieldJSONMap).message(msg, 0)
 	bs, err := json.MarshalIndent(data, "", "\t")
 	return bs, err
 }
 
-func convertMessageToMap(message *desc.MessageDescriptor) map[string]interface{} {
-	m := make(map[string]interface{})
-	for _, fieldDescriptor := range message.GetFields() {
-		fieldName := fieldDescriptor.GetName()
-		if realName, exist := Visitor.MDoc.FieldJSONMap[fieldName]; exist {
-			fieldName = realName
-		}
-		switch fieldDescriptor.GetType() {
-		case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
-			if fieldDescriptor.IsRepeated() {
-				// 如果是一个数组的话
-				m[fieldName] = []interface{}{convertMessageToMap(fieldDescriptor.GetMessageType())}
-				continue
-			}
-			m[fieldName] = convertMessageToMap(fieldDescriptor.GetMessageType())
-			continue
-		default:
-			if fieldDescriptor.IsRepeated() {
-				switch fieldDescriptor.GetType() {
-				case descriptor.FieldDescriptorProto_TYPE_BOOL:
-					m[fieldName] = []interface{}{false, true}
-				case descriptor.FieldDescriptorProto_TYPE_STRING:
-					m[fieldName] = []interface{}{""}
-				default:
-					m[fieldName] = []interface{}{0}
-				}
-				continue
-			}
-			m[fieldName] = fieldDescriptor.GetDefaultValue()
-		}
+func (g *Generator) getProtoFileDescriptor(pbFile string) *desc.FileDescriptor {
+	p := protoparse.Parser{IncludeSourceCodeInfo: true}
+	// 有 import 查找路径时 文件名需要相对于查找路径
+	if len(g.Visitor.IncludePaths) != 0 {
+		p.ImportPaths = append([]string{path.Dir(pbFile)}, g.Visitor.IncludePaths...)
+		pbFile = path.Base(pbFile)
 	}
-	return m
-}
-
-func getProtoFileDescriptor(path string) *desc.FileDescriptor {
-	p := protoparse.Parser{}
-	fds, err := p.ParseFiles(path)
+	fds, err := p.ParseFiles(pbFile)
 	if err != nil {
 		logrus.Errorf("getProto ParseFiles error:%v", err)
 		return nil
@@ -580,11 +650,11 @@ func getProtoFileDescriptor(path string) *desc.FileDescriptor {
 	return fd
 }
 
-func mdLoadAndGetAllMessage(m *proto.Message) {
-	Visitor.AddMDocDepMessage(Visitor.MDocDepPkgName, m)
+func (g *Generator) mdLoadAndGetAllMessage(m *proto.Message) {
+	g.Visitor.AddMDocDepMessage(g.Visitor.MDocDepPkgName, m)
 }
 
-func mdLoadPackage(p *proto.Package) {
+func (g *Generator) mdLoadPackage(p *proto.Package) {
 	pkgName := strings.ReplaceAll(p.Name, ".", "_")
-	Visitor.MDocDepPkgName = pkgName
+	g.Visitor.MDocDepPkgName = pkgName
 }
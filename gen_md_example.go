@@ -0,0 +1,203 @@
+package proto_parser
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+)
+
+// mdExampleMaxDepth 示例中 message 的最大嵌套层数 超过后输出空对象
+const mdExampleMaxDepth = 5
+
+// mdWellKnownExamples google.protobuf 中常用类型按 json 映射的示例
+var mdWellKnownExamples = map[string]func() interface{}{
+	"google.protobuf.Timestamp": func() interface{} { return "2021-01-01T00:00:00Z" },
+	"google.protobuf.Duration":  func() interface{} { return "1.5s" },
+	"google.protobuf.Struct":    func() interface{} { return map[string]interface{}{"key": "value"} },
+	"google.protobuf.Value":     func() interface{} { return "value" },
+	"google.protobuf.ListValue": func() interface{} { return []interface{}{"value"} },
+	"google.protobuf.Empty":     func() interface{} { return map[string]interface{}{} },
+	"google.protobuf.FieldMask": func() interface{} { return "field.path" },
+	"google.protobuf.Any": func() interface{} {
+		return map[string]interface{}{"@type": "type.googleapis.com/google.protobuf.Empty"}
+	},
+	"google.protobuf.DoubleValue": func() interface{} { return 1.5 },
+	"google.protobuf.FloatValue":  func() interface{} { return 1.5 },
+	"google.protobuf.Int64Value":  func() interface{} { return "1" },
+	"google.protobuf.UInt64Value": func() interface{} { return "1" },
+	"google.protobuf.Int32Value":  func() interface{} { return 1 },
+	"google.protobuf.UInt32Value": func() interface{} { return 1 },
+	"google.protobuf.BoolValue":   func() interface{} { return true },
+	"google.protobuf.StringValue": func() interface{} { return "string" },
+	"google.protobuf.BytesValue":  func() interface{} { return "Ynl0ZXM=" },
+}
+
+// mdExample 生成请求响应示例
+type mdExample struct {
+	jsonMap map[string]string // 字段名=>@json 名 字段自身没有注解时使用
+	path    map[string]bool   // 当前路径上的 message 用于检测递归
+}
+
+func newMDExample(jsonMap map[string]string) *mdExample {
+	return &mdExample{jsonMap: jsonMap, path: make(map[string]bool)}
+}
+
+// message 生成 message 的示例 递归引用或超过最大层数时输出空对象
+// oneof 只输出第一个字段
+func (e *mdExample) message(md *desc.MessageDescriptor, depth int) map[string]interface{} {
+	m := make(map[string]interface{})
+	name := md.GetFullyQualifiedName()
+	if e.path[name] || depth >= mdExampleMaxDepth {
+		return m
+	}
+	e.path[name] = true
+	defer delete(e.path, name)
+
+	var oneofs = make(map[string]bool)
+	for _, fd := range md.GetFields() {
+		if oneof := fd.GetOneOf(); oneof != nil {
+			if oneofs[oneof.GetName()] {
+				continue
+			}
+			oneofs[oneof.GetName()] = true
+		}
+		m[e.fieldName(fd)] = e.field(fd, depth)
+	}
+	return m
+}
+
+// fieldName 字段的 json 名 优先字段自身的 @json 注解
+func (e *mdExample) fieldName(fd *desc.FieldDescriptor) string {
+	if info := fd.GetSourceInfo(); info != nil {
+		reg := regexp.MustCompile(RegexpJson)
+		if res := reg.FindStringSubmatch(info.GetLeadingComments()); len(res) == 2 {
+			return res[1]
+		}
+	}
+	if realName, exist := e.jsonMap[fd.GetName()]; exist {
+		return realName
+	}
+	return fd.GetName()
+}
+
+func (e *mdExample) field(fd *desc.FieldDescriptor, depth int) interface{} {
+	if v, ok := fieldExample(fd); ok {
+		return v
+	}
+	if fd.IsMap() {
+		return map[string]interface{}{
+			mapKeyExample(fd.GetMapKeyType()): e.value(fd.GetMapValueType(), depth),
+		}
+	}
+	v := e.value(fd, depth)
+	if fd.IsRepeated() {
+		return []interface{}{v}
+	}
+	return v
+}
+
+// value 单个值的示例
+func (e *mdExample) value(fd *desc.FieldDescriptor, depth int) interface{} {
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
+		md := fd.GetMessageType()
+		if fn, ok := mdWellKnownExamples[md.GetFullyQualifiedName()]; ok {
+			return fn()
+		}
+		return e.message(md, depth+1)
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		return enumExample(fd.GetEnumType())
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return true
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return "string"
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return "Ynl0ZXM="
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE, descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		return 1.5
+	}
+	if isInt64Field(fd) {
+		return "1"
+	}
+	return 1
+}
+
+// isInt64Field 64 位整数在 json 映射中按字符串输出
+func isInt64Field(fd *desc.FieldDescriptor) bool {
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_INT64, descriptor.FieldDescriptorProto_TYPE_UINT64,
+		descriptor.FieldDescriptorProto_TYPE_SINT64, descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return true
+	}
+	return false
+}
+
+// int64Example 64 位整数字段 @example 中的数字转为字符串
+func int64Example(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		return val.String()
+	case []interface{}:
+		for i := range val {
+			val[i] = int64Example(val[i])
+		}
+	}
+	return v
+}
+
+// fieldExample 字段注释中 @example 指定的示例 能解析成 json 时按 json 输出 否则按字符串输出
+// 64 位整数字段的数字示例按 json 映射转为字符串
+func fieldExample(fd *desc.FieldDescriptor) (interface{}, bool) {
+	info := fd.GetSourceInfo()
+	if info == nil {
+		return nil, false
+	}
+	reg := regexp.MustCompile(RegexpExample)
+	res := reg.FindStringSubmatch(info.GetLeadingComments() + "\n" + info.GetTrailingComments())
+	if len(res) != 2 {
+		return nil, false
+	}
+	raw := strings.TrimSpace(res[1])
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		v = raw
+	}
+	if !fd.IsMap() && isInt64Field(fd) {
+		v = int64Example(v)
+	}
+	if _, isArray := v.([]interface{}); fd.IsRepeated() && !fd.IsMap() && !isArray {
+		v = []interface{}{v}
+	}
+	return v, true
+}
+
+// enumExample 枚举按 json 映射输出枚举名 优先取第一个非 0 值
+func enumExample(ed *desc.EnumDescriptor) interface{} {
+	values := ed.GetValues()
+	if len(values) == 0 {
+		return 0
+	}
+	for _, v := range values {
+		if v.GetNumber() != 0 {
+			return v.GetName()
+		}
+	}
+	return values[0].GetName()
+}
+
+// mapKeyExample map 的 key 在 json 中均为字符串
+func mapKeyExample(fd *desc.FieldDescriptor) string {
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return "key"
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return "true"
+	}
+	return "1"
+}
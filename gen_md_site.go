@@ -0,0 +1,267 @@
+package proto_parser
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/emicklei/proto"
+	"github.com/sirupsen/logrus"
+)
+
+// mdSiteRPC 文档站中的一个接口
+type mdSiteRPC struct {
+	Service  string
+	File     string // 所在 service 的页面
+	Path     string // 带路由组前缀的完整路径
+	Node     *GroupRouterNode
+	Doc      string   // 按 DocConfig 渲染的接口文档
+	Enums    []string // 接口用到的枚举
+	ErrCodes []string // 接口声明的错误码
+}
+
+// mdSiteService 文档站中的一个路由组 service 对应一个文件
+type mdSiteService struct {
+	Name  string
+	File  string
+	Proto string
+	Rpcs  []*mdSiteRPC
+}
+
+// mdSiteEnum 枚举说明页中的一个枚举
+type mdSiteEnum struct {
+	Name   string
+	Fields []*MDocsField
+	UsedBy []*mdSiteRPC
+}
+
+// mdSiteErrCode 错误码说明页中的一个错误码
+type mdSiteErrCode struct {
+	MDocsErrCodeField
+	UsedBy []*mdSiteRPC
+}
+
+// OutputMDSite 为目录下所有路由组 service 生成 markdown 文档到 outDir
+// 每个 service 一个文件 以 proto 包名加 service 名命名 另外输出目录页 index.md 枚举说明 enums.md 与错误码说明 errcode.md
+// includes 为额外依赖的 proto 文件 目录下的 proto 文件会自动作为依赖
+// config 的 Template TemplateFile 用于渲染每个接口 Locale 同时作用于各页面 只支持 markdown 格式
+func (g *Generator) OutputMDSite(protoDir, outDir string, includes []string, config ...*DocConfig) error {
+	var c = &DocConfig{}
+	if len(config) != 0 && config[0] != nil {
+		c = config[0]
+	}
+	if c.Format != "" && c.Format != DocFormatMarkdown {
+		err := fmt.Errorf("OutputMDSite only supports markdown, got: %s", c.Format)
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+	labels, err := docLocaleLabels(c.Locale)
+	if err != nil {
+		return err
+	}
+
+	pbFiles, err := findProtoFiles(protoDir)
+	if err != nil {
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+	includes = append(append([]string(nil), includes...), pbFiles...)
+
+	var services []*mdSiteService
+	var enums = make(map[string]*mdSiteEnum)
+	var errCodes = make(map[string]*mdSiteErrCode)
+	for _, pbFile := range pbFiles {
+		groups, err := g.mdRouteGroups(pbFile)
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			var srv = &mdSiteService{
+				Name:  group.Name,
+				File:  group.Name + ".md",
+				Proto: pbFile,
+			}
+			// 不同 proto 中可以有同名的 service
+			if group.Package != "" {
+				srv.File = group.Package + "." + srv.File
+			}
+			for _, rpcName := range group.Rpcs {
+				mdoc, err := g.buildMDoc(pbFile, group.Name, rpcName, includes)
+				if err != nil {
+					logrus.Warnf("skip %s.%s in %s: %v", group.Name, rpcName, pbFile, err)
+					continue
+				}
+				doc, err := renderDoc(mdoc, c)
+				if err != nil {
+					return err
+				}
+				var rpc = &mdSiteRPC{
+					Service: group.Name,
+					File:    srv.File,
+					Path:    group.Paths[rpcName],
+					Node:    mdoc.Node,
+					Doc:     doc,
+				}
+				for name, fields := range mdoc.EnumFields {
+					rpc.Enums = append(rpc.Enums, name)
+					if _, ok := enums[name]; !ok {
+						enums[name] = &mdSiteEnum{Name: name, Fields: fields}
+					}
+					enums[name].UsedBy = append(enums[name].UsedBy, rpc)
+				}
+				sort.Strings(rpc.Enums)
+				for _, ec := range mdoc.ErrCodeList {
+					rpc.ErrCodes = append(rpc.ErrCodes, ec.Name)
+					if _, ok := errCodes[ec.Name]; !ok {
+						errCodes[ec.Name] = &mdSiteErrCode{MDocsErrCodeField: ec}
+					}
+					errCodes[ec.Name].UsedBy = append(errCodes[ec.Name].UsedBy, rpc)
+				}
+				srv.Rpcs = append(srv.Rpcs, rpc)
+			}
+			services = append(services, srv)
+		}
+	}
+	sort.SliceStable(services, func(i, j int) bool {
+		return services[i].File < services[j].File
+	})
+
+	var enumList []*mdSiteEnum
+	for _, e := range enums {
+		enumList = append(enumList, e)
+	}
+	sort.Slice(enumList, func(i, j int) bool {
+		return enumList[i].Name < enumList[j].Name
+	})
+	var errCodeList []*mdSiteErrCode
+	for _, ec := range errCodes {
+		errCodeList = append(errCodeList, ec)
+	}
+	sort.Slice(errCodeList, func(i, j int) bool {
+		if errCodeList[i].Code != errCodeList[j].Code {
+			return errCodeList[i].Code < errCodeList[j].Code
+		}
+		return errCodeList[i].Name < errCodeList[j].Name
+	})
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+	var pages = []mdSitePage{
+		{"index.md", MDSiteIndexTpl, services},
+		{"enums.md", MDSiteEnumTpl, enumList},
+		{"errcode.md", MDSiteErrCodeTpl, errCodeList},
+	}
+	for _, srv := range services {
+		pages = append(pages, mdSitePage{srv.File, MDSiteServiceTpl, srv})
+	}
+	for _, page := range pages {
+		if err := writeMDSitePage(filepath.Join(outDir, page.file), page.tpl, page.data, labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mdSitePage 文档站中的一个页面
+type mdSitePage struct {
+	file string
+	tpl  string
+	data interface{}
+}
+
+// mdRouteGroup proto 文件中的一个路由组
+type mdRouteGroup struct {
+	Name    string
+	Package string
+	Rpcs    []string
+	Paths   map[string]string // rpc => 带路由组前缀的完整路径
+}
+
+// mdRouteGroups proto 文件中所有的路由组与其中的 rpc
+func (g *Generator) mdRouteGroups(pbFile string) ([]*mdRouteGroup, error) {
+	definition, err := openProtoFile(pbFile)
+	if err != nil {
+		return nil, err
+	}
+	g.reset()
+	proto.Walk(definition,
+		proto.WithPackage(g.loadPackage),
+		proto.WithService(g.parseSrvGenRouter),
+	)
+	var res []*mdRouteGroup
+	for _, name := range g.groupRouterNames() {
+		router := g.Visitor.GroupRouterMap[name]
+		var group = &mdRouteGroup{
+			Name:    name,
+			Package: g.Visitor.ProtoPackage,
+			Paths:   make(map[string]string),
+		}
+		for _, node := range router.Apis {
+			group.Rpcs = append(group.Rpcs, node.FuncName)
+			group.Paths[node.FuncName] = routeFullPath(router.RouterPrefix, node.RouterPath)
+		}
+		res = append(res, group)
+	}
+	return res, nil
+}
+
+// findProtoFiles 递归查找目录下的 proto 文件 忽略残留的 origin_ 文件
+func findProtoFiles(dir string) ([]string, error) {
+	var res []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(p) != ".proto" || strings.HasPrefix(info.Name(), "origin_") {
+			return nil
+		}
+		res = append(res, p)
+		return nil
+	})
+	return res, err
+}
+
+// mdAnchor markdown 标题对应的锚点 与 GitHub 的规则一致 去掉标点 空格换成 -
+func mdAnchor(title string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(title)) {
+		switch {
+		case r == ' ':
+			b.WriteRune('-')
+		case r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func writeMDSitePage(file, tpl string, data interface{}, labels map[string]string) error {
+	t, err := template.New("md_site").Funcs(template.FuncMap{
+		"anchor": mdAnchor,
+		"i18n": func(key string) string {
+			return labels[key]
+		},
+	}).Parse(tpl)
+	if err != nil {
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0666); err != nil {
+		logrus.Errorf("write %s err: %+v", file, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package proto_parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputMDSite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "md_site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := `syntax = "proto3";
+package user;
+
+enum ErrCode {
+    None = 0;
+    ErrUserNotFound = 10001; // 用户不存在
+}
+
+enum Gender {
+    Unknown = 0; // 未知
+    Male = 1; // 男
+}
+
+message GetUserReq {
+    int64 id = 1;
+}
+
+message GetUserResp {
+    Gender gender = 1;
+}
+
+// @route_group: true
+// @route_api: /api/user
+service UserAPI {
+    // @desc: 获取用户
+    // @author: a
+    // @method: GET
+    // @api: /get
+    // @error:
+    // ErrUserNotFound
+    rpc GetUser (GetUserReq) returns (GetUserResp);
+    option deprecated = false;
+    rpc Ping (GetUserReq) returns (GetUserResp);
+}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "user.proto"), []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// 其他 proto 中同名的 service 使用不同的页面
+	admin := `syntax = "proto3";
+package admin;
+
+message GetUserReq {
+    int64 id = 1;
+}
+
+message GetUserResp {
+    string name = 1;
+}
+
+// @route_group: true
+service UserAPI {
+    // @desc: 管理员获取用户
+    // @method: GET
+    rpc GetUser (GetUserReq) returns (GetUserResp);
+}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "admin.proto"), []byte(admin), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// 以 API 结尾的 service 没有注释时也是路由组
+	order := `syntax = "proto3";
+package order;
+
+message ListOrderReq {
+    int64 uid = 1;
+}
+
+message ListOrderResp {
+    int64 total = 1;
+}
+
+service OrderAPI {
+    rpc ListOrder (ListOrderReq) returns (ListOrderResp);
+}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "order.proto"), []byte(order), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	outDir := filepath.Join(dir, "docs")
+	if err := OutputMDSite(dir, outDir, nil); err != nil {
+		t.Fatal(err)
+	}
+	var expects = map[string]string{
+		"index.md":          "| [GetUser](user.UserAPI.md#getuser) | GET | `/api/user/get` |",
+		"user.UserAPI.md":   "[Gender](enums.md#gender)",
+		"admin.UserAPI.md":  "管理员获取用户",
+		"order.OrderAPI.md": "- `/list_order`",
+		"enums.md":          "| Male | 1 | 男 |",
+		"errcode.md":        "- 错误码: 10001",
+	}
+	for file, expect := range expects {
+		data, err := ioutil.ReadFile(filepath.Join(outDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), expect) {
+			t.Errorf("%s should contain %q, got:\n%s", file, expect, data)
+		}
+	}
+	// 没有注释的 rpc 按默认的 POST 与路由生成文档
+	data, _ := ioutil.ReadFile(filepath.Join(outDir, "index.md"))
+	for _, expect := range []string{"| [Ping](user.UserAPI.md#ping) | POST | `/api/user/ping` |", "[ListOrder](order.OrderAPI.md#listorder)"} {
+		if !strings.Contains(string(data), expect) {
+			t.Errorf("index.md should contain %q, got:\n%s", expect, data)
+		}
+	}
+
+	// 语言与模板作用于所有页面
+	enDir := filepath.Join(dir, "docs_en")
+	err = OutputMDSite(dir, enDir, nil, &DocConfig{Locale: DocLocaleEn, Template: "custom {{.Node.FuncName}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expects = map[string]string{
+		"index.md":        "|API|Method|URL|Summary|",
+		"user.UserAPI.md": "custom GetUser",
+		"errcode.md":      "- Code: 10001",
+	}
+	for file, expect := range expects {
+		data, err := ioutil.ReadFile(filepath.Join(enDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), expect) {
+			t.Errorf("%s should contain %q, got:\n%s", file, expect, data)
+		}
+	}
+}
@@ -3,13 +3,16 @@ package proto_parser
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/emicklei/proto"
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
 	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"strings"
 	"testing"
 )
 
 func Test_convertMessageToMap(t *testing.T) {
-	f := getProtoFileDescriptor("parser.proto")
+	f := NewGenerator().getProtoFileDescriptor("parser.proto")
 
 	fd := f.FindMessage("proto_parser.ModelOperLog")
 
@@ -17,7 +20,8 @@ func Test_convertMessageToMap(t *testing.T) {
 		panic("fd nil")
 	}
 
-	var convertMessageToMap = func(message *desc.MessageDescriptor) map[string]interface{} {
+	var convertMessageToMap func(message *desc.MessageDescriptor) map[string]interface{}
+	convertMessageToMap = func(message *desc.MessageDescriptor) map[string]interface{} {
 		m := make(map[string]interface{})
 		for _, fieldDescriptor := range message.GetFields() {
 			fieldName := fieldDescriptor.GetName()
@@ -56,3 +60,137 @@ func Test_convertMessageToMap(t *testing.T) {
 	}
 	fmt.Println(string(bs))
 }
+
+func TestRenderDoc(t *testing.T) {
+	mdoc := &MDocs{
+		Node:     &GroupRouterNode{FuncName: "Get", RouterPath: "/get", Method: "GET", Describe: "<get>"},
+		ReqBody:  "{}",
+		RespBody: "{}",
+	}
+
+	// markdown 与其他格式共用一份文案
+	out, err := renderDoc(mdoc, &DocConfig{})
+	if err != nil || !strings.Contains(out, "**简要描述:**") || !strings.Contains(out, "**返回示例**") {
+		t.Errorf("unexpected zh doc: %v\n%s", err, out)
+	}
+	out, err = renderDoc(mdoc, &DocConfig{Locale: DocLocaleEn})
+	if err != nil || !strings.Contains(out, "**Summary:**") || !strings.Contains(out, "**Response example**") {
+		t.Errorf("unexpected en doc: %v\n%s", err, out)
+	}
+	out, err = renderDoc(mdoc, &DocConfig{Format: DocFormatHTML})
+	if err != nil || !strings.Contains(out, "<h3>简要描述</h3>") || !strings.Contains(out, "&lt;get&gt;") {
+		t.Errorf("unexpected html doc: %v\n%s", err, out)
+	}
+	out, err = renderDoc(mdoc, &DocConfig{Format: DocFormatAsciiDoc, Locale: DocLocaleEn})
+	if err != nil || !strings.Contains(out, "*Summary:*") {
+		t.Errorf("unexpected asciidoc doc: %v\n%s", err, out)
+	}
+	out, err = renderDoc(mdoc, &DocConfig{Template: `{{.Node.Method}} {{.Node.RouterPath}} {{i18n "method"}}`, Locale: DocLocaleEn})
+	if err != nil || out != "GET /get Method" {
+		t.Errorf("unexpected custom doc: %v\n%s", err, out)
+	}
+	if _, err := renderDoc(mdoc, &DocConfig{Locale: "fr"}); err == nil {
+		t.Errorf("expect unsupported locale error")
+	}
+}
+
+func TestMDExample(t *testing.T) {
+	src := `syntax = "proto3";
+package test;
+
+import "google/protobuf/timestamp.proto";
+
+enum Gender {
+    Unknown = 0;
+    Male = 1;
+}
+
+message Node {
+    // @json: node_name
+    // @example: "root"
+    string name = 1;
+    repeated Node children = 2;
+    Gender gender = 3;
+    map<string, int64> tags = 4;
+    oneof value {
+        int64 num = 5;
+        string text = 6;
+    }
+    google.protobuf.Timestamp created_at = 7;
+    // @example: 1 2
+    repeated string ids = 8;
+    // @example: [12345678901234567]
+    repeated uint64 uids = 9;
+    sint32 level = 10;
+}
+`
+	p := protoparse.Parser{
+		IncludeSourceCodeInfo: true,
+		Accessor:              protoparse.FileContentsFromMap(map[string]string{"test.proto": src}),
+	}
+	fds, err := p.ParseFiles("test.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMDExample(nil).message(fds[0].FindMessage("test.Node"), 0)
+	data, _ := json.Marshal(m)
+	for _, expect := range []string{`"node_name":"root"`, `"gender":"Male"`, `"tags":{"key":"1"}`,
+		`"num":"1"`, `"created_at":"2021-01-01T00:00:00Z"`, `"ids":["1 2"]`, `"children":[{}]`,
+		`"uids":["12345678901234567"]`, `"level":1`} {
+		if !strings.Contains(string(data), expect) {
+			t.Errorf("expect %s in %s", expect, data)
+		}
+	}
+	if strings.Contains(string(data), `"text"`) {
+		t.Errorf("only the first oneof field is expected: %s", data)
+	}
+}
+
+func TestGetFieldDOC(t *testing.T) {
+	src := `syntax = "proto3";
+package test;
+
+message Item {
+    string name = 1;
+    repeated Item children = 2;
+}
+
+message ListResp {
+    // @json: item_map
+    map<string, Item> items = 1;
+    oneof filter {
+        string keyword = 2;
+        int64 id = 3;
+    }
+}
+`
+	definition, err := proto.NewParser(strings.NewReader(src)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGenerator()
+	g.Visitor.MDoc = &MDocs{}
+	var resp *proto.Message
+	proto.Walk(definition, proto.WithMessage(func(m *proto.Message) {
+		g.getSrvMsg(m)
+		if m.Name == "ListResp" {
+			resp = m
+		}
+	}))
+
+	rows := DocRows(g.getFieldDOC(resp, nil))
+	var got []string
+	for _, row := range rows {
+		got = append(got, fmt.Sprintf("%d %s %s %s", row.Level, row.FieldName, row.FieldType, row.Oneof))
+	}
+	want := []string{
+		"0 item_map Map<string, Item(object对象)> ",
+		"1 name string ",
+		"1 children Array::Item(object对象) ",
+		"0 keyword string filter",
+		"0 id integer filter",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected rows:\n%s", strings.Join(got, "\n"))
+	}
+}
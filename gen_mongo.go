@@ -0,0 +1,323 @@
+package proto_parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/emicklei/proto"
+	"github.com/sirupsen/logrus"
+)
+
+// mongoBsonTypes proto 标量类型对应 mongo-driver 默认编码后的 bson 类型
+var mongoBsonTypes = map[string]interface{}{
+	"string":   "string",
+	"bool":     "bool",
+	"int32":    "int",
+	"sint32":   "int",
+	"sfixed32": "int",
+	"int64":    "long",
+	"sint64":   "long",
+	"sfixed64": "long",
+	"uint32":   "long",
+	"fixed32":  "long",
+	"uint64":   "long",
+	"fixed64":  "long",
+	"float":    "double",
+	"double":   "double",
+	// nil 的 []byte 编码为 null
+	"bytes": []string{"binData", "null"},
+}
+
+// mongoElem mongoDoc 中的一个键值
+type mongoElem struct {
+	Key   string
+	Value interface{}
+}
+
+// mongoDoc 保持 key 顺序的文档 联合索引的 key 顺序有意义
+type mongoDoc []mongoElem
+
+func (d mongoDoc) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, e := range d {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(e.Key)
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// mongoIndex collection 的一个索引 key 为 bson 字段路径
+type mongoIndex struct {
+	Name               string
+	Keys               mongoDoc
+	Unique             bool
+	TTL                bool
+	ExpireAfterSeconds int64
+	Sparse             bool
+	PartialFilter      string
+	Collation          *IndexCollation
+}
+
+// mongoCollection 一个 model 对应的 collection
+type mongoCollection struct {
+	Model         string
+	Name          string
+	Indexes       []*mongoIndex
+	Validator     mongoDoc
+	ValidatorJSON string
+}
+
+// OutputMongo 按 mongodb model 输出 pbFile 中每个 collection 的索引与 $jsonSchema 校验
+// 默认输出 createIndexes collMod 命令 可以与线上导出的索引比较; Format 为 go 时输出 model 的 EnsureIndexes EnsureValidator 方法
+func (g *Generator) OutputMongo(pbFile string, config ...*MongoConfig) error {
+	g.reset()
+
+	var c = &MongoConfig{}
+	if len(config) != 0 && config[0] != nil {
+		c = config[0]
+	}
+
+	definition, err := openProtoFile(pbFile)
+	if err != nil {
+		return err
+	}
+	g.pbFilePath = pbFile
+	if err := g.Visitor.GetSymbols().AddFile(pbFile, definition); err != nil {
+		logrus.Errorf("load import err: %+v", err)
+	}
+	proto.Walk(definition,
+		proto.WithPackage(g.loadPackage),
+		proto.WithMessage(g.loadMessage),
+	)
+	for _, message := range g.Visitor.ModelMsgMap {
+		g.injectMongoModelTag(message)
+		g.genModelTableName(message)
+	}
+	proto.Walk(definition, proto.WithMessage(g.collectIndex))
+	if g.diags.HasError() {
+		return g.diags
+	}
+
+	collections := g.mongoCollections(definition)
+
+	var out []byte
+	switch strings.ToLower(c.Format) {
+	case "", MongoFormatJSON:
+		out, err = mongoCommands(collections)
+	case MongoFormatGo:
+		out, err = mongoEnsureCode(g.Visitor.PackageName, path.Base(pbFile), collections)
+	default:
+		err = fmt.Errorf("不支持的输出格式 %q", c.Format)
+	}
+	if err != nil {
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+
+	if c.Output != "" {
+		if err := ioutil.WriteFile(c.Output, out, 0666); err != nil {
+			logrus.Errorf("write %s err: %+v", c.Output, err)
+			return err
+		}
+		return nil
+	}
+	_, _ = os.Stdout.Write(out)
+	return nil
+}
+
+// mongoCollections 按 proto 中声明的顺序收集 model 的 collection 索引按名字排序
+func (g *Generator) mongoCollections(definition *proto.Proto) []*mongoCollection {
+	var models = make(map[*proto.Message]bool)
+	for _, m := range g.Visitor.ModelMsgMap {
+		models[m] = true
+	}
+
+	var collections []*mongoCollection
+	proto.Walk(definition, proto.WithMessage(func(m *proto.Message) {
+		if !models[m] {
+			return
+		}
+		var mc = &mongoCollection{
+			Model:     m.Name,
+			Name:      g.Visitor.ModelTableNameMap[trim(m.Name)],
+			Validator: mongoDoc{{"$jsonSchema", g.mongoSchema(m, make(map[*proto.Message]bool))}},
+		}
+		buf, _ := json.Marshal(mc.Validator)
+		mc.ValidatorJSON = string(buf)
+		mc.Indexes = g.mongoIndexes(m)
+		collections = append(collections, mc)
+	}))
+	return collections
+}
+
+// mongoIndexes model 声明的索引 按索引名排序 text 2dsphere hashed 索引的 key 值为索引类型
+func (g *Generator) mongoIndexes(m *proto.Message) []*mongoIndex {
+	var indexes = g.Visitor.ModelIndexMap[m.Name]
+	var names []string
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var res []*mongoIndex
+	for _, name := range names {
+		info := indexes[name]
+		var idx = &mongoIndex{
+			Name:               info.Name,
+			Unique:             info.Unique,
+			TTL:                info.TTLIndex,
+			ExpireAfterSeconds: info.ExpireAfterSeconds,
+			Sparse:             info.Options.Sparse,
+			PartialFilter:      info.Options.PartialFilter,
+			Collation:          info.Options.Collation,
+		}
+		for _, f := range info.Fields {
+			var value interface{} = f.Sort
+			if f.Kind != "" {
+				value = f.Kind
+			}
+			idx.Keys = append(idx.Keys, mongoElem{Key: f.Field, Value: value})
+		}
+		res = append(res, idx)
+	}
+	return res
+}
+
+// mongoSchema message 的 $jsonSchema 属性名为注入后的 bson 字段名 不限制未声明的属性
+// map 与 oneof 字段不做限制 visited 防止递归定义
+func (g *Generator) mongoSchema(m *proto.Message, visited map[*proto.Message]bool) mongoDoc {
+	visited[m] = true
+	defer delete(visited, m)
+
+	var props = mongoDoc{}
+	for _, e := range m.Elements {
+		field, ok := e.(*proto.NormalField)
+		if !ok {
+			continue
+		}
+		var s interface{} = g.mongoFieldSchema(m, field.Type, visited)
+		if field.Repeated {
+			// nil 的 slice 编码为 null
+			s = mongoDoc{{"bsonType", []string{"array", "null"}}, {"items", s}}
+		}
+		props = append(props, mongoElem{Key: g.bsonFieldName(field), Value: s})
+	}
+	return mongoDoc{{"bsonType", "object"}, {"properties", props}}
+}
+
+// mongoFieldSchema 字段类型的 schema 找不到定义的 message 不做限制
+func (g *Generator) mongoFieldSchema(parent *proto.Message, typ string, visited map[*proto.Message]bool) mongoDoc {
+	if t, ok := mongoBsonTypes[g.Visitor.scalarType(typ)]; ok {
+		return mongoDoc{{"bsonType", t}}
+	}
+	pm := g.fieldMessage(parent, typ)
+	if pm == nil || visited[pm] {
+		return mongoDoc{}
+	}
+	s := g.mongoSchema(pm, visited)
+	// nil 的指针编码为 null
+	s[0].Value = []string{"object", "null"}
+	return s
+}
+
+// fieldMessage 字段引用的 message 当前文件中按嵌套作用域由近及远查找 带包名的类型走符号表
+// 外部文件中的 message 按它所在的作用域在符号表中查找
+func (g *Generator) fieldMessage(parent *proto.Message, typ string) *proto.Message {
+	if !g.isLocalMessage(parent) {
+		if sym := g.Visitor.Symbols.MessageSymbol(parent); sym != nil {
+			return g.Visitor.Symbols.LookupMessage(sym.FullName, typ)
+		}
+		return nil
+	}
+	if strings.Contains(typ, ".") {
+		return g.Visitor.LookupMessage(typ)
+	}
+	scope := getOuterForefathersNameArr(parent)
+	for i := len(scope); i > 0; i-- {
+		name := strings.Join(append(append([]string(nil), scope[:i]...), typ), "_")
+		if pm, ok := g.Visitor.AllMsgMap[name]; ok {
+			return pm
+		}
+	}
+	if pm, ok := g.Visitor.AllMsgMap[typ]; ok {
+		return pm
+	}
+	// 同一个包中其他文件的 message
+	return g.Visitor.LookupMessage(typ)
+}
+
+// mongoCommands 每个 collection 的 createIndexes 与 collMod 命令 按顺序用 db.runCommand 执行
+// createIndexes 会自动创建 collection 没有索引的 collection 需要先创建才能执行 collMod
+func mongoCommands(collections []*mongoCollection) ([]byte, error) {
+	var cmds = []mongoDoc{}
+	for _, mc := range collections {
+		if len(mc.Indexes) != 0 {
+			var indexes []mongoDoc
+			for _, idx := range mc.Indexes {
+				var doc = mongoDoc{{"key", idx.Keys}, {"name", idx.Name}}
+				if idx.Unique {
+					doc = append(doc, mongoElem{"unique", true})
+				}
+				if idx.TTL {
+					doc = append(doc, mongoElem{"expireAfterSeconds", idx.ExpireAfterSeconds})
+				}
+				if idx.Sparse {
+					doc = append(doc, mongoElem{"sparse", true})
+				}
+				if idx.PartialFilter != "" {
+					doc = append(doc, mongoElem{"partialFilterExpression", json.RawMessage(idx.PartialFilter)})
+				}
+				if idx.Collation != nil {
+					doc = append(doc, mongoElem{"collation", idx.Collation})
+				}
+				indexes = append(indexes, doc)
+			}
+			cmds = append(cmds, mongoDoc{{"createIndexes", mc.Name}, {"indexes", indexes}})
+		}
+		cmds = append(cmds, mongoDoc{{"collMod", mc.Name}, {"validator", mc.Validator}})
+	}
+	buf, err := json.MarshalIndent(cmds, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, '\n'), nil
+}
+
+// mongoEnsureCode 按 MongoEnsureTpl 输出 model 的 EnsureIndexes EnsureValidator 方法
+func mongoEnsureCode(packageName, fileName string, collections []*mongoCollection) ([]byte, error) {
+	var KV = struct {
+		PackageName string
+		FileName    string
+		Collections []*mongoCollection
+	}{
+		PackageName: packageName,
+		FileName:    fileName,
+		Collections: collections,
+	}
+	src, err := executeTpl("mongo", MongoEnsureTpl, KV)
+	if err != nil {
+		return nil, err
+	}
+	return format.Source(src)
+}
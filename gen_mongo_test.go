@@ -0,0 +1,311 @@
+package proto_parser
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const mongoTestProto = `syntax = "proto3";
+package user;
+
+enum Status {
+    Unknown = 0;
+    Active = 1;
+}
+
+// @table_name: users
+message ModelUser {
+    string id = 1;
+    // @bson: nick
+    // @unique_index: idx_nick_status asc
+    string nick_name = 2;
+    // @unique_index: idx_nick_status desc
+    Status status = 3;
+    // @ttl_index: idx_expire_at asc 3600
+    int64 expire_at = 4;
+    repeated string tags = 5;
+    Profile profile = 6;
+    bytes avatar = 7;
+}
+
+message Profile {
+    // @bson: desc
+    string bio = 1;
+    double score = 2;
+}
+`
+
+func TestOutputMongo(t *testing.T) {
+	dir := t.TempDir()
+	pbFile := filepath.Join(dir, "user.proto")
+	if err := ioutil.WriteFile(pbFile, []byte(mongoTestProto), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "user.json")
+	if err := NewGenerator().OutputMongo(pbFile, &MongoConfig{Output: output}); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(output)
+	compact := strings.Join(strings.Fields(string(content)), "")
+	for _, want := range []string{
+		`{"createIndexes":"users","indexes":[{"key":{"expire_at":1},"name":"idx_expire_at","expireAfterSeconds":3600},{"key":{"nick":1,"status":-1},"name":"idx_nick_status","unique":true}]}`,
+		`{"collMod":"users","validator":{"$jsonSchema":{"bsonType":"object","properties":{"_id":{"bsonType":"string"},"nick":{"bsonType":"string"},"status":{"bsonType":"int"},"expire_at":{"bsonType":"long"}`,
+		`"tags":{"bsonType":["array","null"],"items":{"bsonType":"string"}}`,
+		`"profile":{"bsonType":["object","null"],"properties":{"desc":{"bsonType":"string"},"score":{"bsonType":"double"}}}`,
+		`"avatar":{"bsonType":["binData","null"]}`,
+	} {
+		if !strings.Contains(compact, want) {
+			t.Errorf("want %s in:\n%s", want, content)
+		}
+	}
+
+	output = filepath.Join(dir, "autogen_mongo_user.go")
+	if err := NewGenerator().OutputMongo(pbFile, &MongoConfig{Output: output, Format: MongoFormatGo}); err != nil {
+		t.Fatal(err)
+	}
+	content, _ = ioutil.ReadFile(output)
+	if _, err := parser.ParseFile(token.NewFileSet(), output, content, 0); err != nil {
+		t.Fatalf("generated file does not parse: %v\n%s", err, content)
+	}
+	for _, want := range []string{
+		"func (t *ModelUser) EnsureIndexes(ctx context.Context, db *mongo.Database) error {",
+		`Keys:    bson.D{{Key: "nick", Value: 1}, {Key: "status", Value: -1}},`,
+		`Options: options.Index().SetName("idx_nick_status").SetUnique(true),`,
+		`Options: options.Index().SetName("idx_expire_at").SetExpireAfterSeconds(3600),`,
+		"func (t *ModelUser) EnsureValidator(ctx context.Context, db *mongo.Database) error {",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("want %q in:\n%s", want, content)
+		}
+	}
+}
+
+func TestOutputMongoIndexPerModel(t *testing.T) {
+	dir := t.TempDir()
+	pbFile := filepath.Join(dir, "order.proto")
+	src := `syntax = "proto3";
+package order;
+
+// @table_name: orders
+message ModelOrder {
+    string id = 1;
+    // @index: idx_created desc
+    int64 created_at = 2;
+}
+
+// @table_name: payments
+message ModelPayment {
+    string id = 1;
+    // @index: idx_created asc
+    int64 created_at = 2;
+    // @index: idx_created asc
+    string order_id = 3;
+}
+`
+	if err := ioutil.WriteFile(pbFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "order.json")
+	if err := NewGenerator().OutputMongo(pbFile, &MongoConfig{Output: output}); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(output)
+	compact := strings.Join(strings.Fields(string(content)), "")
+	for _, want := range []string{
+		`{"createIndexes":"orders","indexes":[{"key":{"created_at":-1},"name":"idx_created"}]}`,
+		`{"createIndexes":"payments","indexes":[{"key":{"created_at":1,"order_id":1},"name":"idx_created"}]}`,
+	} {
+		if !strings.Contains(compact, want) {
+			t.Errorf("want %s in:\n%s", want, content)
+		}
+	}
+}
+
+func TestOutputMongoIndexOptions(t *testing.T) {
+	dir := t.TempDir()
+	pbFile := filepath.Join(dir, "shop.proto")
+	src := `syntax = "proto3";
+package shop;
+
+// @table_name: shops
+message ModelShop {
+    string id = 1;
+    // @bson: title
+    // @index: idx_title text
+    string name = 2;
+    // @index: idx_title text
+    string desc = 3;
+    Address address = 4;
+    // @unique_index: idx_code asc sparse collation:zh:2
+    string code = 5;
+    // @index: idx_owner hashed
+    string owner_id = 6;
+    // @index: idx_status asc partial:{"status": {"$gt": 0}}
+    int32 status = 7;
+}
+
+message Address {
+    // @bson: city_name
+    // @index: idx_city asc
+    string city = 1;
+    // @index: idx_location 2dsphere
+    repeated double location = 2;
+}
+`
+	if err := ioutil.WriteFile(pbFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "shop.json")
+	if err := NewGenerator().OutputMongo(pbFile, &MongoConfig{Output: output}); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(output)
+	compact := strings.Join(strings.Fields(string(content)), "")
+	for _, want := range []string{
+		`{"key":{"address.city_name":1},"name":"idx_city"}`,
+		`{"key":{"code":1},"name":"idx_code","unique":true,"sparse":true,"collation":{"locale":"zh","strength":2}}`,
+		`{"key":{"address.location":"2dsphere"},"name":"idx_location"}`,
+		`{"key":{"owner_id":"hashed"},"name":"idx_owner"}`,
+		`{"key":{"status":1},"name":"idx_status","partialFilterExpression":{"status":{"$gt":0}}}`,
+		`{"key":{"title":"text","desc":"text"},"name":"idx_title"}`,
+	} {
+		if !strings.Contains(compact, want) {
+			t.Errorf("want %s in:\n%s", want, content)
+		}
+	}
+
+	output = filepath.Join(dir, "autogen_mongo_shop.go")
+	if err := NewGenerator().OutputMongo(pbFile, &MongoConfig{Output: output, Format: MongoFormatGo}); err != nil {
+		t.Fatal(err)
+	}
+	content, _ = ioutil.ReadFile(output)
+	if _, err := parser.ParseFile(token.NewFileSet(), output, content, 0); err != nil {
+		t.Fatalf("generated file does not parse: %v\n%s", err, content)
+	}
+	for _, want := range []string{
+		`Options: options.Index().SetName("idx_code").SetUnique(true).SetSparse(true).SetCollation(&options.Collation{Locale: "zh", Strength: 2}),`,
+		`Options: options.Index().SetName("idx_status").SetPartialFilterExpression(partial4),`,
+		`Keys:    bson.D{{Key: "address.location", Value: "2dsphere"}},`,
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("want %q in:\n%s", want, content)
+		}
+	}
+
+	// core.IndexInfo 无法表达的索引不输出到 model 代码中
+	g := NewGenerator()
+	if err := g.OutputMongo(pbFile, &MongoConfig{Output: output}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.GenModelCode("shop", pbFile); err != nil {
+		t.Fatal(err)
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "autogen_model_shop.go"))
+	if !strings.Contains(string(content), "ModelShopIndex_idx_city,") {
+		t.Errorf("want idx_city in:\n%s", content)
+	}
+	for _, name := range []string{"idx_title", "idx_code", "idx_location", "idx_owner", "idx_status"} {
+		if strings.Contains(string(content), name) {
+			t.Errorf("unexpected %s in:\n%s", name, content)
+		}
+	}
+
+	// hashed 索引不能是唯一索引
+	src = strings.Replace(src, "@index: idx_owner hashed", "@unique_index: idx_owner hashed", 1)
+	if err := ioutil.WriteFile(pbFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewGenerator().OutputMongo(pbFile, &MongoConfig{Output: output}); err == nil {
+		t.Error("expect error for unique hashed index")
+	}
+}
+
+func TestOutputMongoSharedSubDocument(t *testing.T) {
+	dir := t.TempDir()
+	pbFile := filepath.Join(dir, "user.proto")
+	src := `syntax = "proto3";
+package user;
+
+// @table_name: users
+message ModelUser {
+    string id = 1;
+    Address home = 2;
+    Address work = 3;
+}
+
+message Address {
+    // @index: idx_city asc
+    string city = 1;
+}
+`
+	if err := ioutil.WriteFile(pbFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	g := NewGenerator()
+	if err := g.OutputMongo(pbFile, &MongoConfig{Output: filepath.Join(dir, "user.json")}); err == nil {
+		t.Fatal("expect error for index reached through two fields")
+	}
+	diags := g.Diagnostics()
+	if len(diags) != 1 || !strings.Contains(diags[0].Msg, "home.city") || diags[0].Pos.Line != 12 {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+}
+
+func TestOutputMongoImportedBson(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "common"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	var files = map[string]string{
+		filepath.Join(dir, "common", "page.proto"): `syntax = "proto3";
+package common;
+message Page {
+    // @bson: size
+    // @index: idx_page_size asc
+    int64 page_size = 1;
+    Cursor cursor = 2;
+    message Cursor {
+        // @bson: ignore
+        string last_id = 1;
+    }
+}
+`,
+		filepath.Join(dir, "user.proto"): `syntax = "proto3";
+package user;
+import "common/page.proto";
+// @table_name: users
+message ModelUser {
+    string id = 1;
+    common.Page page = 2;
+}
+`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := filepath.Join(dir, "user.json")
+	if err := NewGenerator().OutputMongo(filepath.Join(dir, "user.proto"), &MongoConfig{Output: output}); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(output)
+	compact := strings.Join(strings.Fields(string(content)), "")
+	for _, want := range []string{
+		`{"key":{"page.size":1},"name":"idx_page_size"}`,
+		`"page":{"bsonType":["object","null"],"properties":{"size":{"bsonType":"long"},"cursor":{"bsonType":["object","null"],"properties":{"last_id":{"bsonType":"string"}}}}}`,
+	} {
+		if !strings.Contains(compact, want) {
+			t.Errorf("want %s in:\n%s", want, content)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package proto_parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/elliotchance/pie/pie"
+	"github.com/emicklei/proto"
+	"github.com/sirupsen/logrus"
+)
+
+// openAPIMethods ANY 路由展开成的请求方法
+var openAPIMethods = []string{"get", "post", "put", "patch", "delete"}
+
+// openAPIBuilder 构建 OpenAPI 文档的上下文
+type openAPIBuilder struct {
+	g       *Generator
+	paths   map[string]map[string]interface{}
+	schemas map[string]interface{}
+}
+
+// OutputOpenAPI 遍历所有路由组 service 输出 OpenAPI 3.1 文档
+// includes 为依赖的 proto 文件 用于查找 import 的 message 与错误码
+func (g *Generator) OutputOpenAPI(pbFiles, includes []string) ([]byte, error) {
+	g.reset()
+
+	for _, include := range append(append([]string(nil), includes...), pbFiles...) {
+		g.Visitor.IncludePaths = append(g.Visitor.IncludePaths, path.Dir(include))
+	}
+	g.Visitor.IncludePaths = pie.Strings(g.Visitor.IncludePaths).Unique().Sort()
+	symbols := g.Visitor.GetSymbols()
+
+	var b = &openAPIBuilder{
+		g:       g,
+		paths:   make(map[string]map[string]interface{}),
+		schemas: make(map[string]interface{}),
+	}
+	var routes = NewRouteTable()
+	for _, pbFile := range pbFiles {
+		definition, err := openProtoFile(pbFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := symbols.AddFile(pbFile, definition); err != nil {
+			logrus.Errorf("load import err: %+v", err)
+		}
+
+		g.Visitor = &ProtoVisitor{IncludePaths: g.Visitor.IncludePaths, Symbols: symbols}
+		proto.Walk(definition,
+			proto.WithPackage(g.loadPackage),
+			proto.WithService(g.parseSrvGenRouter),
+		)
+
+		for _, srvName := range g.groupRouterNames() {
+			group := g.Visitor.GroupRouterMap[srvName]
+			for _, node := range group.Apis {
+				route := newRouteEntry(srvName, group, node)
+				if d := routes.Add(route); d != nil {
+					logrus.Warnf("%s", d)
+					continue
+				}
+				b.addOperation(route, node)
+			}
+		}
+	}
+
+	var doc = map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":   "API",
+			"version": "1.0.0",
+		},
+		"paths": b.paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+		},
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// addOperation 添加一个接口 ANY 路由按方法展开 每个方法使用独立的 operation
+func (b *openAPIBuilder) addOperation(route *RouteEntry, node *GroupRouterNode) {
+	p, pathNames := openAPIPath(route.Path)
+	if b.paths[p] == nil {
+		b.paths[p] = make(map[string]interface{})
+	}
+
+	method := strings.ToLower(route.Method)
+	if method != "any" {
+		b.paths[p][method] = b.operation(route, node, method, pathNames)
+		return
+	}
+	for _, m := range openAPIMethods {
+		op := b.operation(route, node, m, pathNames)
+		// operationId 在文档中必须唯一
+		op["operationId"] = fmt.Sprintf("%s_%s_%s", route.Service, route.Handler, m)
+		b.paths[p][m] = op
+	}
+}
+
+// operation 构建一个请求方法的 operation
+func (b *openAPIBuilder) operation(route *RouteEntry, node *GroupRouterNode, method string, pathNames []string) map[string]interface{} {
+	pkg := b.g.Visitor.ProtoPackage
+	var op = map[string]interface{}{
+		"operationId": fmt.Sprintf("%s_%s", route.Service, route.Handler),
+		"tags":        []string{route.Service},
+	}
+	if node.Describe != "" {
+		op["summary"] = node.Describe
+	}
+	if node.Author != "" {
+		op["description"] = fmt.Sprintf("author: %s", node.Author)
+	}
+
+	query := method == "get" || method == "delete"
+	if params := b.parameters(pkg, node.ReqName, pathNames, query); len(params) != 0 {
+		op["parameters"] = params
+	}
+	if !query {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": b.typeSchema(pkg, node.ReqName)},
+			},
+		}
+	}
+
+	var responses = map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "OK",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": b.typeSchema(pkg, node.RespName)},
+			},
+		},
+	}
+	if node.rpc != nil && node.rpc.Comment != nil {
+		if errResp := b.errorResponse(pkg, rpcErrCodeNames(node.rpc.Comment.Lines)); errResp != nil {
+			responses["default"] = errResp
+		}
+	}
+	op["responses"] = responses
+	return op
+}
+
+// openAPIPath gin 的 :param 与 *param 转换为 OpenAPI 的 {param} 返回路径与参数名
+func openAPIPath(p string) (string, []string) {
+	segs := strings.Split(p, "/")
+	var names []string
+	for i, seg := range segs {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			names = append(names, seg[1:])
+			segs[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segs, "/"), names
+}
+
+// parameters 请求参数 路径参数按路由中的顺序排在前面 与请求中同名的字段使用字段的类型
+// query 为 true 时(GET DELETE) 其余的标量字段作为 query 参数
+func (b *openAPIBuilder) parameters(scope, typ string, pathNames []string, query bool) []interface{} {
+	var pathParams = make(map[string]map[string]interface{})
+	for _, name := range pathNames {
+		pathParams[name] = map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		}
+	}
+
+	var params []interface{}
+	if sym := b.g.Visitor.GetSymbols().Lookup(scope, typ); sym != nil && sym.Message != nil {
+		style := effectiveJsonStyle(sym.Message)
+		for _, elem := range sym.Message.Elements {
+			field, ok := elem.(*proto.NormalField)
+			if !ok {
+				continue
+			}
+			if !isBuiltInType(field.Type) && b.g.Visitor.GetSymbols().LookupEnum(sym.FullName, field.Type) == nil {
+				continue
+			}
+			name := fieldJsonName(field.Name, field.Comment, style)
+			desc := openAPIFieldDesc(field.Comment, field.InlineComment)
+			param, ok := pathParams[name]
+			if !ok {
+				param, ok = pathParams[field.Name]
+			}
+			if ok {
+				param["schema"] = b.fieldSchema(sym.FullName, field.Type, field.Repeated)
+				if desc != "" {
+					param["description"] = desc
+				}
+				continue
+			}
+			if !query {
+				continue
+			}
+			param = map[string]interface{}{
+				"name":   name,
+				"in":     "query",
+				"schema": b.fieldSchema(sym.FullName, field.Type, field.Repeated),
+			}
+			if desc != "" {
+				param["description"] = desc
+			}
+			if openAPIFieldRequired(field.Comment) {
+				param["required"] = true
+			}
+			params = append(params, param)
+		}
+	}
+
+	var res = make([]interface{}, 0, len(pathNames)+len(params))
+	for _, name := range pathNames {
+		res = append(res, pathParams[name])
+	}
+	return append(res, params...)
+}
+
+// typeSchema 类型对应的 schema message 与 enum 注册到 components 中
+func (b *openAPIBuilder) typeSchema(scope, typ string) map[string]interface{} {
+	if schema := openAPIScalarSchema(typ); schema != nil {
+		return schema
+	}
+
+	sym := b.g.Visitor.GetSymbols().Lookup(scope, typ)
+	if sym == nil {
+		// 未能解析的类型 例如 google.protobuf 中的类型
+		return map[string]interface{}{"type": "object"}
+	}
+
+	ref := map[string]interface{}{"$ref": "#/components/schemas/" + sym.FullName}
+	if _, ok := b.schemas[sym.FullName]; ok {
+		return ref
+	}
+	if sym.Enum != nil {
+		b.schemas[sym.FullName] = openAPIEnumSchema(sym.Enum)
+		return ref
+	}
+
+	// 先占位 避免递归引用时死循环
+	var schema = map[string]interface{}{"type": "object"}
+	b.schemas[sym.FullName] = schema
+
+	style := effectiveJsonStyle(sym.Message)
+	var properties = make(map[string]interface{})
+	var required []string
+	var addField = func(field *proto.Field, comment, inline *proto.Comment, repeated bool) {
+		name := fieldJsonName(field.Name, comment, style)
+		prop := b.fieldSchema(sym.FullName, field.Type, repeated)
+		if desc := openAPIFieldDesc(comment, inline); desc != "" {
+			prop = openAPIWithDesc(prop, desc)
+		}
+		properties[name] = prop
+		if openAPIFieldRequired(comment) {
+			required = append(required, name)
+		}
+	}
+	for _, elem := range sym.Message.Elements {
+		switch field := elem.(type) {
+		case *proto.NormalField:
+			addField(field.Field, field.Comment, field.InlineComment, field.Repeated)
+		case *proto.MapField:
+			name := fieldJsonName(field.Name, field.Comment, style)
+			properties[name] = map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": b.fieldSchema(sym.FullName, field.Type, false),
+			}
+		case *proto.Oneof:
+			for _, oe := range field.Elements {
+				if of, ok := oe.(*proto.OneOfField); ok {
+					addField(of.Field, of.Comment, of.InlineComment, false)
+				}
+			}
+		}
+	}
+	schema["properties"] = properties
+	if len(required) != 0 {
+		schema["required"] = required
+	}
+	if sym.Message.Comment != nil {
+		if desc := trim(sym.Message.Comment.Message()); desc != "" {
+			schema["description"] = desc
+		}
+	}
+	return ref
+}
+
+// fieldSchema 字段的 schema repeated 字段为数组
+func (b *openAPIBuilder) fieldSchema(scope, typ string, repeated bool) map[string]interface{} {
+	schema := b.typeSchema(scope, typ)
+	if repeated {
+		return map[string]interface{}{"type": "array", "items": schema}
+	}
+	return schema
+}
+
+// errorResponse @error 中声明的错误码 作为 default 响应输出
+func (b *openAPIBuilder) errorResponse(pkg string, names []string) map[string]interface{} {
+	if len(names) == 0 {
+		return nil
+	}
+	var codes []interface{}
+	var lines []string
+	var items []interface{}
+	for _, name := range names {
+		ec, ok := b.g.lookupErrCode(pkg, name)
+		if !ok {
+			lines = append(lines, fmt.Sprintf("- %s", name))
+			items = append(items, map[string]interface{}{"name": name})
+			continue
+		}
+		codes = append(codes, ec.Code)
+		lines = append(lines, fmt.Sprintf("- %d %s: %s", ec.Code, name, ec.Desc))
+		items = append(items, map[string]interface{}{"code": ec.Code, "name": name, "description": ec.Desc})
+	}
+
+	var code = map[string]interface{}{"type": "integer", "format": "int32"}
+	if len(codes) != 0 {
+		code["enum"] = codes
+	}
+	return map[string]interface{}{
+		"description": "业务错误\n" + strings.Join(lines, "\n"),
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"code": code,
+						"msg":  map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+		},
+		"x-error-codes": items,
+	}
+}
+
+// lookupErrCode 在符号表中查找错误码 name 可以带包名
+func (g *Generator) lookupErrCode(pkg, name string) (MDocsErrCodeField, bool) {
+	var enumType, fieldName = ErrCodeName, name
+	if idx := strings.LastIndex(name, "."); idx != -1 {
+		enumType = name[:idx] + "." + ErrCodeName
+		fieldName = name[idx+1:]
+	}
+	e := g.Visitor.GetSymbols().LookupEnum(pkg, enumType)
+	if e == nil {
+		return MDocsErrCodeField{}, false
+	}
+	for _, elem := range e.Elements {
+		ef, ok := elem.(*proto.EnumField)
+		if !ok || ef.Name != fieldName {
+			continue
+		}
+		var v = MDocsErrCodeField{Code: ef.Integer, Name: name}
+		if ef.InlineComment != nil {
+			v.Desc = trim(ef.InlineComment.Message())
+		}
+		return v, true
+	}
+	return MDocsErrCodeField{}, false
+}
+
+// openAPIScalarSchema 标量类型的 schema 非标量返回 nil
+func openAPIScalarSchema(typ string) map[string]interface{} {
+	switch typ {
+	case "int32", "sint32", "sfixed32", "uint32", "fixed32":
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case "int64", "sint64", "sfixed64", "uint64", "fixed64":
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case "float":
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case "double":
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case "bool":
+		return map[string]interface{}{"type": "boolean"}
+	case "string":
+		return map[string]interface{}{"type": "string"}
+	case "bytes":
+		return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+	}
+	return nil
+}
+
+// openAPIEnumSchema 枚举按整数输出 附带枚举名与说明
+func openAPIEnumSchema(e *proto.Enum) map[string]interface{} {
+	var values []int
+	var names, descs, lines []string
+	for _, elem := range e.Elements {
+		ef, ok := elem.(*proto.EnumField)
+		if !ok {
+			continue
+		}
+		var desc string
+		if ef.InlineComment != nil {
+			desc = trim(ef.InlineComment.Message())
+		}
+		values = append(values, ef.Integer)
+		names = append(names, ef.Name)
+		descs = append(descs, desc)
+		lines = append(lines, fmt.Sprintf("- %d %s: %s", ef.Integer, ef.Name, desc))
+	}
+	return map[string]interface{}{
+		"type":                "integer",
+		"format":              "int32",
+		"enum":                values,
+		"description":         strings.Join(lines, "\n"),
+		"x-enum-varnames":     names,
+		"x-enum-descriptions": descs,
+	}
+}
+
+// openAPIWithDesc 给 schema 添加说明 $ref 不能带其他属性 用 allOf 包一层
+func openAPIWithDesc(schema map[string]interface{}, desc string) map[string]interface{} {
+	if _, ok := schema["$ref"]; ok {
+		return map[string]interface{}{"allOf": []interface{}{schema}, "description": desc}
+	}
+	var res = make(map[string]interface{}, len(schema)+1)
+	for k, v := range schema {
+		res[k] = v
+	}
+	res["description"] = desc
+	return res
+}
+
+// openAPIFieldDesc 字段说明 优先 @desc 其次行内注释
+func openAPIFieldDesc(comment, inline *proto.Comment) string {
+	if comment != nil {
+		reg := regexp.MustCompile(`@desc:\s*(.*)`)
+		for _, line := range comment.Lines {
+			if res := reg.FindStringSubmatch(line); len(res) == 2 {
+				return trim(res[1])
+			}
+		}
+	}
+	if inline != nil {
+		return trim(inline.Message())
+	}
+	return ""
+}
+
+// openAPIFieldRequired 字段是否带有 @v: required
+func openAPIFieldRequired(comment *proto.Comment) bool {
+	if comment == nil {
+		return false
+	}
+	reg := regexp.MustCompile(`@v:\s*(.*)`)
+	for _, line := range comment.Lines {
+		if res := reg.FindStringSubmatch(line); len(res) == 2 && strings.Contains(res[1], "required") {
+			return true
+		}
+	}
+	return false
+}
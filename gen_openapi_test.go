@@ -0,0 +1,147 @@
+package proto_parser
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputOpenAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pbFile := filepath.Join(dir, "user.proto")
+	src := `syntax = "proto3";
+package user;
+
+enum ErrCode {
+    None = 0;
+    ErrUserNotFound = 10001; // 用户不存在
+}
+
+enum Gender {
+    Unknown = 0; // 未知
+    Male = 1; // 男
+}
+
+// @json_style: lower_camel
+message CreateUserReq {
+    // @v: required
+    string user_name = 1;
+    Gender gender = 2;
+    // @json: extra_info
+    map<string, string> extra = 3;
+}
+
+message CreateUserResp {
+    int64 id = 1;
+}
+
+message GetUserReq {
+    int64 user_id = 1; // 用户ID
+    string fields = 2;
+}
+
+// @route_group: true
+// @route_api: /api/user
+service UserAPI {
+    // @desc: 创建用户
+    // @author: a
+    // @method: POST
+    // @api: /create
+    // @error:
+    // ErrUserNotFound
+    rpc CreateUser (CreateUserReq) returns (CreateUserResp);
+    // @method: GET
+    // @api: /info/:user_id
+    rpc GetUser (GetUserReq) returns (CreateUserResp);
+    // @method: ANY
+    // @api: /ping
+    rpc Ping (CreateUserResp) returns (CreateUserResp);
+}
+`
+	if err := ioutil.WriteFile(pbFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := OutputOpenAPI([]string{pbFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Required   []string               `json:"required"`
+				Properties map[string]interface{} `json:"properties"`
+				Enum       []int                  `json:"enum"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("unexpected version: %s", doc.OpenAPI)
+	}
+	op, ok := doc.Paths["/api/user/create"]["post"]
+	if !ok {
+		t.Fatalf("missing operation:\n%s", data)
+	}
+	if _, ok := op["responses"].(map[string]interface{})["default"]; !ok {
+		t.Errorf("missing error response:\n%s", data)
+	}
+
+	// gin 路径参数转换为 {param} 并声明为 path 参数
+	get, ok := doc.Paths["/api/user/info/{user_id}"]["get"]
+	if !ok {
+		t.Fatalf("missing path param operation:\n%s", data)
+	}
+	params, _ := get["parameters"].([]interface{})
+	if len(params) != 2 {
+		t.Fatalf("unexpected parameters: %v", params)
+	}
+	first := params[0].(map[string]interface{})
+	if first["name"] != "user_id" || first["in"] != "path" || first["required"] != true ||
+		first["schema"].(map[string]interface{})["type"] != "integer" {
+		t.Errorf("unexpected path parameter: %v", first)
+	}
+	if second := params[1].(map[string]interface{}); second["name"] != "fields" || second["in"] != "query" {
+		t.Errorf("unexpected query parameter: %v", second)
+	}
+
+	// ANY 按方法展开 operationId 不重复
+	var ids = make(map[interface{}]bool)
+	for _, m := range openAPIMethods {
+		op, ok := doc.Paths["/api/user/ping"][m]
+		if !ok {
+			t.Fatalf("missing %s operation for ANY route", m)
+		}
+		ids[op["operationId"]] = true
+	}
+	if len(ids) != len(openAPIMethods) {
+		t.Errorf("expect unique operationId for ANY route, got %v", ids)
+	}
+	if _, ok := doc.Paths["/api/user/ping"]["get"]["requestBody"]; ok {
+		t.Errorf("expect no request body for GET")
+	}
+
+	req := doc.Components.Schemas["user.CreateUserReq"]
+	if len(req.Required) != 1 || req.Required[0] != "userName" {
+		t.Errorf("unexpected required: %v", req.Required)
+	}
+	for _, name := range []string{"userName", "gender", "extra_info"} {
+		if _, ok := req.Properties[name]; !ok {
+			t.Errorf("missing property %s:\n%s", name, data)
+		}
+	}
+	if gender := doc.Components.Schemas["user.Gender"]; len(gender.Enum) != 2 {
+		t.Errorf("unexpected enum: %v", gender.Enum)
+	}
+}
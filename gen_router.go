@@ -13,53 +13,46 @@ import (
 	"text/template"
 )
 
-func parseSrvGenRouter(srv *proto.Service) {
-	var needGen bool
-	if strings.HasSuffix(srv.Name, NameAPIGroup) {
-		needGen = true
+func (g *Generator) parseSrvGenRouter(srv *proto.Service) {
+	if isRouteGroup(srv) {
+		g.genRouterConfig(srv)
 	}
-	if !needGen {
-		if srv.Comment == nil {
-			return
-		}
+}
 
-		if len(srv.Comment.Lines) == 0 {
-			return
+// isRouteGroup service 是否为路由组 以 API 结尾 或者注释中声明了 @route_group: true
+// 生成代码 文档与 AddAPI 共用同一个判断
+func isRouteGroup(srv *proto.Service) bool {
+	if strings.HasSuffix(srv.Name, NameAPIGroup) {
+		return true
+	}
+	if srv.Comment == nil {
+		return false
+	}
+	var gr = regexp.MustCompile(RegexpGroupRouter)
+	var res bool
+	for _, com := range srv.Comment.Lines {
+		match := gr.FindStringSubmatch(com)
+		if len(match) != 2 {
+			continue
 		}
-
-		var doc = srv.Comment.Lines
-
-		for _, com := range doc {
-			var gr = regexp.MustCompile(RegexpGroupRouter)
-			res := gr.FindAllStringSubmatch(com, -1)
-			if len(res) == 0 {
-				continue
-			}
-			if len(res[0]) != 2 {
-				continue
-			}
-
-			if res[0][1] != "true" {
-				return
-			}
-
-			// 是一个路由组
-			needGen = true
+		if match[1] != "true" {
+			return false
 		}
+		res = true
 	}
-
-	if needGen {
-		genRouterConfig(srv)
-	}
+	return res
 }
 
-func genRouterConfig(srv *proto.Service) {
+func (g *Generator) genRouterConfig(srv *proto.Service) {
 	var record = new(GroupRouter)
 	var apiPrefix string
 	var genTo = "internal/controller/impl_controller.go"
 	var mws []string
-	// 当开启了自定义组前缀
-	var doc = srv.Comment.Lines
+	// 当开启了自定义组前缀 以 API 结尾的 service 可以没有注释
+	var doc []string
+	if srv.Comment != nil {
+		doc = srv.Comment.Lines
+	}
 	for _, com := range doc {
 		if strings.Contains(com, "@route_api:") {
 			var gra = regexp.MustCompile(RegexpGroupRouterAPI)
@@ -81,7 +74,7 @@ func genRouterConfig(srv *proto.Service) {
 
 		// 获取中间件内容部份
 		if strings.Contains(com, "@middleware:") {
-			mws = prepareMiddleware(com)
+			mws = g.prepareMiddleware(com)
 		}
 	}
 
@@ -91,7 +84,10 @@ func genRouterConfig(srv *proto.Service) {
 	record.Mws = mws
 
 	for _, rpc := range srv.Elements {
-		sv := rpc.(*proto.RPC)
+		sv, ok := rpc.(*proto.RPC)
+		if !ok {
+			continue
+		}
 		if sv.Comment == nil {
 			record.Apis = append(record.Apis, genDefaultGroupRouterNode(sv))
 			continue
@@ -109,7 +105,7 @@ func genRouterConfig(srv *proto.Service) {
 		}
 		comment := sv.Comment.Lines
 		var isDefaultMethod, isDefaultAPI = true, true
-		for _, line := range comment {
+		for i, line := range comment {
 			// 检测author
 			if strings.Contains(line, "@author:") {
 				reg := regexp.MustCompile(RegexpRouterRpcAuthor)
@@ -150,6 +146,8 @@ func genRouterConfig(srv *proto.Service) {
 						node.Method = "PUT"
 					case "ANY":
 						node.Method = "ANY"
+					default:
+						g.errorf(commentLinePos(sv.Comment, i), "rpc %s: 不支持的请求方法 %q", sv.Name, res[0][1])
 					}
 					isDefaultMethod = false
 					continue
@@ -171,7 +169,7 @@ func genRouterConfig(srv *proto.Service) {
 			}
 			// 检测中间件
 			if strings.Contains(line, "@middleware") {
-				node.Mws = prepareMiddleware(line)
+				node.Mws = g.prepareMiddleware(line)
 
 				//reg := regexp.MustCompile(RegexpMiddleware)
 				//res := reg.FindAllStringSubmatch(line, -1)
@@ -194,11 +192,11 @@ func genRouterConfig(srv *proto.Service) {
 		record.Apis = append(record.Apis, node)
 	}
 
-	Visitor.AddRouterGroup(srv.Name, record)
+	g.Visitor.AddRouterGroup(srv.Name, record)
 }
 
-func genGroupRouterTemplate(pbFile string) error {
-	if len(Visitor.GroupRouterMap) == 0 {
+func (g *Generator) genGroupRouterTemplate(pbFile string) error {
+	if len(g.Visitor.GroupRouterMap) == 0 {
 		return nil
 	}
 
@@ -207,9 +205,9 @@ func genGroupRouterTemplate(pbFile string) error {
 		GroupRouterMap       map[string]*GroupRouter
 		GroupRouterImportPkg []string
 	}{
-		PackageName:          Visitor.PackageName,
-		GroupRouterMap:       Visitor.GroupRouterMap,
-		GroupRouterImportPkg: pie.Strings(Visitor.GroupRouterImportPkg).Unique(),
+		PackageName:          g.Visitor.PackageName,
+		GroupRouterMap:       g.Visitor.GroupRouterMap,
+		GroupRouterImportPkg: pie.Strings(g.Visitor.GroupRouterImportPkg).Unique(),
 	}
 
 	t, err := template.New("group_router").Parse(GroupRouterTpl)
@@ -235,20 +233,14 @@ func genGroupRouterTemplate(pbFile string) error {
 	}
 
 	// get proto go_package
-	text, _ := GetLineWithchars(pbFile, "option go_package = ")
-	var importPackage string
-	if text != "" {
-		protoGoPkgOption := "option go_package = "
-		importPackage = strings.Replace(text, protoGoPkgOption, "", -1)
-		importPackage = strings.Replace(importPackage, ";", "", -1)
-		importPackage = strings.Replace(importPackage, "\n", "", -1)
-		importPackage = strings.Replace(importPackage, "\r", "", -1)
-	}
+	importPackage := getGoPackageOption(pbFile)
 
 	// 在这里检测文件内容 并注册对应方法实现
 	for srvName, router := range KV.GroupRouterMap {
-		var at = new(AstTree)
+		var at = &AstTree{gen: g}
+		unlock := g.lockFile(router.GenTo)
 		err := at.parseGoFile(router.GenTo, srvName, importPackage, router.Apis)
+		unlock()
 		if err != nil {
 			logrus.Errorf("parse go file err: %+v", err)
 			return err
@@ -266,5 +258,6 @@ func genDefaultGroupRouterNode(rpc *proto.RPC) *GroupRouterNode {
 		Describe:   "无描述",
 		ReqName:    rpc.RequestType,
 		RespName:   rpc.ReturnsType,
+		rpc:        rpc,
 	}
 }
@@ -0,0 +1,750 @@
+package proto_parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/scanner"
+
+	"github.com/emicklei/proto"
+	"github.com/sirupsen/logrus"
+)
+
+// sqlIndexReg 字段上的 @index: 与 @unique_index: 注解 格式与 collectIndex 一致
+var sqlIndexReg = regexp.MustCompile(`@(index|unique_index):\s*([\w]{5,})\s+(asc|desc|ASC|DESC)`)
+
+// sqlColumn 表的一列 类型已按方言转换
+type sqlColumn struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	NotNull       bool   `json:"not_null,omitempty"`
+	Default       string `json:"default,omitempty"`
+	AutoIncrement bool   `json:"auto_increment,omitempty"`
+	Comment       string `json:"comment,omitempty"`
+
+	pos scanner.Position
+}
+
+// sqlIndexColumn 索引中的一列
+type sqlIndexColumn struct {
+	Name string `json:"name"`
+	Desc bool   `json:"desc,omitempty"`
+}
+
+// sqlIndex 表的索引 同名的注解组成联合索引
+type sqlIndex struct {
+	Name    string           `json:"name"`
+	Unique  bool             `json:"unique,omitempty"`
+	Columns []sqlIndexColumn `json:"columns"`
+}
+
+// sqlTable 一个 model 对应的表
+type sqlTable struct {
+	Name       string       `json:"name"`
+	File       string       `json:"file,omitempty"` // 声明表的 proto 文件 迁移快照中区分不同文件的表
+	Columns    []*sqlColumn `json:"columns"`
+	PrimaryKey []string     `json:"primary_key,omitempty"`
+	Indexes    []*sqlIndex  `json:"indexes,omitempty"`
+
+	pos scanner.Position
+}
+
+// sqlSchema 一个 proto 文件中所有 model 的表结构 同时作为迁移目录的快照
+type sqlSchema struct {
+	Version int         `json:"version"`
+	Dialect string      `json:"dialect"`
+	Tables  []*sqlTable `json:"tables"`
+}
+
+// OutputSQL 按 gdbc model 输出 pbFile 的建表语句
+// 开启 SQLConfig.Migrate 时与迁移目录下的 <方言>.schema.json 快照比较 有变化时输出下一个版本的迁移并更新快照
+func (g *Generator) OutputSQL(pbFile string, config ...*SQLConfig) error {
+	g.reset()
+
+	var c = &SQLConfig{}
+	if len(config) != 0 && config[0] != nil {
+		c = config[0]
+	}
+	d, err := newSQLDialect(c.Dialect)
+	if err != nil {
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+
+	definition, err := openProtoFile(pbFile)
+	if err != nil {
+		return err
+	}
+	g.pbFilePath = pbFile
+	g.Visitor.dbDriver = "gdbc"
+	if err := g.Visitor.GetSymbols().AddFile(pbFile, definition); err != nil {
+		logrus.Errorf("load import err: %+v", err)
+	}
+	proto.Walk(definition,
+		proto.WithPackage(g.loadPackage),
+		proto.WithMessage(g.loadMessage),
+	)
+
+	schema := g.buildSQLSchema(definition, d)
+	if g.diags.HasError() {
+		return g.diags
+	}
+
+	out := strings.Join(d.createSchema(schema), "\n") + "\n"
+	if c.Output != "" {
+		if err := ioutil.WriteFile(c.Output, []byte(out), 0666); err != nil {
+			logrus.Errorf("write %s err: %+v", c.Output, err)
+			return err
+		}
+	} else {
+		_, _ = fmt.Fprint(os.Stdout, out)
+	}
+
+	if c.Migrate {
+		return g.migrateSQL(pbFile, c.MigrationDir, d, schema)
+	}
+	return nil
+}
+
+// migrateSQL 与迁移目录中的快照比较 有变化时输出下一个版本的迁移文件并更新快照 没有快照时第一个版本为完整的建表语句
+// 同一个迁移目录下的 proto 共用一份快照与版本序列 快照中的表记录来源文件 只与当前文件的表比较
+func (g *Generator) migrateSQL(pbFile, migrationDir string, d sqlDialect, schema *sqlSchema) error {
+	if migrationDir == "" {
+		migrationDir = path.Join(path.Dir(pbFile), "migrations", string(d))
+	}
+	snapshot := path.Join(migrationDir, fmt.Sprintf("%s.schema.json", d))
+
+	var old = &sqlSchema{Dialect: string(d)}
+	if IsExist(snapshot) {
+		content, err := ioutil.ReadFile(snapshot)
+		if err != nil {
+			logrus.Errorf("read %s err: %+v", snapshot, err)
+			return err
+		}
+		if err := json.Unmarshal(content, old); err != nil {
+			logrus.Errorf("parse %s err: %+v", snapshot, err)
+			return err
+		}
+		if old.Dialect != string(d) {
+			return fmt.Errorf("快照 %s 的方言 %s 与 %s 不一致", snapshot, old.Dialect, d)
+		}
+	}
+
+	// 其他文件的表原样保留 当前文件的表在快照中的位置不变
+	fileName := path.Base(pbFile)
+	var tables = make(map[string]*sqlTable)
+	for _, t := range schema.Tables {
+		t.File = fileName
+		tables[t.Name] = t
+	}
+	var prev = &sqlSchema{Dialect: string(d)}
+	var next = &sqlSchema{Dialect: string(d), Version: old.Version + 1}
+	var inserted bool
+	for _, t := range old.Tables {
+		if t.File == fileName {
+			prev.Tables = append(prev.Tables, t)
+			if !inserted {
+				next.Tables = append(next.Tables, schema.Tables...)
+				inserted = true
+			}
+			continue
+		}
+		if cur, ok := tables[t.Name]; ok {
+			g.errorf(cur.pos, "table %s: 已由同一迁移目录下的 %s 声明", t.Name, t.File)
+		}
+		next.Tables = append(next.Tables, t)
+	}
+	if !inserted {
+		next.Tables = append(next.Tables, schema.Tables...)
+	}
+	if g.diags.HasError() {
+		return g.diags
+	}
+
+	stmts := g.diffSQLSchema(d, prev, schema)
+	if g.diags.HasError() {
+		return g.diags
+	}
+	if len(stmts) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(migrationDir, os.ModePerm); err != nil {
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+	migration := path.Join(migrationDir, fmt.Sprintf("%04d_%s.sql", next.Version, strings.TrimSuffix(fileName, path.Ext(fileName))))
+	content := fmt.Sprintf("-- 由 %s 生成 版本 %d\n%s\n", fileName, next.Version, strings.Join(stmts, "\n"))
+	if err := ioutil.WriteFile(migration, []byte(content), 0666); err != nil {
+		logrus.Errorf("write %s err: %+v", migration, err)
+		return err
+	}
+
+	buf, err := json.MarshalIndent(next, "", "  ")
+	if err != nil {
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+	if err := ioutil.WriteFile(snapshot, append(buf, '\n'), 0666); err != nil {
+		logrus.Errorf("write %s err: %+v", snapshot, err)
+		return err
+	}
+	return nil
+}
+
+// buildSQLSchema 按 model message 生成表结构 表 列 索引保持 proto 中声明的顺序
+func (g *Generator) buildSQLSchema(definition *proto.Proto, d sqlDialect) *sqlSchema {
+	var models = make(map[*proto.Message]bool)
+	for _, m := range g.Visitor.ModelMsgMap {
+		models[m] = true
+	}
+
+	var schema = &sqlSchema{Dialect: string(d)}
+	var tables = make(map[string]*sqlTable)
+	proto.Walk(definition, proto.WithMessage(func(m *proto.Message) {
+		if !models[m] {
+			return
+		}
+		g.genModelTableName(m)
+		t := g.sqlTableOf(m, g.Visitor.ModelTableNameMap[trim(m.Name)], d)
+		if exist, ok := tables[t.Name]; ok {
+			g.errorf(m.Position, "model %s: 表名 %s 已在第 %d 行使用", m.Name, t.Name, exist.pos.Line)
+			return
+		}
+		tables[t.Name] = t
+		schema.Tables = append(schema.Tables, t)
+	}))
+	return schema
+}
+
+// sqlTableOf model 对应的表 列名与类型和 gorm tag 的推导一致 @sql_type: 覆盖列类型
+func (g *Generator) sqlTableOf(m *proto.Message, tableName string, d sqlDialect) *sqlTable {
+	var t = &sqlTable{Name: tableName, pos: m.Position}
+	var indexes = make(map[string]*sqlIndex)
+	var addIndex = func(pos scanner.Position, name string, unique bool, col sqlIndexColumn) {
+		idx, ok := indexes[name]
+		if !ok {
+			idx = &sqlIndex{Name: name, Unique: unique}
+			indexes[name] = idx
+			t.Indexes = append(t.Indexes, idx)
+		}
+		if idx.Unique != unique {
+			g.errorf(pos, "model %s: 索引 %s 同时声明为普通索引与唯一索引", m.Name, name)
+			return
+		}
+		idx.Columns = append(idx.Columns, col)
+	}
+
+	var columns = make(map[string]bool)
+	for _, e := range m.Elements {
+		var field *proto.Field
+		var repeated bool
+		switch f := e.(type) {
+		case *proto.NormalField:
+			field, repeated = f.Field, f.Repeated
+		case *proto.MapField:
+			field, repeated = f.Field, true
+		default:
+			continue
+		}
+
+		col, tag := g.sqlColumnOf(field, repeated, d)
+		if col == nil {
+			continue
+		}
+		if columns[col.Name] {
+			g.errorf(field.Position, "model %s: 重复的列名 %s", m.Name, col.Name)
+			continue
+		}
+		columns[col.Name] = true
+		t.Columns = append(t.Columns, col)
+		if tag.has("primaryKey") {
+			t.PrimaryKey = append(t.PrimaryKey, col.Name)
+		}
+
+		// gorm 的 index uniqueIndex 值为 索引名,sort:desc 等选项 没有索引名时与 gorm 一致为 idx_表名_列名
+		for _, item := range tag.items {
+			if item.key != "index" && item.key != "uniqueIndex" {
+				continue
+			}
+			opts := strings.Split(item.value, ",")
+			name := trim(opts[0])
+			if name == "" {
+				name = fmt.Sprintf("idx_%s_%s", t.Name, col.Name)
+			} else {
+				name = d.indexName(t.Name, name)
+			}
+			var ic = sqlIndexColumn{Name: col.Name}
+			for _, opt := range opts[1:] {
+				ic.Desc = ic.Desc || strings.EqualFold(strings.ReplaceAll(opt, " ", ""), "sort:desc")
+			}
+			addIndex(field.Position, name, item.key == "uniqueIndex", ic)
+		}
+
+		if field.Comment == nil {
+			continue
+		}
+		for i, line := range field.Comment.Lines {
+			if !strings.Contains(line, "@index:") && !strings.Contains(line, "@unique_index:") {
+				continue
+			}
+			res := sqlIndexReg.FindStringSubmatch(line)
+			if len(res) != 4 {
+				g.indexAnnotationErr(commentLinePos(field.Comment, i), field.Name, line, "@index: 索引名 asc|desc")
+				continue
+			}
+			addIndex(commentLinePos(field.Comment, i), d.indexName(t.Name, res[2]), res[1] == "unique_index", sqlIndexColumn{
+				Name: col.Name,
+				Desc: strings.ToLower(res[3]) == "desc",
+			})
+		}
+	}
+	return t
+}
+
+// sqlColumnOf 字段对应的列 gorm tag 声明为 - 的字段与关联字段返回 nil
+func (g *Generator) sqlColumnOf(field *proto.Field, repeated bool, d sqlDialect) (*sqlColumn, *gormTag) {
+	var tag = new(gormTag)
+	var sqlType string
+	if field.Comment != nil {
+		gormReg := regexp.MustCompile(RegexpGorm)
+		sqlTypeReg := regexp.MustCompile(RegexpSQLType)
+		var hasGorm bool
+		for i, line := range field.Comment.Lines {
+			if res := gormReg.FindStringSubmatch(line); len(res) == 2 && !hasGorm {
+				hasGorm = true
+				if strings.ToLower(trim(res[1])) == "ignore" {
+					continue
+				}
+				t, err := parseGormTag(res[1])
+				if err != nil {
+					g.errorf(commentLinePos(field.Comment, i), "gorm %s: %v", field.Name, err)
+				}
+				tag = t
+			}
+			if res := sqlTypeReg.FindStringSubmatch(line); len(res) == 2 {
+				sqlType = trim(res[1])
+			}
+		}
+	}
+	if tag.has("-") {
+		return nil, tag
+	}
+
+	// repeated map message 字段以 json 保存
+	var typ = g.Visitor.scalarType(field.Type)
+	if repeated || !isBuiltInType(typ) {
+		typ = ""
+	}
+	if typ == "" && (tag.has("foreignKey") || tag.has("references") || tag.has("many2many") || tag.has("polymorphic")) {
+		return nil, tag
+	}
+	tag.infer(toTitle(field.Name), typ)
+
+	var col = &sqlColumn{
+		Name:          tag.column(field.Name),
+		Type:          sqlType,
+		NotNull:       tag.has("not null") || tag.has("primaryKey"),
+		Default:       tag.value("default"),
+		AutoIncrement: tag.has("autoIncrement"),
+		Comment:       tag.value("comment"),
+		pos:           field.Position,
+	}
+	if col.Comment == "" {
+		col.Comment = trim(getInlineComment(&proto.NormalField{Field: field}))
+	}
+	if col.Type == "" {
+		col.Type = tag.value("type")
+		// gorm 的通用类型按方言转换 与 proto 类型一致时保留 int32 int64 等的区别
+		if pt, ok := gormGenericTypes[col.Type]; ok || col.Type == "" {
+			if ok && gormDataType(typ) != col.Type {
+				typ = pt
+			}
+			col.Type = d.columnType(typ, tag.value("size"), tag.value("precision"), tag.value("scale"))
+		}
+	}
+	if col.AutoIncrement && d == SQLDialectSQLite {
+		// sqlite 只有 INTEGER PRIMARY KEY 才能自增
+		col.Type = "INTEGER"
+	}
+	return col, tag
+}
+
+// gormGenericTypes gorm 的通用类型对应的 proto 类型
+var gormGenericTypes = map[string]string{
+	"string": "string",
+	"int":    "int64",
+	"uint":   "uint64",
+	"float":  "double",
+	"bool":   "bool",
+	"bytes":  "bytes",
+	"time":   "time",
+}
+
+// sqlDialect SQL 方言
+type sqlDialect string
+
+func newSQLDialect(name string) (sqlDialect, error) {
+	switch strings.ToLower(name) {
+	case "", SQLDialectMySQL:
+		return SQLDialectMySQL, nil
+	case SQLDialectPostgres, "postgresql", "pg":
+		return SQLDialectPostgres, nil
+	case SQLDialectSQLite, "sqlite3":
+		return SQLDialectSQLite, nil
+	}
+	return "", fmt.Errorf("不支持的 SQL 方言 %q", name)
+}
+
+// columnType proto 类型对应的列类型 typ 为空时按 json 保存 time 为 gorm 的时间类型
+func (d sqlDialect) columnType(typ, size, precision, scale string) string {
+	var pick = func(mysql, postgres, sqlite string) string {
+		switch d {
+		case SQLDialectPostgres:
+			return postgres
+		case SQLDialectSQLite:
+			return sqlite
+		}
+		return mysql
+	}
+	switch typ {
+	case "string":
+		if size != "" {
+			return pick("VARCHAR("+size+")", "VARCHAR("+size+")", "TEXT")
+		}
+		return pick("VARCHAR(255)", "TEXT", "TEXT")
+	case "bool":
+		return pick("BOOLEAN", "BOOLEAN", "INTEGER")
+	case "int32", "sint32", "sfixed32":
+		return pick("INT", "INTEGER", "INTEGER")
+	case "int64", "sint64", "sfixed64":
+		return pick("BIGINT", "BIGINT", "INTEGER")
+	case "uint32", "fixed32":
+		return pick("INT UNSIGNED", "BIGINT", "INTEGER")
+	case "uint64", "fixed64":
+		return pick("BIGINT UNSIGNED", "NUMERIC(20)", "INTEGER")
+	case "float", "double":
+		if precision != "" {
+			var ps = precision
+			if scale != "" {
+				ps += "," + scale
+			}
+			return pick("DECIMAL("+ps+")", "NUMERIC("+ps+")", "NUMERIC")
+		}
+		if typ == "float" {
+			return pick("FLOAT", "REAL", "REAL")
+		}
+		return pick("DOUBLE", "DOUBLE PRECISION", "REAL")
+	case "bytes":
+		return pick("LONGBLOB", "BYTEA", "BLOB")
+	case "time":
+		return pick("DATETIME(3)", "TIMESTAMPTZ", "DATETIME")
+	}
+	return pick("JSON", "JSONB", "TEXT")
+}
+
+// quote 引用标识符
+func (d sqlDialect) quote(name string) string {
+	if d == SQLDialectMySQL {
+		return "`" + name + "`"
+	}
+	return `"` + name + `"`
+}
+
+// quoteList 引用多个标识符
+func (d sqlDialect) quoteList(names []string) string {
+	var res = make([]string, 0, len(names))
+	for _, name := range names {
+		res = append(res, d.quote(name))
+	}
+	return strings.Join(res, ", ")
+}
+
+// literal 字符串常量
+func (d sqlDialect) literal(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// defaultValue 列的默认值 与 gorm 一致 字符串列的值去掉引号后按字符串常量输出
+// NULL 与带括号的表达式原样输出
+func (d sqlDialect) defaultValue(c *sqlColumn) string {
+	typ := strings.ToUpper(c.Type)
+	if !strings.Contains(typ, "CHAR") && !strings.Contains(typ, "TEXT") {
+		return c.Default
+	}
+	if strings.EqualFold(c.Default, "null") || strings.Contains(c.Default, "(") && strings.Contains(c.Default, ")") {
+		return c.Default
+	}
+	return d.literal(strings.Trim(strings.Trim(c.Default, "'"), `"`))
+}
+
+// indexName 索引名 postgres sqlite 的索引名在整个 schema 中唯一 不以表名开头时加上表名前缀
+func (d sqlDialect) indexName(table, name string) string {
+	if d == SQLDialectMySQL || strings.HasPrefix(name, table+"_") {
+		return name
+	}
+	return table + "_" + name
+}
+
+// inlinePrimaryKey sqlite 自增主键只能在列定义中声明
+func (d sqlDialect) inlinePrimaryKey(t *sqlTable, c *sqlColumn) bool {
+	return d == SQLDialectSQLite && c.AutoIncrement && len(t.PrimaryKey) == 1 && t.PrimaryKey[0] == c.Name
+}
+
+// columnDef 列定义
+func (d sqlDialect) columnDef(t *sqlTable, c *sqlColumn) string {
+	var b strings.Builder
+	b.WriteString(d.quote(c.Name) + " " + c.Type)
+	if d.inlinePrimaryKey(t, c) {
+		b.WriteString(" PRIMARY KEY AUTOINCREMENT")
+		return b.String()
+	}
+	if c.AutoIncrement && d == SQLDialectPostgres {
+		b.WriteString(" GENERATED BY DEFAULT AS IDENTITY")
+	}
+	if c.NotNull {
+		b.WriteString(" NOT NULL")
+	}
+	if c.Default != "" {
+		b.WriteString(" DEFAULT " + d.defaultValue(c))
+	}
+	if c.AutoIncrement && d == SQLDialectMySQL {
+		b.WriteString(" AUTO_INCREMENT")
+	}
+	if c.Comment != "" && d == SQLDialectMySQL {
+		b.WriteString(" COMMENT " + d.literal(c.Comment))
+	}
+	return b.String()
+}
+
+// columnComment postgres 的列注释需要单独的语句
+func (d sqlDialect) columnComment(t *sqlTable, c *sqlColumn) string {
+	if d != SQLDialectPostgres {
+		return ""
+	}
+	var comment = "NULL"
+	if c.Comment != "" {
+		comment = d.literal(c.Comment)
+	}
+	return fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s;", d.quote(t.Name), d.quote(c.Name), comment)
+}
+
+// createSchema 所有表的建表语句
+func (d sqlDialect) createSchema(schema *sqlSchema) []string {
+	var stmts []string
+	for _, t := range schema.Tables {
+		stmts = append(stmts, d.createTable(t)...)
+	}
+	return stmts
+}
+
+// createTable 建表以及索引语句
+func (d sqlDialect) createTable(t *sqlTable) []string {
+	var defs []string
+	var inline bool
+	for _, c := range t.Columns {
+		defs = append(defs, "  "+d.columnDef(t, c))
+		inline = inline || d.inlinePrimaryKey(t, c)
+	}
+	if len(t.PrimaryKey) != 0 && !inline {
+		defs = append(defs, fmt.Sprintf("  PRIMARY KEY (%s)", d.quoteList(t.PrimaryKey)))
+	}
+	var stmts = []string{fmt.Sprintf("CREATE TABLE %s (\n%s\n);", d.quote(t.Name), strings.Join(defs, ",\n"))}
+	for _, c := range t.Columns {
+		if c.Comment != "" && d == SQLDialectPostgres {
+			stmts = append(stmts, d.columnComment(t, c))
+		}
+	}
+	for _, idx := range t.Indexes {
+		stmts = append(stmts, d.createIndex(t, idx))
+	}
+	return stmts
+}
+
+// createIndex 建索引语句
+func (d sqlDialect) createIndex(t *sqlTable, idx *sqlIndex) string {
+	var cols []string
+	for _, c := range idx.Columns {
+		if c.Desc {
+			cols = append(cols, d.quote(c.Name)+" DESC")
+			continue
+		}
+		cols = append(cols, d.quote(c.Name))
+	}
+	var unique string
+	if idx.Unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s);", unique, d.quote(idx.Name), d.quote(t.Name), strings.Join(cols, ", "))
+}
+
+// dropIndex 删除索引语句
+func (d sqlDialect) dropIndex(t *sqlTable, idx *sqlIndex) string {
+	if d == SQLDialectMySQL {
+		return fmt.Sprintf("DROP INDEX %s ON %s;", d.quote(idx.Name), d.quote(t.Name))
+	}
+	return fmt.Sprintf("DROP INDEX %s;", d.quote(idx.Name))
+}
+
+// diffSQLSchema 快照 old 到当前表结构 cur 的迁移语句 按表名比较 不识别重命名
+// 先删除变化的索引与主键 再改列 最后重建主键与索引 sqlite 不支持的修改记录警告
+func (g *Generator) diffSQLSchema(d sqlDialect, old, cur *sqlSchema) []string {
+	var stmts []string
+	var oldTables = make(map[string]*sqlTable)
+	for _, t := range old.Tables {
+		oldTables[t.Name] = t
+	}
+	var curTables = make(map[string]bool)
+	for _, t := range cur.Tables {
+		curTables[t.Name] = true
+		ot, ok := oldTables[t.Name]
+		if !ok {
+			stmts = append(stmts, d.createTable(t)...)
+			continue
+		}
+		stmts = append(stmts, g.diffSQLTable(d, ot, t)...)
+	}
+	var dropped []string
+	for _, t := range old.Tables {
+		if !curTables[t.Name] {
+			dropped = append(dropped, t.Name)
+		}
+	}
+	sort.Strings(dropped)
+	for _, name := range dropped {
+		stmts = append(stmts, fmt.Sprintf("DROP TABLE %s;", d.quote(name)))
+	}
+	return stmts
+}
+
+// diffSQLTable 同名表的迁移语句
+func (g *Generator) diffSQLTable(d sqlDialect, ot, t *sqlTable) []string {
+	var oldIndexes = make(map[string]*sqlIndex)
+	for _, idx := range ot.Indexes {
+		oldIndexes[idx.Name] = idx
+	}
+	var curIndexes = make(map[string]*sqlIndex)
+	for _, idx := range t.Indexes {
+		curIndexes[idx.Name] = idx
+	}
+	var oldColumns = make(map[string]*sqlColumn)
+	for _, c := range ot.Columns {
+		oldColumns[c.Name] = c
+	}
+	var curColumns = make(map[string]*sqlColumn)
+	for _, c := range t.Columns {
+		curColumns[c.Name] = c
+	}
+
+	var stmts []string
+	for _, idx := range ot.Indexes {
+		if cur, ok := curIndexes[idx.Name]; !ok || !reflect.DeepEqual(cur, idx) {
+			stmts = append(stmts, d.dropIndex(t, idx))
+		}
+	}
+
+	pkChanged := !reflect.DeepEqual(ot.PrimaryKey, t.PrimaryKey)
+	if pkChanged && d == SQLDialectSQLite {
+		g.warnf(t.pos, "table %s: sqlite 不支持修改主键 需要手动重建表", t.Name)
+		pkChanged = false
+	}
+	if pkChanged && len(ot.PrimaryKey) != 0 {
+		if d == SQLDialectMySQL {
+			stmts = append(stmts, d.alterTable(t, "DROP PRIMARY KEY"))
+		} else {
+			stmts = append(stmts, d.alterTable(t, "DROP CONSTRAINT %s", d.quote(t.Name+"_pkey")))
+		}
+	}
+
+	for _, c := range ot.Columns {
+		if _, ok := curColumns[c.Name]; !ok {
+			stmts = append(stmts, d.alterTable(t, "DROP COLUMN %s", d.quote(c.Name)))
+		}
+	}
+	for _, c := range t.Columns {
+		oc, ok := oldColumns[c.Name]
+		if !ok {
+			stmts = append(stmts, d.alterTable(t, "ADD COLUMN %s", d.columnDef(t, c)))
+			if c.Comment != "" && d == SQLDialectPostgres {
+				stmts = append(stmts, d.columnComment(t, c))
+			}
+			continue
+		}
+		if sameSQLColumn(oc, c) {
+			continue
+		}
+		switch d {
+		case SQLDialectMySQL:
+			stmts = append(stmts, d.alterTable(t, "MODIFY COLUMN %s", d.columnDef(t, c)))
+		case SQLDialectPostgres:
+			stmts = append(stmts, d.alterPostgresColumn(t, oc, c)...)
+		default:
+			g.warnf(c.pos, "table %s: sqlite 不支持修改列 %s 需要手动重建表", t.Name, c.Name)
+		}
+	}
+
+	if pkChanged && len(t.PrimaryKey) != 0 {
+		stmts = append(stmts, d.alterTable(t, "ADD PRIMARY KEY (%s)", d.quoteList(t.PrimaryKey)))
+	}
+	for _, idx := range t.Indexes {
+		if old, ok := oldIndexes[idx.Name]; !ok || !reflect.DeepEqual(old, idx) {
+			stmts = append(stmts, d.createIndex(t, idx))
+		}
+	}
+	return stmts
+}
+
+// sameSQLColumn 列定义是否相同 不比较位置
+func sameSQLColumn(a, b *sqlColumn) bool {
+	x, y := *a, *b
+	x.pos, y.pos = scanner.Position{}, scanner.Position{}
+	return x == y
+}
+
+// alterTable 修改表的语句
+func (d sqlDialect) alterTable(t *sqlTable, format string, args ...interface{}) string {
+	return fmt.Sprintf("ALTER TABLE %s %s;", d.quote(t.Name), fmt.Sprintf(format, args...))
+}
+
+// alterPostgresColumn postgres 按变化的属性分别修改列
+func (d sqlDialect) alterPostgresColumn(t *sqlTable, oc, c *sqlColumn) []string {
+	var alter = func(format string, args ...interface{}) string {
+		return d.alterTable(t, format, args...)
+	}
+	var name = d.quote(c.Name)
+	var stmts []string
+	if oc.Type != c.Type {
+		stmts = append(stmts, alter("ALTER COLUMN %s TYPE %s USING %s::%s", name, c.Type, name, c.Type))
+	}
+	if oc.AutoIncrement != c.AutoIncrement {
+		if c.AutoIncrement {
+			stmts = append(stmts, alter("ALTER COLUMN %s ADD GENERATED BY DEFAULT AS IDENTITY", name))
+		} else {
+			stmts = append(stmts, alter("ALTER COLUMN %s DROP IDENTITY", name))
+		}
+	}
+	if oc.NotNull != c.NotNull {
+		if c.NotNull {
+			stmts = append(stmts, alter("ALTER COLUMN %s SET NOT NULL", name))
+		} else {
+			stmts = append(stmts, alter("ALTER COLUMN %s DROP NOT NULL", name))
+		}
+	}
+	if oc.Default != c.Default {
+		if c.Default != "" {
+			stmts = append(stmts, alter("ALTER COLUMN %s SET DEFAULT %s", name, d.defaultValue(c)))
+		} else {
+			stmts = append(stmts, alter("ALTER COLUMN %s DROP DEFAULT", name))
+		}
+	}
+	if oc.Comment != c.Comment {
+		stmts = append(stmts, d.columnComment(t, c))
+	}
+	return stmts
+}
@@ -0,0 +1,170 @@
+package proto_parser
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sqlTestProto = `syntax = "proto3";
+package user;
+
+// @table_name: users
+message ModelUser {
+    // @gorm: primaryKey
+    int64 id = 1;
+    // @gorm: size:64;not null
+    // @unique_index: idx_user_name asc
+    string user_name = 2;
+    // @gorm: default:0
+    // @index: idx_age_score desc
+    int32 age = 3;
+    // @sql_type: DECIMAL(10,2)
+    // @index: idx_age_score asc
+    double score = 4; // 积分
+    repeated string tags = 5;
+    // @gorm: -
+    string tmp = 6;
+    // @gorm: default:guest
+    string nick = 7;
+}
+`
+
+func writeSQLTestProto(t *testing.T, pbFile, src string) {
+	if err := ioutil.WriteFile(pbFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutputSQL(t *testing.T) {
+	dir := t.TempDir()
+	pbFile := filepath.Join(dir, "user.proto")
+	writeSQLTestProto(t, pbFile, sqlTestProto)
+
+	output := filepath.Join(dir, "user.sql")
+	if err := NewGenerator().OutputSQL(pbFile, &SQLConfig{Output: output}); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(output)
+	want := "CREATE TABLE `users` (\n" +
+		"  `id` BIGINT NOT NULL AUTO_INCREMENT,\n" +
+		"  `user_name` VARCHAR(64) NOT NULL,\n" +
+		"  `age` INT DEFAULT 0,\n" +
+		"  `score` DECIMAL(10,2) COMMENT '积分',\n" +
+		"  `tags` JSON,\n" +
+		"  `nick` VARCHAR(255) DEFAULT 'guest',\n" +
+		"  PRIMARY KEY (`id`)\n" +
+		");\n" +
+		"CREATE UNIQUE INDEX `idx_user_name` ON `users` (`user_name`);\n" +
+		"CREATE INDEX `idx_age_score` ON `users` (`age` DESC, `score`);\n"
+	if string(content) != want {
+		t.Errorf("unexpected ddl:\n%s", content)
+	}
+
+	if err := NewGenerator().OutputSQL(pbFile, &SQLConfig{Output: output, Dialect: SQLDialectPostgres}); err != nil {
+		t.Fatal(err)
+	}
+	content, _ = ioutil.ReadFile(output)
+	for _, want := range []string{
+		`"id" BIGINT GENERATED BY DEFAULT AS IDENTITY NOT NULL,`,
+		`"tags" JSONB,`,
+		`COMMENT ON COLUMN "users"."score" IS '积分';`,
+		`"nick" TEXT DEFAULT 'guest'`,
+		`CREATE UNIQUE INDEX "users_idx_user_name" ON "users" ("user_name");`,
+		`CREATE INDEX "users_idx_age_score" ON "users" ("age" DESC, "score");`,
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("want %q in:\n%s", want, content)
+		}
+	}
+
+	if err := NewGenerator().OutputSQL(pbFile, &SQLConfig{Output: output, Dialect: SQLDialectSQLite}); err != nil {
+		t.Fatal(err)
+	}
+	content, _ = ioutil.ReadFile(output)
+	if !strings.Contains(string(content), `"id" INTEGER PRIMARY KEY AUTOINCREMENT,`) || strings.Contains(string(content), "PRIMARY KEY (") {
+		t.Errorf("unexpected sqlite ddl:\n%s", content)
+	}
+}
+
+func TestOutputSQLMigrate(t *testing.T) {
+	dir := t.TempDir()
+	pbFile := filepath.Join(dir, "user.proto")
+	writeSQLTestProto(t, pbFile, sqlTestProto)
+	config := &SQLConfig{Output: filepath.Join(dir, "user.sql"), Migrate: true}
+
+	if err := NewGenerator().OutputSQL(pbFile, config); err != nil {
+		t.Fatal(err)
+	}
+	first, err := ioutil.ReadFile(filepath.Join(dir, "migrations", "mysql", "0001_user.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(first), "CREATE TABLE `users`") {
+		t.Errorf("unexpected first migration:\n%s", first)
+	}
+
+	// 没有变化时不输出新版本
+	if err := NewGenerator().OutputSQL(pbFile, config); err != nil {
+		t.Fatal(err)
+	}
+	if IsExist(filepath.Join(dir, "migrations", "mysql", "0002_user.sql")) {
+		t.Fatal("unexpected migration without changes")
+	}
+
+	src := strings.Replace(sqlTestProto, "// @gorm: size:64;not null", "// @gorm: size:128;not null", 1)
+	src = strings.Replace(src, "    // @index: idx_age_score asc\n", "", 1)
+	src = strings.Replace(src, "repeated string tags = 5;", "string email = 5;", 1)
+	writeSQLTestProto(t, pbFile, src)
+	if err := NewGenerator().OutputSQL(pbFile, config); err != nil {
+		t.Fatal(err)
+	}
+	second, err := ioutil.ReadFile(filepath.Join(dir, "migrations", "mysql", "0002_user.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-- 由 user.proto 生成 版本 2\n" +
+		"DROP INDEX `idx_age_score` ON `users`;\n" +
+		"ALTER TABLE `users` DROP COLUMN `tags`;\n" +
+		"ALTER TABLE `users` MODIFY COLUMN `user_name` VARCHAR(128) NOT NULL;\n" +
+		"ALTER TABLE `users` ADD COLUMN `email` VARCHAR(255);\n" +
+		"CREATE INDEX `idx_age_score` ON `users` (`age` DESC);\n"
+	if string(second) != want {
+		t.Errorf("unexpected migration:\n%s", second)
+	}
+
+	// 同一迁移目录下的其他 proto 共用快照与版本序列 不会删除其他文件的表
+	orderFile := filepath.Join(dir, "order.proto")
+	writeSQLTestProto(t, orderFile, `syntax = "proto3";
+package user;
+
+// @table_name: orders
+message ModelOrder {
+    int64 id = 1;
+}
+`)
+	if err := NewGenerator().OutputSQL(orderFile, config); err != nil {
+		t.Fatal(err)
+	}
+	third, err := ioutil.ReadFile(filepath.Join(dir, "migrations", "mysql", "0003_order.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(third), "CREATE TABLE `orders`") || strings.Contains(string(third), "users") {
+		t.Errorf("unexpected migration:\n%s", third)
+	}
+	if err := NewGenerator().OutputSQL(pbFile, config); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "migrations", "mysql", "*.sql"))
+	if len(files) != 3 {
+		t.Errorf("unexpected migrations: %v", files)
+	}
+
+	// 表名不能与同一迁移目录下其他文件的表重复
+	writeSQLTestProto(t, orderFile, strings.Replace(sqlTestProto, "message ModelUser", "message ModelOrder", 1))
+	if err := NewGenerator().OutputSQL(orderFile, config); err == nil {
+		t.Error("expect duplicated table error")
+	}
+}
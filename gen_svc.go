@@ -1,13 +1,23 @@
 package proto_parser
 
 import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
 	"regexp"
 	"strings"
 
 	"github.com/emicklei/proto"
+	"github.com/sirupsen/logrus"
 )
 
-func parseSrvGenRPC(srv *proto.Service) {
+func (g *Generator) parseSrvGenRPC(srv *proto.Service) {
 	if srv.Comment == nil {
 		return
 	}
@@ -57,15 +67,281 @@ func parseSrvGenRPC(srv *proto.Service) {
 	}
 
 	// 开始操作一拨
-	genServiceAllRpc(srv, svcDesc, genTo)
+	g.genServiceAllRpc(srv, svcDesc, genTo)
 }
 
-func genServiceAllRpc(srv *proto.Service, svcDesc, genTo string) {
-	// 取 genTo 不存在就创建文件
-	// 检测是否实现接口 没实现则生成实现方法
+// RpcServiceNode grpc service 中单个 rpc 的生成信息
+type RpcServiceNode struct {
+	FuncName       string // 函数名
+	Describe       string // 描述
+	ReqType        string // 请求结构体 带包名
+	RespType       string // 响应结构体 带包名
+	StreamsRequest bool   // 客户端流
+	StreamsReturns bool   // 服务端流
+	imports        []string
 }
 
-func parseSrvGenTask(srv *proto.Service) {
+// SvcAstTree 用来解析 @gen_to 的 .go 文件 识别是否实现 grpc service
+type SvcAstTree struct {
+	pos           *token.FileSet
+	fileTree      *ast.File
+	src           []byte
+	goPath        string
+	srvName       string
+	srvDesc       string
+	pkgName       string
+	qualifier     string // proto 生成代码的包名前缀 同包时为空
+	importPackage string
+	rpcNode       []*RpcServiceNode
+}
+
+func (g *Generator) genServiceAllRpc(srv *proto.Service, svcDesc, genTo string) {
+	if genTo == "" {
+		logrus.Errorf("service %s: @gen_to not found, skip...", srv.Name)
+		return
+	}
+	defer g.lockFile(genTo)()
+
+	var tree = &SvcAstTree{
+		goPath:  genTo,
+		srvName: srv.Name,
+		srvDesc: svcDesc,
+		pkgName: fixPkgName(path.Base(path.Dir(genTo))),
+	}
+	if tree.pkgName == "." {
+		realPath, _ := os.Getwd()
+		tree.pkgName = fixPkgName(filepath.Base(realPath))
+	}
+
+	// 取 proto 生成代码的导入路径 同包则不需要导入
+	var importPackagePart = g.Visitor.PackageName
+	if goPkg := getGoPackageOption(g.pbFilePath); goPkg != "" {
+		tree.importPackage, importPackagePart = fixImportPackage(goPkg)
+	}
+	if importPackagePart != tree.pkgName {
+		tree.qualifier = importPackagePart + "."
+	} else {
+		tree.importPackage = ""
+	}
+
+	for _, elem := range srv.Elements {
+		rpc, ok := elem.(*proto.RPC)
+		if !ok {
+			continue
+		}
+		var node = &RpcServiceNode{
+			FuncName:       rpc.Name,
+			StreamsRequest: rpc.StreamsRequest,
+			StreamsReturns: rpc.StreamsReturns,
+		}
+		node.ReqType = g.svcGoTypeName(tree, node, rpc.RequestType)
+		node.RespType = g.svcGoTypeName(tree, node, rpc.ReturnsType)
+		if rpc.Comment != nil {
+			for _, line := range rpc.Comment.Lines {
+				if !strings.Contains(line, "@desc") {
+					continue
+				}
+				reg := regexp.MustCompile(RegexpRouterRpcDesc)
+				res := reg.FindAllStringSubmatch(line, -1)
+				if len(res) == 1 && len(res[0]) == 2 {
+					node.Describe = trim(res[0][1])
+					break
+				}
+			}
+		}
+		tree.rpcNode = append(tree.rpcNode, node)
+	}
+
+	if err := tree.parseGoFile(); err != nil {
+		logrus.Errorf("gen service %s err: %+v", srv.Name, err)
+	}
+}
+
+// svcGoTypeName proto 类型名转换为 go 类型名 外部包的类型通过符号表取所在文件的 go_package 并记录到 node 需要的导入
+func (g *Generator) svcGoTypeName(tree *SvcAstTree, node *RpcServiceNode, typ string) string {
+	sym := g.Visitor.GetSymbols().Lookup(g.Visitor.ProtoPackage, typ)
+	if sym == nil {
+		if importPath, ok := wellKnownGoPackages[strings.TrimPrefix(typ, ".")]; ok {
+			node.addImport(importPath)
+			return path.Base(importPath) + "." + typ[strings.LastIndex(typ, ".")+1:]
+		}
+		logrus.Warnf("service %s: type %s not found, use it as is", tree.srvName, typ)
+		return tree.qualifier + typ
+	}
+	if sym.File.Package == g.Visitor.ProtoPackage {
+		return tree.qualifier + sym.GoName()
+	}
+
+	importPath, pkgName := sym.File.GoImport()
+	if importPath == "" {
+		logrus.Warnf("service %s: %s has no go_package, use proto package %s", tree.srvName, sym.File.Path, sym.File.Package)
+		return sym.File.GoPackageName() + "." + sym.GoName()
+	}
+	// 不是完整导入路径时 与当前文件的 go_package 一样基于 go.mod 的模块名补全
+	if !strings.Contains(strings.Split(importPath, "/")[0], ".") {
+		importPath, _ = fixImportPackage(importPath)
+		importPath = strings.Trim(importPath, "\"")
+	}
+	if pkgName == tree.pkgName {
+		return sym.GoName()
+	}
+	node.addImport(importPath)
+	return pkgName + "." + sym.GoName()
+}
+
+// addImport 记录 rpc 中外部包类型的导入路径
+func (node *RpcServiceNode) addImport(importPath string) {
+	for _, p := range node.imports {
+		if p == importPath {
+			return
+		}
+	}
+	node.imports = append(node.imports, importPath)
+}
+
+// typeImports 需要生成的 rpc 中外部包类型的导入路径
+func (tree *SvcAstTree) typeImports() []string {
+	var res []string
+	var exist = make(map[string]struct{})
+	for _, node := range tree.rpcNode {
+		for _, p := range node.imports {
+			if _, ok := exist[p]; ok {
+				continue
+			}
+			exist[p] = struct{}{}
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// needContext 是否有非流式的 rpc 需要导入 context
+func (tree *SvcAstTree) needContext() bool {
+	for _, node := range tree.rpcNode {
+		if !node.StreamsRequest && !node.StreamsReturns {
+			return true
+		}
+	}
+	return false
+}
+
+func (tree *SvcAstTree) dataMap() map[string]interface{} {
+	return map[string]interface{}{
+		"needContext":   tree.needContext(),
+		"srvName":       tree.srvName,
+		"srvDesc":       tree.srvDesc,
+		"pkgName":       tree.pkgName,
+		"qualifier":     tree.qualifier,
+		"importPackage": tree.importPackage,
+		"typeImports":   tree.typeImports(),
+		"rpcNode":       tree.rpcNode,
+	}
+}
+
+// parseGoFile 取 genTo 不存在就创建文件 存在则检测是否实现接口 没实现则生成实现方法
+func (tree *SvcAstTree) parseGoFile() error {
+	src, err := ioutil.ReadFile(tree.goPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logrus.Errorf("read file err: %+v", err)
+			return err
+		}
+		buf, err := executeTpl("generate_svc_file", CompleteRpcServiceGenerateAndPackageTpl, tree.dataMap())
+		if err != nil {
+			return err
+		}
+		return writeGoFile(tree.goPath, buf)
+	}
+
+	tree.src = src
+	tree.pos = token.NewFileSet()
+	tree.fileTree, err = parser.ParseFile(tree.pos, tree.goPath, src, parser.ParseComments)
+	if err != nil {
+		logrus.Errorf("parse file err: %+v", err)
+		return err
+	}
+
+	return tree.checkAndGenerateService()
+}
+
+// checkAndGenerateService 补全 struct 的 Unimplemented 内嵌 未实现的 rpc 方法和注册函数
+func (tree *SvcAstTree) checkAndGenerateService() error {
+	var edits []srcEdit
+	var appendTpl []string
+	var needFunc, needRegister bool
+
+	st := findStructType(tree.fileTree, tree.srvName)
+	if st == nil {
+		appendTpl = append(appendTpl, CompleteRpcServiceGenerateTpl)
+		needFunc, needRegister = true, true
+	} else {
+		// 检查是否内嵌了 UnimplementedXxxServer
+		if !hasEmbeddedField(st, fmt.Sprintf("Unimplemented%sServer", tree.srvName)) {
+			buf, err := executeTpl("generate_svc_embed", FieldRpcServiceEmbedTpl, tree.dataMap())
+			if err != nil {
+				return err
+			}
+			edits = append(edits, srcEdit{
+				offset: tree.pos.Position(st.Fields.Opening).Offset + 1,
+				text:   string(buf),
+			})
+		}
+
+		// 检查 rpc 方法是否全部实现
+		implemented := receiverFuncNames(tree.fileTree, tree.srvName)
+		var noImplNode []*RpcServiceNode
+		for _, node := range tree.rpcNode {
+			if _, ok := implemented[node.FuncName]; !ok {
+				noImplNode = append(noImplNode, node)
+			}
+		}
+		tree.rpcNode = noImplNode
+		if len(noImplNode) != 0 {
+			appendTpl = append(appendTpl, FuncRpcServiceGenerateTpl)
+			needFunc = true
+		}
+
+		// 检查注册函数
+		if !hasFuncDecl(tree.fileTree, fmt.Sprintf("Register%s", tree.srvName)) {
+			appendTpl = append(appendTpl, FuncRpcServiceRegisterTpl)
+			needRegister = true
+		}
+	}
+
+	if len(edits) == 0 && len(appendTpl) == 0 {
+		return nil
+	}
+
+	for i, tpl := range appendTpl {
+		buf, err := executeTpl(fmt.Sprintf("generate_svc_%d", i), tpl, tree.dataMap())
+		if err != nil {
+			return err
+		}
+		edits = append(edits, srcEdit{offset: len(tree.src), text: string(buf)})
+	}
+
+	// 补全依赖包
+	var imports []string
+	if needFunc && tree.needContext() {
+		imports = append(imports, "context")
+	}
+	if needRegister {
+		imports = append(imports, "google.golang.org/grpc")
+	}
+	if tree.importPackage != "" {
+		imports = append(imports, strings.Trim(tree.importPackage, "\""))
+	}
+	if needFunc {
+		imports = append(imports, tree.typeImports()...)
+	}
+	if edit, ok := missingImportEdit(tree.pos, tree.fileTree, imports); ok {
+		edits = append(edits, edit)
+	}
+
+	return writeGoFile(tree.goPath, applySrcEdits(tree.src, edits))
+}
+
+func (g *Generator) parseSrvGenTask(srv *proto.Service) {
 	if srv.Comment == nil {
 		return
 	}
@@ -110,7 +386,7 @@ func parseSrvGenTask(srv *proto.Service) {
 		}
 		node := TaskNode{}
 		docs := rpc.Comment.Lines
-		for _, doc := range docs {
+		for i, doc := range docs {
 			if strings.Contains(doc, "@desc:") {
 				reg := regexp.MustCompile(RegexpRouterRpcDesc)
 				res := reg.FindAllStringSubmatch(doc, -1)
@@ -156,13 +432,15 @@ func parseSrvGenTask(srv *proto.Service) {
 				res := reg.FindAllStringSubmatch(doc, -1)
 				if len(res) == 1 && len(res[0]) == 2 {
 					node.Type = string2Int64(trim(res[0][1]))
+					// 0永续任务 1时间范围执行任务 2指定了执行次数的任务
+					if node.Type > 2 {
+						g.errorf(commentLinePos(rpc.Comment, i), "task %s: 不支持的任务类型 %d 可选 0 1 2", rpc.Name, node.Type)
+					}
 					continue
 				}
+				g.errorf(commentLinePos(rpc.Comment, i), "task %s: 任务类型格式错误: %s", rpc.Name, trim(doc))
 			}
 		}
-		Visitor.AddTask(srv.Name, rpc.Name, genTo, node)
+		g.Visitor.AddTask(srv.Name, rpc.Name, genTo, node)
 	}
-
-	// 代码生成
-	TaskCodeGenTo()
 }
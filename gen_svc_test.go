@@ -0,0 +1,146 @@
+package proto_parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSvcAstTree_checkAndGenerateService(t *testing.T) {
+	goFile := filepath.Join(t.TempDir(), "user_service.go")
+	src := `package services
+
+import (
+	"context"
+
+	"example.com/pb"
+)
+
+type UserSvc struct{}
+
+func (s *UserSvc) Get(ctx context.Context, req *pb.GetReq) (*pb.GetResp, error) {
+	return new(pb.GetResp), nil
+}
+`
+	if err := ioutil.WriteFile(goFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var tree = &SvcAstTree{
+		goPath:        goFile,
+		srvName:       "UserSvc",
+		pkgName:       "services",
+		qualifier:     "pb.",
+		importPackage: `"example.com/pb"`,
+		rpcNode: []*RpcServiceNode{
+			{FuncName: "Get", ReqType: "pb.GetReq", RespType: "pb.GetResp"},
+			{FuncName: "Put", ReqType: "pb.PutReq", RespType: "pb.PutResp"},
+			{FuncName: "Watch", ReqType: "pb.WatchReq", RespType: "pb.WatchResp", StreamsReturns: true},
+		},
+	}
+	if err := tree.parseGoFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(goFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := string(content)
+	for _, want := range []string{
+		"pb.UnimplementedUserSvcServer",
+		"func (s *UserSvc) Put(ctx context.Context, req *pb.PutReq) (*pb.PutResp, error)",
+		"func (s *UserSvc) Watch(req *pb.WatchReq, stream pb.UserSvc_WatchServer) error",
+		"func RegisterUserSvc(s *grpc.Server)",
+		`"google.golang.org/grpc"`,
+	} {
+		if !strings.Contains(res, want) {
+			t.Errorf("want %q in:\n%s", want, res)
+		}
+	}
+	if strings.Count(res, "func (s *UserSvc) Get(") != 1 {
+		t.Errorf("implemented rpc should not be generated again:\n%s", res)
+	}
+}
+
+func TestGenerator_svcGoTypeName(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "common"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	var files = map[string]string{
+		filepath.Join(dir, "common", "page.proto"): `syntax = "proto3";
+package common;
+option go_package = "example.com/common;commonpb";
+message Page {
+    int64 size = 1;
+}
+`,
+		filepath.Join(dir, "user.proto"): `syntax = "proto3";
+package user;
+import "google/protobuf/empty.proto";
+import "common/page.proto";
+message GetReq {
+    message Inner {}
+}
+service UserSvc {
+    rpc Get(GetReq) returns (google.protobuf.Empty);
+    rpc List(common.Page) returns (GetReq.Inner);
+}
+`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pbFile := filepath.Join(dir, "user.proto")
+	definition, err := openProtoFile(pbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGenerator()
+	g.Visitor.ProtoPackage = "user"
+	if err := g.Visitor.GetSymbols().AddFile(pbFile, definition); err != nil {
+		t.Fatal(err)
+	}
+
+	var tree = &SvcAstTree{
+		goPath:        filepath.Join(dir, "user_service.go"),
+		srvName:       "UserSvc",
+		pkgName:       "services",
+		qualifier:     "pb.",
+		importPackage: `"example.com/pb"`,
+	}
+	for _, c := range []struct{ typ, want string }{
+		{"GetReq", "pb.GetReq"},
+		{"GetReq.Inner", "pb.GetReq_Inner"},
+		{"google.protobuf.Empty", "emptypb.Empty"},
+		{"common.Page", "commonpb.Page"},
+	} {
+		node := &RpcServiceNode{FuncName: "Get"}
+		if got := g.svcGoTypeName(tree, node, c.typ); got != c.want {
+			t.Errorf("%s: want %s, got %s", c.typ, c.want, got)
+		}
+	}
+
+	tree.rpcNode = []*RpcServiceNode{{FuncName: "Get", ReqType: "pb.GetReq"}}
+	tree.rpcNode[0].RespType = g.svcGoTypeName(tree, tree.rpcNode[0], "google.protobuf.Empty")
+	if err := tree.parseGoFile(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(tree.goPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"google.golang.org/protobuf/types/known/emptypb"`,
+		"func (s *UserSvc) Get(ctx context.Context, req *pb.GetReq) (*emptypb.Empty, error)",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("want %q in:\n%s", want, content)
+		}
+	}
+}
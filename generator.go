@@ -0,0 +1,161 @@
+package proto_parser
+
+import "sync"
+
+// Generator 一次代码生成的上下文 持有本次运行的全部状态
+// 不同的 Generator 之间不共享状态 可以在同一进程中并发使用 单个 Generator 不支持并发调用
+type Generator struct {
+	// 当前处理的 proto 文件解析结果
+	Visitor *ProtoVisitor
+	// 控制 ModelTpl 模板不生成 GetScope 函数
+	noGetScopeFunc bool
+	// 限频规则输出路径
+	freqRuleOutput string
+	// 错误码由 CodeGen 按目录合并输出 不再逐个文件生成
+	mergeErrCode bool
+	// 路由组 @gen_to 文件中 rpc 已删除的方法注释掉 默认只输出警告
+	commentRemovedRPC bool
+	// 把 @gen_to 中用到的错误码写回源 proto 的 @error
+	rewriteErrCode bool
+	// proto 文件位置
+	pbFilePath string
+	// 输出文档的 service 与 rpc
+	srvName string
+	rpcName string
+	// 注解诊断
+	diags Diagnostics
+	// 并发生成时共享的文件锁 防止多个 proto 同时改写同一个 @gen_to 文件
+	fileLocker *fileLocker
+	// 一次运行中按目录缓存的包 分析 @gen_to 中用到的错误码
+	errCodePkgs *errCodePkgCache
+}
+
+// fork 创建一个继承运行配置的子 Generator 用于并发生成单个文件
+func (g *Generator) fork() *Generator {
+	return &Generator{
+		Visitor:           &ProtoVisitor{},
+		noGetScopeFunc:    g.noGetScopeFunc,
+		freqRuleOutput:    g.freqRuleOutput,
+		mergeErrCode:      g.mergeErrCode,
+		commentRemovedRPC: g.commentRemovedRPC,
+		rewriteErrCode:    g.rewriteErrCode,
+		fileLocker:        g.fileLocker,
+		errCodePkgs:       g.errCodePkgs,
+	}
+}
+
+// lockFile 锁住一个要改写的文件 返回解锁函数 非并发模式下不加锁
+func (g *Generator) lockFile(file string) func() {
+	if g.fileLocker == nil {
+		return func() {}
+	}
+	return g.fileLocker.lock(file)
+}
+
+type fileLocker struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newFileLocker() *fileLocker {
+	return &fileLocker{locks: make(map[string]*sync.Mutex)}
+}
+
+func (l *fileLocker) lock(file string) func() {
+	file = absPath(file)
+	l.mu.Lock()
+	m, ok := l.locks[file]
+	if !ok {
+		m = new(sync.Mutex)
+		l.locks[file] = m
+	}
+	l.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
+
+// NewGenerator 创建一个新的生成上下文
+func NewGenerator() *Generator {
+	return &Generator{Visitor: &ProtoVisitor{}}
+}
+
+// reset 清空上一次调用留下的状态 每个入口方法开始时调用
+func (g *Generator) reset() {
+	g.Visitor = &ProtoVisitor{}
+	g.pbFilePath = ""
+	g.srvName = ""
+	g.rpcName = ""
+	g.diags = nil
+	g.errCodePkgs = nil
+}
+
+// CodeGen 使用新的 Generator 生成代码 见 Generator.CodeGen
+func CodeGen(config *CodeGenConfig) error {
+	return NewGenerator().CodeGen(config)
+}
+
+// ParseProto 使用新的 Generator 解析 proto 见 Generator.ParseProto
+func ParseProto(pbFile string) (midFile string, err error) {
+	return NewGenerator().ParseProto(pbFile)
+}
+
+// AddAPI 使用新的 Generator 添加 API 见 Generator.AddAPI
+func AddAPI(pbFile, groupRouter, apiName, method string) error {
+	return NewGenerator().AddAPI(pbFile, groupRouter, apiName, method)
+}
+
+// AddRPC 使用新的 Generator 添加 RPC 见 Generator.AddRPC
+func AddRPC(pbFile, srvName, apiName string) error {
+	return NewGenerator().AddRPC(pbFile, srvName, apiName)
+}
+
+// AddRoute 使用新的 Generator 添加路由组 见 Generator.AddRoute
+func AddRoute(pbFile, routeName, api, genTo string) error {
+	return NewGenerator().AddRoute(pbFile, routeName, api, genTo)
+}
+
+// AddSvc 使用新的 Generator 添加 service 见 Generator.AddSvc
+func AddSvc(pbFile, svcName, genTo string) error {
+	return NewGenerator().AddSvc(pbFile, svcName, genTo)
+}
+
+// AddTask 使用新的 Generator 添加任务 见 Generator.AddTask
+func AddTask(pbFile, taskSvc, taskName, genTo string) error {
+	return NewGenerator().AddTask(pbFile, taskSvc, taskName, genTo)
+}
+
+// OutputMD 使用新的 Generator 输出 markdown 文档 见 Generator.OutputMD
+func OutputMD(pbFile, srv, rpc string, includes []string, config ...*DocConfig) error {
+	return NewGenerator().OutputMD(pbFile, srv, rpc, includes, config...)
+}
+
+// Lint 使用新的 Generator 检查 proto 见 Generator.Lint
+func Lint(pbFiles []string, rules ...string) (LintResult, error) {
+	return NewGenerator().Lint(pbFiles, rules...)
+}
+
+// OutputOpenAPI 使用新的 Generator 输出 OpenAPI 文档 见 Generator.OutputOpenAPI
+func OutputOpenAPI(pbFiles, includes []string) ([]byte, error) {
+	return NewGenerator().OutputOpenAPI(pbFiles, includes)
+}
+
+// OutputMDSite 使用新的 Generator 输出整个目录的 markdown 文档 见 Generator.OutputMDSite
+func OutputMDSite(protoDir, outDir string, includes []string, config ...*DocConfig) error {
+	return NewGenerator().OutputMDSite(protoDir, outDir, includes, config...)
+}
+
+// CheckErrCode 使用新的 Generator 检查 @error 与代码中的错误码是否一致 见 Generator.CheckErrCode
+func CheckErrCode(pbFiles []string) error {
+	return NewGenerator().CheckErrCode(pbFiles)
+}
+
+// OutputSQL 使用新的 Generator 输出 gdbc model 的建表语句与迁移 见 Generator.OutputSQL
+func OutputSQL(pbFile string, config ...*SQLConfig) error {
+	return NewGenerator().OutputSQL(pbFile, config...)
+}
+
+// OutputMongo 使用新的 Generator 输出 mongodb model 的索引与校验 见 Generator.OutputMongo
+func OutputMongo(pbFile string, config ...*MongoConfig) error {
+	return NewGenerator().OutputMongo(pbFile, config...)
+}
@@ -0,0 +1,54 @@
+package proto_parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestGenerator_AddRouteIsolated(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for i := 0; i < 4; i++ {
+		pbFile := filepath.Join(dir, fmt.Sprintf("svc_%d.proto", i))
+		if err := ioutil.WriteFile(pbFile, []byte("syntax = \"proto3\";\npackage svc;\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, pbFile)
+	}
+
+	// 不同 Generator 并发处理不同文件
+	var wg sync.WaitGroup
+	var errs = make([]error, len(files))
+	for i, pbFile := range files {
+		wg.Add(1)
+		go func(i int, pbFile string) {
+			defer wg.Done()
+			errs[i] = NewGenerator().AddRoute(pbFile, "UserAPI", "/api/user", "./internal/controller/user.go")
+		}(i, pbFile)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("%s: %+v", files[i], err)
+		}
+	}
+
+	// 同一个 Generator 连续调用 上一次的 service 不应该残留
+	g := NewGenerator()
+	other := filepath.Join(dir, "other.proto")
+	if err := ioutil.WriteFile(other, []byte("syntax = \"proto3\";\npackage svc;\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddRoute(files[0], "OrderAPI", "/api/order", "./internal/controller/order.go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.AddRoute(other, "OrderAPI", "/api/order", "./internal/controller/order.go"); err != nil {
+		t.Errorf("state leaked between calls: %+v", err)
+	}
+	if err := g.AddRoute(files[0], "OrderAPI", "/api/order", "./internal/controller/order.go"); err == nil {
+		t.Error("want duplicate route group error")
+	}
+}
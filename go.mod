@@ -1,6 +1,7 @@
 module github.com/actorbuf/proto-parser
 
-go 1.16
+// golang.org/x/tools v0.9.1 需要 go 1.18
+go 1.18
 
 require (
 	github.com/actorbuf/iota v0.0.2
@@ -12,4 +13,7 @@ require (
 	github.com/golang/protobuf v1.5.2
 	github.com/jhump/protoreflect v1.10.3
 	github.com/sirupsen/logrus v1.8.1
+	golang.org/x/tools v0.9.1
+	// NativeCompile 在 PATH 中没有 protoc-gen-go 时使用 internal_gengo(非公开 API) 升级前需验证生成的 .pb.go
+	google.golang.org/protobuf v1.27.1
 )
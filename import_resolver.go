@@ -0,0 +1,275 @@
+package proto_parser
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/emicklei/proto"
+)
+
+// ProtoFile 已加载的 proto 文件
+type ProtoFile struct {
+	Path       string       // 文件绝对路径
+	Package    string       // proto 包名 保留 . 分隔
+	Imports    []string     // import 的文件
+	Definition *proto.Proto // 解析结果
+}
+
+// ProtoSymbol 符号表中的一个定义 message 或 enum 二选一
+type ProtoSymbol struct {
+	FullName string // 全限定名 pkg.Outer.Inner
+	Name     string // 定义名
+	File     *ProtoFile
+	Message  *proto.Message
+	Enum     *proto.Enum
+}
+
+// wellKnownGoPackages protoc 内置的 google.protobuf 类型不在符号表中 按类型名对应 go 导入路径
+var wellKnownGoPackages = map[string]string{
+	"google.protobuf.Any":         "google.golang.org/protobuf/types/known/anypb",
+	"google.protobuf.Duration":    "google.golang.org/protobuf/types/known/durationpb",
+	"google.protobuf.Empty":       "google.golang.org/protobuf/types/known/emptypb",
+	"google.protobuf.FieldMask":   "google.golang.org/protobuf/types/known/fieldmaskpb",
+	"google.protobuf.Struct":      "google.golang.org/protobuf/types/known/structpb",
+	"google.protobuf.Value":       "google.golang.org/protobuf/types/known/structpb",
+	"google.protobuf.ListValue":   "google.golang.org/protobuf/types/known/structpb",
+	"google.protobuf.Timestamp":   "google.golang.org/protobuf/types/known/timestamppb",
+	"google.protobuf.DoubleValue": "google.golang.org/protobuf/types/known/wrapperspb",
+	"google.protobuf.FloatValue":  "google.golang.org/protobuf/types/known/wrapperspb",
+	"google.protobuf.Int64Value":  "google.golang.org/protobuf/types/known/wrapperspb",
+	"google.protobuf.UInt64Value": "google.golang.org/protobuf/types/known/wrapperspb",
+	"google.protobuf.Int32Value":  "google.golang.org/protobuf/types/known/wrapperspb",
+	"google.protobuf.UInt32Value": "google.golang.org/protobuf/types/known/wrapperspb",
+	"google.protobuf.BoolValue":   "google.golang.org/protobuf/types/known/wrapperspb",
+	"google.protobuf.StringValue": "google.golang.org/protobuf/types/known/wrapperspb",
+	"google.protobuf.BytesValue":  "google.golang.org/protobuf/types/known/wrapperspb",
+}
+
+// GoImport 文件中 option go_package 声明的导入路径与包名 go_package 可以写成 导入路径;包名
+// 没有写包名时取导入路径的最后一级 没有声明 go_package 时都为空
+func (f *ProtoFile) GoImport() (importPath, pkgName string) {
+	for _, elem := range f.Definition.Elements {
+		if opt, ok := elem.(*proto.Option); ok && opt.Name == "go_package" {
+			importPath = opt.Constant.Source
+		}
+	}
+	if idx := strings.Index(importPath, ";"); idx != -1 {
+		importPath, pkgName = importPath[:idx], importPath[idx+1:]
+	}
+	if pkgName == "" && importPath != "" {
+		pkgName = path.Base(importPath)
+	}
+	return importPath, pkgName
+}
+
+// GoPackageName 文件生成代码的 go 包名 没有声明 go_package 时按 proto 包名 . 换成 _
+func (f *ProtoFile) GoPackageName() string {
+	if _, pkgName := f.GoImport(); pkgName != "" {
+		return pkgName
+	}
+	return strings.ReplaceAll(f.Package, ".", "_")
+}
+
+// GoName 定义生成的 go 类型名 嵌套定义用 _ 连接
+func (s *ProtoSymbol) GoName() string {
+	name := strings.TrimPrefix(s.FullName, s.File.Package+".")
+	return strings.ReplaceAll(name, ".", "_")
+}
+
+// SymbolTable 符号表 以全限定名为 key 记录所有递归 import 的 proto 文件中定义的 message 与 enum
+type SymbolTable struct {
+	includePaths []string
+	files        map[string]*ProtoFile
+	symbols      map[string]*ProtoSymbol
+	messages     map[*proto.Message]*ProtoSymbol
+}
+
+// NewSymbolTable 创建符号表 includePaths 为 import 的查找路径
+func NewSymbolTable(includePaths []string) *SymbolTable {
+	return &SymbolTable{
+		includePaths: includePaths,
+		files:        make(map[string]*ProtoFile),
+		symbols:      make(map[string]*ProtoSymbol),
+		messages:     make(map[*proto.Message]*ProtoSymbol),
+	}
+}
+
+// AddFile 注册一个已解析的 proto 文件 并递归加载它的所有 import
+func (st *SymbolTable) AddFile(pbFile string, definition *proto.Proto) error {
+	absPath, err := filepath.Abs(pbFile)
+	if err != nil {
+		return err
+	}
+	if _, exist := st.files[absPath]; exist {
+		return nil
+	}
+
+	var file = &ProtoFile{Path: absPath, Definition: definition}
+	st.files[absPath] = file
+
+	for _, elem := range definition.Elements {
+		switch t := elem.(type) {
+		case *proto.Package:
+			file.Package = t.Name
+		case *proto.Import:
+			file.Imports = append(file.Imports, t.Filename)
+		}
+	}
+
+	st.addElements(file, file.Package, definition.Elements)
+
+	var errs []string
+	for _, imp := range file.Imports {
+		if err := st.LoadImport(filepath.Dir(pbFile), imp); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s: %s", pbFile, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// LoadImport 在 dir 与 include 路径下查找 import 的文件 并递归加载
+func (st *SymbolTable) LoadImport(dir, filename string) error {
+	pbFile, ok := st.resolvePath(dir, filename)
+	if !ok {
+		// google/protobuf 下的文件由 protoc 内置 找不到也不影响
+		if strings.HasPrefix(filename, "google/protobuf/") {
+			return nil
+		}
+		return fmt.Errorf("import %s not found in %v", filename, append([]string{dir}, st.includePaths...))
+	}
+
+	absPath, err := filepath.Abs(pbFile)
+	if err != nil {
+		return err
+	}
+	if _, exist := st.files[absPath]; exist {
+		return nil
+	}
+
+	definition, err := openProtoFile(pbFile)
+	if err != nil {
+		return err
+	}
+	return st.AddFile(pbFile, definition)
+}
+
+// resolvePath 依次在 dir include 路径 当前目录下查找文件
+func (st *SymbolTable) resolvePath(dir, filename string) (string, bool) {
+	var candidates = []string{filepath.Join(dir, filename)}
+	for _, inc := range st.includePaths {
+		candidates = append(candidates, filepath.Join(inc, filename))
+	}
+	candidates = append(candidates, filename)
+
+	for _, c := range candidates {
+		fi, err := os.Stat(c)
+		if err == nil && !fi.IsDir() {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+func (st *SymbolTable) addElements(file *ProtoFile, prefix string, elements []proto.Visitee) {
+	for _, elem := range elements {
+		switch t := elem.(type) {
+		case *proto.Message:
+			fullName := joinFullName(prefix, t.Name)
+			st.symbols[fullName] = &ProtoSymbol{FullName: fullName, Name: t.Name, File: file, Message: t}
+			st.messages[t] = st.symbols[fullName]
+			st.addElements(file, fullName, t.Elements)
+		case *proto.Enum:
+			fullName := joinFullName(prefix, t.Name)
+			st.symbols[fullName] = &ProtoSymbol{FullName: fullName, Name: t.Name, File: file, Enum: t}
+		}
+	}
+}
+
+// Lookup 按 proto 的作用域规则查找类型 scope 为引用方所在的包名或 message 全名
+func (st *SymbolTable) Lookup(scope, typ string) *ProtoSymbol {
+	if st == nil || typ == "" {
+		return nil
+	}
+	// 以 . 开头的是全限定名
+	if strings.HasPrefix(typ, ".") {
+		return st.symbols[strings.TrimPrefix(typ, ".")]
+	}
+	// 由内向外逐级查找
+	for {
+		if sym, ok := st.symbols[joinFullName(scope, typ)]; ok {
+			return sym
+		}
+		if scope == "" {
+			return nil
+		}
+		if idx := strings.LastIndex(scope, "."); idx != -1 {
+			scope = scope[:idx]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+// LookupMessage 查找 message
+func (st *SymbolTable) LookupMessage(scope, typ string) *proto.Message {
+	if sym := st.Lookup(scope, typ); sym != nil {
+		return sym.Message
+	}
+	return nil
+}
+
+// MessageSymbol message 在符号表中的定义 用于按它所在的作用域查找字段类型
+func (st *SymbolTable) MessageSymbol(msg *proto.Message) *ProtoSymbol {
+	if st == nil {
+		return nil
+	}
+	return st.messages[msg]
+}
+
+// LookupEnum 查找 enum
+func (st *SymbolTable) LookupEnum(scope, typ string) *proto.Enum {
+	if sym := st.Lookup(scope, typ); sym != nil {
+		return sym.Enum
+	}
+	return nil
+}
+
+// Files 所有已加载的文件 按路径排序
+func (st *SymbolTable) Files() []*ProtoFile {
+	if st == nil {
+		return nil
+	}
+	var files []*ProtoFile
+	for _, f := range st.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
+// ErrCodeEnums 所有已加载文件中顶层的 ErrCode 枚举
+func (st *SymbolTable) ErrCodeEnums() []*ProtoSymbol {
+	var res []*ProtoSymbol
+	for _, f := range st.Files() {
+		if sym, ok := st.symbols[joinFullName(f.Package, ErrCodeName)]; ok && sym.Enum != nil && sym.File == f {
+			res = append(res, sym)
+		}
+	}
+	return res
+}
+
+func joinFullName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
@@ -0,0 +1,78 @@
+package proto_parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymbolTable_LoadImport(t *testing.T) {
+	dir := t.TempDir()
+	incDir := filepath.Join(dir, "include")
+	if err := os.MkdirAll(filepath.Join(incDir, "common"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	var files = map[string]string{
+		filepath.Join(incDir, "common", "page.proto"): `syntax = "proto3";
+package common;
+import "common/status.proto";
+message Page {
+    int64 size = 1;
+    Status status = 2;
+    message Cursor {}
+}
+`,
+		filepath.Join(incDir, "common", "status.proto"): `syntax = "proto3";
+package common;
+enum Status {
+    StatusNil = 0;
+}
+enum ErrCode {
+    Nil = 0;
+    NotFound = 10001; // 未找到
+}
+`,
+		filepath.Join(dir, "user.proto"): `syntax = "proto3";
+package user;
+import "common/page.proto";
+message ModelUser {
+    common.Page page = 1;
+}
+`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pbFile := filepath.Join(dir, "user.proto")
+	definition, err := openProtoFile(pbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := NewSymbolTable([]string{incDir})
+	if err := st.AddFile(pbFile, definition); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(st.Files()) != 3 {
+		t.Fatalf("want 3 files, got %d", len(st.Files()))
+	}
+	if st.LookupMessage("user", "common.Page") == nil {
+		t.Error("common.Page not found")
+	}
+	if st.LookupMessage("user", ".common.Page.Cursor") == nil {
+		t.Error("common.Page.Cursor not found")
+	}
+	if st.LookupEnum("common.Page", "Status") == nil {
+		t.Error("common.Status not found from common.Page scope")
+	}
+	if st.LookupMessage("user", "Page") != nil {
+		t.Error("Page should not be visible from package user")
+	}
+	if len(st.ErrCodeEnums()) != 1 {
+		t.Errorf("want 1 ErrCode enum, got %d", len(st.ErrCodeEnums()))
+	}
+}
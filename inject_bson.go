@@ -10,15 +10,22 @@ import (
 )
 
 // injectMongoModelTag 遍历 msg 的字段 取出 comment 进行tag注入
-func injectMongoModelTag(msg *proto.Message) {
+func (g *Generator) injectMongoModelTag(msg *proto.Message) {
 	for _, m := range msg.Elements {
 		if field, ok := m.(*proto.NormalField); ok {
 			if field.Comment == nil {
 				field.Comment = new(proto.Comment)
 			}
-			field.Comment.Lines = injectBsonTag(field, field.Name, field.Comment.Lines)
+			field.Comment.Lines = g.injectBsonTag(field, field.Name, field.Comment.Lines)
 
 			if !isBuiltInType(field.Type) {
+				// 带包名的类型 走符号表查找 外部文件的 message 不注入 bson 字段名由 bsonFieldName 读取注解
+				if strings.Contains(field.Type, ".") {
+					if pm, local := g.lookupImportMessage(field.Type); pm != nil && local {
+						g.injectMongoModelTag(pm)
+					}
+					continue
+				}
 				// 非内建类型 找上级msg 看是否在allMsg中 不在 则直接找 全局m.Name
 				var trackList []string
 				var nowPath []string
@@ -31,43 +38,80 @@ func injectMongoModelTag(msg *proto.Message) {
 				var prefix string
 				for i := len(trackList) - 1; i >= 0; i-- {
 					prefix = trackList[i] + "_" + field.Type
-					if pm, exist := Visitor.AllMsgMap[prefix]; exist {
-						injectMongoModelTag(pm)
+					if pm, exist := g.Visitor.AllMsgMap[prefix]; exist {
+						g.injectMongoModelTag(pm)
 						break
 					}
 					continue
 				}
 
-				if pm, exist := Visitor.AllMsgMap[field.Type]; exist {
-					injectMongoModelTag(pm)
+				if pm, exist := g.Visitor.AllMsgMap[field.Type]; exist {
+					g.injectMongoModelTag(pm)
 					continue
 				}
 			}
 		}
 
 		if field, ok := m.(*proto.Message); ok {
-			injectMongoModelTag(field)
+			g.injectMongoModelTag(field)
 		}
 	}
 }
 
-// injectTag 注入bson tag
-func injectBsonTag(field *proto.NormalField, fieldName string, doc []string) []string {
-	fieldName = toTitle(fieldName)
+// bsonFieldPrefix 字段在 BsonTagMap 中的 key 由外层 message 与字段名组成
+func bsonFieldPrefix(field *proto.NormalField, fieldName string) string {
 	gener := getOuterForefathersNameArr(field.Parent.(*proto.Message))
 	gener = append(gener, fieldName)
 	var newGender []string
 	for _, node := range gener {
 		newGender = append(newGender, case2Camel(node))
 	}
-	prefix := strings.Join(newGender, "_")
+	return strings.Join(newGender, "_")
+}
+
+// bsonFieldName 注入后字段的 bson 字段名 没有注入时按默认规则生成
+// 外部文件的字段不会注入 直接读取字段上的 @bson 注解
+func (g *Generator) bsonFieldName(field *proto.NormalField) string {
+	fieldName := toTitle(field.Name)
+	if parent, ok := field.Parent.(*proto.Message); ok && !g.isLocalMessage(parent) {
+		return bsonAnnotation(field, fieldName)
+	}
+	if v, ok := g.Visitor.BsonTagMap[bsonFieldPrefix(field, fieldName)]; ok {
+		return v
+	}
+	return calm2CaseBSON(fieldName)
+}
+
+// bsonAnnotation 字段注释中第一个 @bson 注解声明的字段名 ignore 时为 proto 字段名 没有注解时按默认规则生成
+func bsonAnnotation(field *proto.NormalField, fieldName string) string {
+	if field.Comment == nil {
+		return calm2CaseBSON(fieldName)
+	}
+	var bsonReg = regexp.MustCompile(RegexpBson)
+	for _, doc := range field.Comment.Lines {
+		res := bsonReg.FindAllStringSubmatch(doc, -1)
+		if len(res) == 0 {
+			continue
+		}
+		if strings.ToLower(res[0][2]) == "ignore" {
+			return field.Name
+		}
+		return res[0][2]
+	}
+	return calm2CaseBSON(fieldName)
+}
+
+// injectTag 注入bson tag
+func (g *Generator) injectBsonTag(field *proto.NormalField, fieldName string, doc []string) []string {
+	fieldName = toTitle(fieldName)
+	prefix := bsonFieldPrefix(field, fieldName)
 	var result []string
 
 	if len(doc) == 0 {
 		var val = calm2CaseBSON(fieldName)
 		result = append(result, fmt.Sprintf("@gotags: bson:\"%s\"", val))
-		Visitor.AddBsonTag(prefix, val)
-		addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, val, trim(getInlineComment(field)))
+		g.Visitor.AddBsonTag(prefix, val)
+		g.addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, val, trim(getInlineComment(field)))
 		return result
 	}
 
@@ -78,14 +122,14 @@ func injectBsonTag(field *proto.NormalField, fieldName string, doc []string) []s
 			res := bsonReg.FindAllStringSubmatch(t, -1)
 			if len(res) >= 1 {
 				if strings.ToLower(res[0][2]) == "ignore" {
-					Visitor.AddBsonTag(prefix, field.Name)
-					addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, field.Name, trim(getInlineComment(field)))
+					g.Visitor.AddBsonTag(prefix, field.Name)
+					g.addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, field.Name, trim(getInlineComment(field)))
 					return doc
 				}
 				t = strings.Replace(t, "@bson", "@gotags", 1)
 				t = strings.Replace(t, res[0][2], fmt.Sprintf("bson:\"%s\"", res[0][2]), 1)
-				Visitor.AddBsonTag(prefix, res[0][2])
-				addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, res[0][2], trim(getInlineComment(field)))
+				g.Visitor.AddBsonTag(prefix, res[0][2])
+				g.addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, res[0][2], trim(getInlineComment(field)))
 				// 匹配上了 只替换第一个bson 多个bson配置取最开始一个
 				result = append(result, t)
 				isInject = true
@@ -100,25 +144,25 @@ func injectBsonTag(field *proto.NormalField, fieldName string, doc []string) []s
 		var val = calm2CaseBSON(fieldName)
 		result = append(result, fmt.Sprintf("@gotags: bson:\"%s\"", val))
 		//bsonTagFieldMap[prefix] = val
-		Visitor.AddBsonTag(prefix, val)
-		addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, val, trim(getInlineComment(field)))
+		g.Visitor.AddBsonTag(prefix, val)
+		g.addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, val, trim(getInlineComment(field)))
 	}
 
 	return result
 }
 
-func addBsonFieldToMap(m *proto.Message, modelName, fieldName, prefix, value, inlineComment string) {
-	if Visitor.ModelFieldStructMap == nil {
-		Visitor.ModelFieldStructMap = make(map[string]map[string]ModelFieldStruct)
+func (g *Generator) addBsonFieldToMap(m *proto.Message, modelName, fieldName, prefix, value, inlineComment string) {
+	if g.Visitor.ModelFieldStructMap == nil {
+		g.Visitor.ModelFieldStructMap = make(map[string]map[string]ModelFieldStruct)
 	}
 
-	if _, exist := Visitor.ModelFieldStructMap[modelName]; !exist {
-		Visitor.ModelFieldStructMap[modelName] = make(map[string]ModelFieldStruct)
+	if _, exist := g.Visitor.ModelFieldStructMap[modelName]; !exist {
+		g.Visitor.ModelFieldStructMap[modelName] = make(map[string]ModelFieldStruct)
 	}
 
 	prefix = strings.ReplaceAll(prefix, modelName+"_", "")
 
-	Visitor.ModelFieldStructMap[modelName][prefix] = ModelFieldStruct{
+	g.Visitor.ModelFieldStructMap[modelName][prefix] = ModelFieldStruct{
 		StructFieldName: case2Camel(fieldName),
 		DbFieldName:     value,
 		Comment:         inlineComment,
@@ -126,7 +170,7 @@ func addBsonFieldToMap(m *proto.Message, modelName, fieldName, prefix, value, in
 }
 
 // injectBsonMessage 如果message 开启了bson注入 则全字段注入
-func injectBsonMessage(m *proto.Message) {
+func (g *Generator) injectBsonMessage(m *proto.Message) {
 	if m.Comment == nil {
 		return
 	}
@@ -148,11 +192,11 @@ func injectBsonMessage(m *proto.Message) {
 		if result[0][2] != "true" {
 			continue
 		}
-		injectBsonMessageTag(m)
+		g.injectBsonMessageTag(m)
 	}
 }
 
-func injectBsonMessageTag(msg *proto.Message) {
+func (g *Generator) injectBsonMessageTag(msg *proto.Message) {
 	for _, m := range msg.Elements {
 		if field, ok := m.(*proto.NormalField); ok {
 			if field.Comment == nil {
@@ -161,6 +205,13 @@ func injectBsonMessageTag(msg *proto.Message) {
 			field.Comment.Lines = injectMsgBsonTag(field, field.Name, field.Comment.Lines)
 
 			if !isBuiltInType(field.Type) {
+				// 带包名的类型 只处理定义在当前文件的 message
+				if strings.Contains(field.Type, ".") {
+					if pm, local := g.lookupImportMessage(field.Type); pm != nil && local {
+						g.injectBsonMessageTag(pm)
+					}
+					continue
+				}
 				// 非内建类型 找上级msg 看是否在allMsg中 不在 则直接找 全局m.Name
 				var trackList []string
 				var nowPath []string
@@ -173,35 +224,29 @@ func injectBsonMessageTag(msg *proto.Message) {
 				var prefix string
 				for i := len(trackList) - 1; i >= 0; i-- {
 					prefix = trackList[i] + "_" + field.Type
-					if pm, exist := Visitor.AllMsgMap[prefix]; exist {
-						injectBsonMessageTag(pm)
+					if pm, exist := g.Visitor.AllMsgMap[prefix]; exist {
+						g.injectBsonMessageTag(pm)
 						break
 					}
 					continue
 				}
 
-				if pm, exist := Visitor.AllMsgMap[field.Type]; exist {
-					injectBsonMessageTag(pm)
+				if pm, exist := g.Visitor.AllMsgMap[field.Type]; exist {
+					g.injectBsonMessageTag(pm)
 					continue
 				}
 			}
 		}
 
 		if field, ok := m.(*proto.Message); ok {
-			injectBsonMessage(field)
+			g.injectBsonMessage(field)
 		}
 	}
 }
 
 func injectMsgBsonTag(field *proto.NormalField, fieldName string, doc []string) []string {
 	fieldName = toTitle(fieldName)
-	gener := getOuterForefathersNameArr(field.Parent.(*proto.Message))
-	gener = append(gener, fieldName)
-	var newGender []string
-	for _, node := range gener {
-		newGender = append(newGender, case2Camel(node))
-	}
-	prefix := strings.Join(newGender, "_")
+	prefix := bsonFieldPrefix(field, fieldName)
 	var result []string
 
 	if len(doc) == 0 {
@@ -241,3 +286,17 @@ func injectMsgBsonTag(field *proto.NormalField, fieldName string, doc []string)
 
 	return result
 }
+
+// lookupImportMessage 通过符号表查找带包名的类型 local 表示是否定义在当前文件中
+func (g *Generator) lookupImportMessage(typ string) (msg *proto.Message, local bool) {
+	msg = g.Visitor.LookupMessage(typ)
+	if msg == nil {
+		return nil, false
+	}
+	return msg, g.isLocalMessage(msg)
+}
+
+// isLocalMessage message 是否定义在当前文件中
+func (g *Generator) isLocalMessage(msg *proto.Message) bool {
+	return g.Visitor.AllMsgMap[getOuterForefathersNameJoin(msg)] == msg
+}
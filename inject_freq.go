@@ -8,15 +8,15 @@ import (
 	"strings"
 )
 
-func injectFreqMap(svc *proto.Service) {
+func (g *Generator) injectFreqMap(svc *proto.Service) {
 	if len(svc.Elements) == 0 {
 		return
 	}
-	if svc.Comment == nil || len(svc.Comment.Lines) == 0 {
-		return
+	// 取路由前缀 没有注释的 service 前缀为空 仍需检查 rpc 的 @freq
+	var prefix string
+	if svc.Comment != nil {
+		prefix = getSvcRouterAPI(svc.Comment.Lines)
 	}
-	// 取路由前缀
-	prefix := getSvcRouterAPI(svc.Comment.Lines)
 
 	for _, element := range svc.Elements {
 		rpc, ok := element.(*proto.RPC)
@@ -28,22 +28,18 @@ func injectFreqMap(svc *proto.Service) {
 		}
 		doc := rpc.Comment.Lines
 		suffix := getRpcRouterAPI(doc)
-		for _, c := range doc {
+		for i, c := range doc {
 			if strings.Contains(c, "@freq") {
 				r := regexp.MustCompile(RegexpFreq)
 				res := r.FindAllStringSubmatch(c, -1)
-				if len(res) != 1 {
-					fmt.Println("限频格式错误: ", c)
-					continue
-				}
-				if len(res[0]) != 2 {
-					fmt.Println("限频格式错误: ", c)
+				if len(res) != 1 || len(res[0]) != 2 {
+					g.errorf(commentLinePos(rpc.Comment, i), "限频格式错误 应为 @freq: 分钟 小时 天: %s", trim(c))
 					continue
 				}
 				freqData := trim(res[0][1])
 				freqS := strings.Split(freqData, " ")
 				if len(freqS) != 3 {
-					fmt.Println("限频格式错误: ", freqS)
+					g.errorf(commentLinePos(rpc.Comment, i), "限频格式错误 应为 @freq: 分钟 小时 天: %s", trim(c))
 					continue
 				}
 				c := core.FreqConfig{
@@ -51,7 +47,7 @@ func injectFreqMap(svc *proto.Service) {
 					Hour:   string2Int64(freqS[1]),
 					Day:    string2Int64(freqS[2]),
 				}
-				Visitor.AddFreq(fmt.Sprintf("%s%s", prefix, suffix), c)
+				g.Visitor.AddFreq(fmt.Sprintf("%s%s", prefix, suffix), c)
 			}
 		}
 	}
@@ -0,0 +1,243 @@
+package proto_parser
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var goTagsReg = regexp.MustCompile(`@gotags:\s*(.*)`)
+
+// InjectTagError 注入 tag 时的错误 带 .pb.go 文件位置
+type InjectTagError struct {
+	Pos token.Position
+	Msg string
+}
+
+func (e *InjectTagError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// InjectTagErrors 单个文件注入 tag 的所有错误
+type InjectTagErrors []*InjectTagError
+
+func (es InjectTagErrors) Error() string {
+	var lines = make([]string, 0, len(es))
+	for _, e := range es {
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// structTag 结构体 tag 中的一项 key:"value"
+type structTag struct {
+	key   string
+	value string
+}
+
+// parseStructTag 按 reflect.StructTag 的规则解析 tag 保留原有顺序
+func parseStructTag(tag string) ([]structTag, error) {
+	var res []structTag
+	for {
+		tag = strings.TrimLeft(tag, " ")
+		if tag == "" {
+			return res, nil
+		}
+
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return nil, fmt.Errorf("bad syntax for struct tag: `%s`", tag)
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return nil, fmt.Errorf("bad syntax for struct tag value: `%s`", tag)
+		}
+		value, err := strconv.Unquote(tag[:i+1])
+		if err != nil {
+			return nil, fmt.Errorf("bad syntax for struct tag value: `%s`", tag[:i+1])
+		}
+		tag = tag[i+1:]
+		res = append(res, structTag{key: key, value: value})
+	}
+}
+
+// formatStructTag 输出 tag 字面量
+func formatStructTag(tags []structTag) string {
+	var items = make([]string, 0, len(tags))
+	for _, t := range tags {
+		items = append(items, fmt.Sprintf("%s:%s", t.key, strconv.Quote(t.value)))
+	}
+	return fmt.Sprintf("`%s`", strings.Join(items, " "))
+}
+
+// fieldInjectTags 读取字段注释中的 @gotags 同一个 key 给出不同值视为冲突
+func fieldInjectTags(fset *token.FileSet, groups ...*ast.CommentGroup) ([]structTag, InjectTagErrors) {
+	var res []structTag
+	var errs InjectTagErrors
+	var exist = make(map[string]string)
+	for _, group := range groups {
+		if group == nil {
+			continue
+		}
+		for _, c := range group.List {
+			match := goTagsReg.FindStringSubmatch(c.Text)
+			if len(match) != 2 {
+				continue
+			}
+			tags, err := parseStructTag(strings.TrimSpace(strings.TrimSuffix(match[1], "*/")))
+			if err != nil {
+				errs = append(errs, &InjectTagError{Pos: fset.Position(c.Pos()), Msg: err.Error()})
+				continue
+			}
+			for _, t := range tags {
+				if old, ok := exist[t.key]; ok {
+					if old != t.value {
+						errs = append(errs, &InjectTagError{
+							Pos: fset.Position(c.Pos()),
+							Msg: fmt.Sprintf("tag %s conflict: %q and %q", t.key, old, t.value),
+						})
+					}
+					continue
+				}
+				exist[t.key] = t.value
+				res = append(res, t)
+			}
+		}
+	}
+	return res, errs
+}
+
+// mergeStructTag 注入的 tag 覆盖同名 key 其余追加在末尾
+func mergeStructTag(origin, inject []structTag) []structTag {
+	var res = append([]structTag(nil), origin...)
+	for _, t := range inject {
+		var replaced bool
+		for i := range res {
+			if res[i].key == t.key {
+				res[i].value = t.value
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// hasInjectTag 注释中是否带有 @gotags
+func hasInjectTag(group *ast.CommentGroup) bool {
+	if group == nil {
+		return false
+	}
+	for _, c := range group.List {
+		if goTagsReg.MatchString(c.Text) {
+			return true
+		}
+	}
+	return false
+}
+
+// injectGoTags 将 .pb.go 中字段注释的 @gotags 合并进结构体 tag
+// 替代 protoc-go-inject-tag 不再依赖外部命令
+func injectGoTags(goFile string) error {
+	src, err := ioutil.ReadFile(goFile)
+	if err != nil {
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+
+	var fset = token.NewFileSet()
+	f, err := parser.ParseFile(fset, goFile, src, parser.ParseComments)
+	if err != nil {
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+
+	var edits []srcEdit
+	var errs InjectTagErrors
+	var used = make(map[*ast.CommentGroup]struct{})
+	ast.Inspect(f, func(n ast.Node) bool {
+		st, ok := n.(*ast.StructType)
+		if !ok || st.Fields == nil {
+			return true
+		}
+		for _, field := range st.Fields.List {
+			if !hasInjectTag(field.Doc) && !hasInjectTag(field.Comment) {
+				continue
+			}
+			if field.Doc != nil {
+				used[field.Doc] = struct{}{}
+			}
+			if field.Comment != nil {
+				used[field.Comment] = struct{}{}
+			}
+			inject, injectErrs := fieldInjectTags(fset, field.Doc, field.Comment)
+			errs = append(errs, injectErrs...)
+			if len(inject) == 0 {
+				continue
+			}
+
+			var origin []structTag
+			var start, end = fset.Position(field.Type.End()).Offset, fset.Position(field.Type.End()).Offset
+			var text string
+			if field.Tag != nil {
+				tag, err := strconv.Unquote(field.Tag.Value)
+				if err == nil {
+					origin, err = parseStructTag(tag)
+				}
+				if err != nil {
+					errs = append(errs, &InjectTagError{Pos: fset.Position(field.Tag.Pos()), Msg: err.Error()})
+					continue
+				}
+				start = fset.Position(field.Tag.Pos()).Offset
+				end = fset.Position(field.Tag.End()).Offset
+				text = formatStructTag(mergeStructTag(origin, inject))
+			} else {
+				text = " " + formatStructTag(inject)
+			}
+			edits = append(edits, srcEdit{offset: start, end: end, text: text})
+		}
+		return true
+	})
+
+	// 没有落在结构体字段上的 @gotags 无法注入
+	for _, group := range f.Comments {
+		if _, ok := used[group]; ok || !hasInjectTag(group) {
+			continue
+		}
+		errs = append(errs, &InjectTagError{
+			Pos: fset.Position(group.Pos()),
+			Msg: "@gotags not attached to a struct field",
+		})
+	}
+
+	if len(errs) > 0 {
+		logrus.Errorf("inject tag err: %+v", errs)
+		return errs
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	return writeGoFile(goFile, applySrcEdits(src, edits))
+}
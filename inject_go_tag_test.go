@@ -0,0 +1,54 @@
+package proto_parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInjectGoTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inject_tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	goFile := filepath.Join(dir, "a.pb.go")
+	src := "package pb\n\ntype User struct {\n" +
+		"\t// @gotags: bson:\"_id\" json:\"id\"\n" +
+		"\tId string `protobuf:\"bytes,1,opt,name=id,proto3\" json:\"id,omitempty\"`\n" +
+		"\tName string // @gotags: bson:\"name\"\n" +
+		"}\n"
+	if err := ioutil.WriteFile(goFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := injectGoTags(goFile); err != nil {
+		t.Fatal(err)
+	}
+	res, _ := ioutil.ReadFile(goFile)
+	if !strings.Contains(string(res), "`protobuf:\"bytes,1,opt,name=id,proto3\" json:\"id\" bson:\"_id\"`") {
+		t.Errorf("unexpected tag:\n%s", res)
+	}
+	if !strings.Contains(string(res), "Name string `bson:\"name\"`") {
+		t.Errorf("unexpected tag:\n%s", res)
+	}
+
+	// 冲突与无主的 @gotags 带行号报错
+	src = "package pb\n\n// @gotags: bson:\"x\"\ntype User struct {\n" +
+		"\t// @gotags: bson:\"a\"\n" +
+		"\tId string // @gotags: bson:\"b\"\n" +
+		"}\n"
+	if err := ioutil.WriteFile(goFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	err = injectGoTags(goFile)
+	errs, ok := err.(InjectTagErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expect 2 errors, got %v", err)
+	}
+	if errs[0].Pos.Line != 6 || errs[1].Pos.Line != 3 {
+		t.Errorf("unexpected error position: %v", errs)
+	}
+}
@@ -1,22 +1,32 @@
 package proto_parser
 
 import (
+	"errors"
 	"fmt"
-	"github.com/emicklei/proto"
 	"regexp"
+	"sort"
 	"strings"
+
+	"github.com/emicklei/proto"
 )
 
 // injectGormModelTag 遍历 msg 的字段 取出 comment 进行tag注入
-func injectGormModelTag(msg *proto.Message) {
+func (g *Generator) injectGormModelTag(msg *proto.Message) {
 	for _, m := range msg.Elements {
 		if field, ok := m.(*proto.NormalField); ok {
 			if field.Comment == nil {
 				field.Comment = new(proto.Comment)
 			}
-			field.Comment.Lines = injectGormTag(field, field.Name, field.Comment.Lines)
+			field.Comment.Lines = g.injectGormTag(field, field.Name, field.Comment.Lines)
 
 			if !isBuiltInType(field.Type) {
+				// 带包名的类型 只处理定义在当前文件的 message
+				if strings.Contains(field.Type, ".") {
+					if pm, local := g.lookupImportMessage(field.Type); pm != nil && local {
+						g.injectGormModelTag(pm)
+					}
+					continue
+				}
 				// 非内建类型 找上级msg 看是否在allMsg中 不在 则直接找 全局m.Name
 				var trackList []string
 				var nowPath []string
@@ -29,80 +39,245 @@ func injectGormModelTag(msg *proto.Message) {
 				var prefix string
 				for i := len(trackList) - 1; i >= 0; i-- {
 					prefix = trackList[i] + "_" + field.Type
-					if pm, exist := Visitor.AllMsgMap[prefix]; exist {
-						injectGormModelTag(pm)
+					if pm, exist := g.Visitor.AllMsgMap[prefix]; exist {
+						g.injectGormModelTag(pm)
 						break
 					}
 					continue
 				}
 
-				if pm, exist := Visitor.AllMsgMap[field.Type]; exist {
-					injectGormModelTag(pm)
+				if pm, exist := g.Visitor.AllMsgMap[field.Type]; exist {
+					g.injectGormModelTag(pm)
 					continue
 				}
 			}
 		}
 
 		if field, ok := m.(*proto.Message); ok {
-			injectGormModelTag(field)
+			g.injectGormModelTag(field)
 		}
 	}
 }
 
 // injectGormTag 注入gorm tag fieldName 用来生成 column标记
-func injectGormTag(field *proto.NormalField, fieldName string, doc []string) []string {
+// @gorm: 中声明的项与推导的 column type 合并 不认识或重复的项报错 其余项照常输出
+func (g *Generator) injectGormTag(field *proto.NormalField, fieldName string, doc []string) []string {
 	fieldName = toTitle(fieldName)
-	gener := getOuterForefathersNameArr(field.Parent.(*proto.Message))
-	gener = append(gener, fieldName)
-	var newGender []string
-	for _, node := range gener {
-		newGender = append(newGender, case2Camel(node))
-	}
-	prefix := strings.Join(newGender, "_")
-	var result []string
-
-	if len(doc) == 0 {
-		var val = GormTagGenerate(fieldName, "", field.Type)
-		result = append(result, fmt.Sprintf("@gotags: gorm:\"%s\"", val))
-		Visitor.AddBsonTag(prefix, val)
-		addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, val, trim(getInlineComment(field)))
-		return result
+	prefix := bsonFieldPrefix(field, fieldName)
+	var addField = func(column string) {
+		g.Visitor.AddBsonTag(prefix, column)
+		g.addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, column, trim(getInlineComment(field)))
 	}
+	var typ = g.Visitor.scalarType(field.Type)
 
-	var isInject bool    //是否已经注入
-	var injectDoc string // 被注入的文档内容
-	for _, t := range doc {
+	var result []string
+	var isInject bool //是否已经注入
+	var gormReg = regexp.MustCompile(RegexpGorm)
+	for i, line := range doc {
 		if !isInject {
-			var bsonReg = regexp.MustCompile(RegexpGorm)
-			res := bsonReg.FindAllStringSubmatch(t, -1)
-			if len(res) >= 1 {
-				injectDoc = t
-				if strings.ToLower(res[0][2]) == "ignore" {
-					Visitor.AddBsonTag(prefix, field.Name)
-					addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, field.Name, trim(getInlineComment(field)))
+			if res := gormReg.FindStringSubmatch(line); len(res) == 2 {
+				if strings.ToLower(trim(res[1])) == "ignore" {
+					addField(field.Name)
 					return doc
 				}
-				t = strings.Replace(t, "@gorm", "@gotags", 1)
-				t = strings.Replace(t, res[0][2], fmt.Sprintf("gorm:\"%s\"", res[0][2]), 1)
-				Visitor.AddBsonTag(prefix, res[0][2])
-				addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, res[0][2], trim(getInlineComment(field)))
-				// 匹配上了 只替换第一个bson 多个bson配置取最开始一个
-				result = append(result, t)
+				tag, err := parseGormTag(res[1])
+				if err != nil {
+					g.errorf(commentLinePos(field.Comment, i), "gorm %s: %v", field.Name, err)
+				}
+				tag.infer(fieldName, typ)
+				// 匹配上了 只替换第一个 多个gorm配置取最开始一个
+				line = strings.Replace(line, res[0], fmt.Sprintf("@gotags: gorm:\"%s\"", tag), 1)
+				addField(tag.column(field.Name))
 				isInject = true
-				continue
 			}
 		}
-		result = append(result, t)
+		result = append(result, line)
 	}
 
-	// 默认注入小驼峰 但是需要注意 Id/ID 等价
+	// 没有声明时注入推导的 column type
 	if !isInject {
-		var val = GormTagGenerate(fieldName, injectDoc, field.Type)
-		result = append(result, fmt.Sprintf("@gotags: gorm:\"%s\"", val))
-		//bsonTagFieldMap[prefix] = val
-		Visitor.AddBsonTag(prefix, val)
-		addBsonFieldToMap(field.Parent.(*proto.Message), getModelName(field.Parent.(*proto.Message)), fieldName, prefix, val, trim(getInlineComment(field)))
+		var tag = new(gormTag)
+		tag.infer(fieldName, typ)
+		result = append(result, fmt.Sprintf("@gotags: gorm:\"%s\"", tag))
+		addField(tag.column(field.Name))
 	}
 
 	return result
 }
+
+// gormTagKeys gorm 支持的 tag 项 按此顺序输出
+var gormTagKeys = []string{
+	"column", "type", "size", "precision", "scale",
+	"primaryKey", "autoIncrement", "autoIncrementIncrement",
+	"unique", "uniqueIndex", "index", "not null", "default", "check", "comment", "serializer",
+	"embedded", "embeddedPrefix", "autoCreateTime", "autoUpdateTime",
+	"foreignKey", "references", "polymorphic", "polymorphicValue",
+	"many2many", "joinForeignKey", "joinReferences", "constraint",
+	"<-", "->", "-",
+}
+
+// gormTagAliases tag 项的其他写法
+var gormTagAliases = map[string]string{
+	"notnull": "not null",
+}
+
+// gormRepeatableKeys 可以重复声明的 tag 项 如字段加入多个索引
+var gormRepeatableKeys = map[string]bool{
+	"index":       true,
+	"uniqueIndex": true,
+}
+
+// gormTagItem 一个 gorm tag 项
+type gormTagItem struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// gormTag 字段的 gorm tag
+type gormTag struct {
+	items []gormTagItem
+}
+
+// parseGormTag 解析 @gorm: 声明的 tag key 不区分大小写
+// 不认识或重复的项跳过并返回错误 其余合法的项照常返回
+func parseGormTag(tag string) (*gormTag, error) {
+	var t = new(gormTag)
+	var seen = make(map[string]bool)
+	var errs []string
+	for _, part := range strings.Split(tag, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var item gormTagItem
+		var key = part
+		if idx := strings.Index(part, ":"); idx >= 0 {
+			key, item.value, item.hasValue = strings.TrimSpace(part[:idx]), strings.TrimSpace(part[idx+1:]), true
+		}
+		item.key = gormTagKey(key)
+		if item.key == "" {
+			errs = append(errs, fmt.Sprintf("不支持的 gorm tag %q", key))
+			continue
+		}
+		if seen[item.key] && !gormRepeatableKeys[item.key] {
+			errs = append(errs, fmt.Sprintf("重复的 gorm tag %q", key))
+			continue
+		}
+		seen[item.key] = true
+		t.items = append(t.items, item)
+	}
+	if len(errs) != 0 {
+		return t, errors.New(strings.Join(errs, "; "))
+	}
+	return t, nil
+}
+
+// gormTagKey tag 项对应的标准写法 不认识的返回空
+func gormTagKey(key string) string {
+	key = strings.ToLower(strings.Join(strings.Fields(key), " "))
+	if v, ok := gormTagAliases[key]; ok {
+		return v
+	}
+	for _, k := range gormTagKeys {
+		if strings.ToLower(k) == key {
+			return k
+		}
+	}
+	return ""
+}
+
+// gormDataType proto 类型对应的 gorm 通用类型 由 gorm 按不同数据库转换
+func gormDataType(typ string) string {
+	switch typ {
+	case "string":
+		return "string"
+	case "float", "double":
+		return "float"
+	case "bool":
+		return "bool"
+	case "uint32", "uint64", "fixed32", "fixed64":
+		return "uint"
+	case "int32", "int64", "sint32", "sint64", "sfixed32", "sfixed64":
+		return "int"
+	case "bytes":
+		return "bytes"
+	}
+	return ""
+}
+
+// has 是否声明了 key
+func (t *gormTag) has(key string) bool {
+	for _, item := range t.items {
+		if item.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// add 添加一个 tag 项
+func (t *gormTag) add(key, value string) {
+	t.items = append(t.items, gormTagItem{key: key, value: value, hasValue: value != ""})
+}
+
+// infer 补全没有声明的 column type 以及 id 字段的主键 自增只用于整数主键
+func (t *gormTag) infer(field, typ string) {
+	if t.has("-") {
+		return
+	}
+	if !t.has("column") {
+		t.add("column", calm2Case(field))
+	}
+	dataType := gormDataType(typ)
+	if !t.has("type") && !t.has("serializer") && dataType != "" {
+		t.add("type", dataType)
+	}
+	if field == "Id" || field == "ID" || field == "id" {
+		if !t.has("primaryKey") {
+			t.add("primaryKey", "")
+		}
+		if !t.has("autoIncrement") && (dataType == "int" || dataType == "uint") {
+			t.add("autoIncrement", "")
+		}
+	}
+}
+
+// value key 声明的值 可重复的 key 返回第一个
+func (t *gormTag) value(key string) string {
+	for _, item := range t.items {
+		if item.key == key {
+			return item.value
+		}
+	}
+	return ""
+}
+
+// column 字段对应的列名 忽略的字段返回 def
+func (t *gormTag) column(def string) string {
+	if v := t.value("column"); v != "" {
+		return v
+	}
+	return def
+}
+
+// String 按 gormTagKeys 的顺序输出 同一个 key 保持声明的顺序
+func (t *gormTag) String() string {
+	var order = make(map[string]int, len(gormTagKeys))
+	for i, k := range gormTagKeys {
+		order[k] = i
+	}
+	var items = append([]gormTagItem(nil), t.items...)
+	sort.SliceStable(items, func(i, j int) bool {
+		return order[items[i].key] < order[items[j].key]
+	})
+	var res []string
+	for _, item := range items {
+		if item.hasValue {
+			res = append(res, item.key+":"+item.value)
+			continue
+		}
+		res = append(res, item.key)
+	}
+	return strings.Join(res, ";")
+}
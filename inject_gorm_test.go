@@ -0,0 +1,99 @@
+package proto_parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emicklei/proto"
+)
+
+func TestInjectGormModelTag(t *testing.T) {
+	src := `syntax = "proto3";
+package user;
+
+message ModelUser {
+    // @gorm: primaryKey
+    int64 id = 1;
+    // @gorm: uniqueIndex:idx_name; size:64;NOT NULL; index:idx_a; index:idx_b
+    string user_name = 2;
+    // @gorm: Default:0;comment:年龄
+    int32 age = 3;
+    string avatar_url = 4;
+    // @gorm: ignore
+    string tmp = 5;
+    // @gorm: column:nick_name;foo:bar
+    string nick = 6;
+    // @gorm: size:32;size:64
+    string email = 7;
+    // @gorm: serializer:json
+    Profile profile = 8;
+}
+
+message Profile {
+    string bio = 1;
+}
+`
+	parser := proto.NewParser(strings.NewReader(src))
+	parser.Filename("test.proto")
+	definition, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGenerator()
+	g.Visitor.dbDriver = "gdbc"
+	proto.Walk(definition, proto.WithMessage(g.loadMessage))
+	msg := g.Visitor.ModelMsgMap["ModelUser"]
+	if msg == nil {
+		t.Fatal("model not found")
+	}
+	g.injectModelTag(msg)
+
+	var want = map[string]string{
+		"id":         `@gotags: gorm:"column:id;type:int;primaryKey;autoIncrement"`,
+		"user_name":  `@gotags: gorm:"column:user_name;type:string;size:64;uniqueIndex:idx_name;index:idx_a;index:idx_b;not null"`,
+		"age":        `@gotags: gorm:"column:age;type:int;default:0;comment:年龄"`,
+		"avatar_url": `@gotags: gorm:"column:avatar_url;type:string"`,
+		"tmp":        `@gorm: ignore`,
+		"nick":       `@gotags: gorm:"column:nick_name;type:string"`,
+		"email":      `@gotags: gorm:"column:email;type:string;size:32"`,
+		"profile":    `@gotags: gorm:"column:profile;serializer:json"`,
+	}
+	for _, e := range msg.Elements {
+		field, ok := e.(*proto.NormalField)
+		if !ok {
+			continue
+		}
+		if got := strings.TrimSpace(strings.Join(field.Comment.Lines, "")); got != want[field.Name] {
+			t.Errorf("field %s: got %s, want %s", field.Name, got, want[field.Name])
+		}
+	}
+	if got := g.Visitor.ModelFieldStructMap["ModelUser"]["UserName"].DbFieldName; got != "user_name" {
+		t.Errorf("unexpected db field name %q", got)
+	}
+
+	// 不认识与重复的项带行号报错 只报告有问题的项
+	diags := g.Diagnostics()
+	if len(diags) != 2 || diags[0].Pos.Line != 14 || diags[1].Pos.Line != 16 {
+		t.Fatalf("expect 2 errors, got:\n%v", diags)
+	}
+	if !strings.Contains(diags[0].Msg, `"foo"`) || strings.Contains(diags[0].Msg, "column") {
+		t.Errorf("unexpected error: %s", diags[0].Msg)
+	}
+}
+
+func TestGormTagGenerate(t *testing.T) {
+	for _, c := range []struct {
+		field, tag, typ, want string
+	}{
+		{"Id", "", "string", "column:id;type:string;primaryKey"},
+		{"Name", "", "string", "column:name;type:string"},
+		{"Name", "type:varchar(32);column:nick", "string", "column:nick;type:varchar(32)"},
+		{"Name", "column:nick_name;foo:bar", "string", "column:nick_name;type:string"},
+		{"Score", "-", "double", "-"},
+		{"Score", "->;<-:create", "double", "column:score;type:float;<-:create;->"},
+	} {
+		if got := GormTagGenerate(c.field, c.tag, c.typ); got != c.want {
+			t.Errorf("GormTagGenerate(%q, %q, %q) = %q, want %q", c.field, c.tag, c.typ, got, c.want)
+		}
+	}
+}
@@ -287,3 +287,74 @@ INJECT:
 	res = append(res, fmt.Sprintf("@gotags: json:\"%s\"", calm2KebabCaseBSON(fieldName)))
 	return res
 }
+
+// msgJsonStyle message 自身声明的 @json_style 未声明时为 raw
+func msgJsonStyle(msg *proto.Message) Style {
+	if msg.Comment == nil {
+		return raw
+	}
+	regStyle := regexp.MustCompile(RegexpJsonStyle)
+	for _, line := range msg.Comment.Lines {
+		res := regStyle.FindAllStringSubmatch(line, -1)
+		if len(res) == 0 || len(res[0]) != 2 {
+			continue
+		}
+		switch res[0][1] {
+		case underscoreVal:
+			return underscore
+		case lowerCamelVal:
+			return lowerCamel
+		case upperCamelVal:
+			return upperCamel
+		case kebabCaseVal:
+			return kebabCase
+		default:
+			return raw
+		}
+	}
+	return raw
+}
+
+// effectiveJsonStyle 实际生效的 json 风格
+// 注入时由外向内处理 外层 message 的非 raw 风格会覆盖内层
+func effectiveJsonStyle(msg *proto.Message) Style {
+	var chain []*proto.Message
+	for m := msg; m != nil; {
+		chain = append([]*proto.Message{m}, chain...)
+		parent, ok := m.Parent.(*proto.Message)
+		if !ok {
+			break
+		}
+		m = parent
+	}
+	for _, m := range chain {
+		if style := msgJsonStyle(m); style != raw {
+			return style
+		}
+	}
+	return raw
+}
+
+// fieldJsonName 字段在生成代码中的 json 名 与注入的 json tag 保持一致
+func fieldJsonName(fieldName string, comment *proto.Comment, style Style) string {
+	if comment != nil {
+		reg := regexp.MustCompile(RegexpJson)
+		for _, line := range comment.Lines {
+			res := reg.FindAllStringSubmatch(line, -1)
+			if len(res) > 0 && len(res[0]) == 2 {
+				return res[0][1]
+			}
+		}
+	}
+	switch style {
+	case underscore:
+		return calm2Case(fieldName)
+	case lowerCamel:
+		return case2LowerCamel(fieldName)
+	case upperCamel:
+		return case2Camel(fieldName)
+	case kebabCase:
+		return calm2KebabCaseBSON(fieldName)
+	}
+	return fieldName
+}
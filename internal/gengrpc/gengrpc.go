@@ -0,0 +1,25 @@
+// Package gengrpc 进程内执行的 protoc-gen-go-grpc
+// grpc.go 来自 google.golang.org/grpc/cmd/protoc-gen-go-grpc v1.2.0 (Apache-2.0 见 LICENSE) 只修改了包名
+// 升级时整体替换 grpc.go 并同步 version
+package gengrpc
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+const version = "1.2.0"
+
+// requireUnimplemented 插件参数 require_unimplemented_servers 固定为默认值 true
+var requireUnimplemented = func() *bool {
+	v := true
+	return &v
+}()
+
+// SupportedFeatures 与 protoc-gen-go-grpc 声明的特性一致
+const SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+
+// GenerateFile 生成 file 的 _grpc.pb.go 没有 service 时返回 nil
+func GenerateFile(gen *protogen.Plugin, file *protogen.File) *protogen.GeneratedFile {
+	return generateFile(gen, file)
+}
@@ -0,0 +1,362 @@
+package proto_parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/scanner"
+
+	"github.com/emicklei/proto"
+	"github.com/sirupsen/logrus"
+)
+
+// lint 规则
+const (
+	LintRuleAnnotation    = "annotation"     // 注解格式错误 限频 索引 请求方法等
+	LintRuleRpcDoc        = "rpc-doc"        // 路由组中的 rpc 需要 @desc @author @method
+	LintRuleRpcMsgName    = "rpc-msg-name"   // 路由组 rpc 的请求响应结构体命名为 rpc 名加 Req/Resp
+	LintRuleModelID       = "model-id"       // Model 需要 id 字段
+	LintRuleIndexName     = "index-name"     // model 中同一个索引名只能用于一种索引类型
+	LintRuleRouteConflict = "route-conflict" // 同一个请求方法与路径只能对应一个 rpc
+)
+
+type lintRule struct {
+	ID       string
+	Desc     string
+	Severity Severity
+}
+
+// lintRules 所有规则 默认全部开启
+var lintRules = []lintRule{
+	{LintRuleAnnotation, "annotation must be well-formed", SeverityError},
+	{LintRuleRpcDoc, "rpc in @route_group service must have @desc, @author and @method", SeverityWarning},
+	{LintRuleRpcMsgName, "rpc request/response message must be named <Rpc>Req/<Rpc>Resp", SeverityWarning},
+	{LintRuleModelID, "Model message must have an id field", SeverityError},
+	{LintRuleIndexName, "index name must be used with one index kind in a model", SeverityError},
+	{LintRuleRouteConflict, "http method and path must be unique", SeverityError},
+}
+
+// LintIssue 一条 lint 结果
+type LintIssue struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Message  string `json:"message"`
+}
+
+// LintResult lint 结果 按文件与行号排序
+type LintResult []*LintIssue
+
+// HasError 是否有错误级别的结果
+func (r LintResult) HasError() bool {
+	for _, issue := range r {
+		if issue.Severity == SeverityError.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON 输出 json 格式
+func (r LintResult) JSON() ([]byte, error) {
+	if r == nil {
+		r = LintResult{}
+	}
+	return json.MarshalIndent(r, "", "  ")
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine,omitempty"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// SARIF 输出 SARIF 2.1.0 格式 可直接上传到代码扫描平台
+func (r LintResult) SARIF() ([]byte, error) {
+	var run = sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: "proto-parser"}},
+		Results: make([]sarifResult, 0, len(r)),
+	}
+	for _, rule := range lintRules {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+			ID:               rule.ID,
+			ShortDescription: sarifMessage{Text: rule.Desc},
+		})
+	}
+	for _, issue := range r {
+		run.Results = append(run.Results, sarifResult{
+			RuleID:  issue.Rule,
+			Level:   issue.Severity,
+			Message: sarifMessage{Text: issue.Message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: issue.File},
+					Region:           sarifRegion{StartLine: issue.Line, StartColumn: issue.Column},
+				},
+			}},
+		})
+	}
+	return json.MarshalIndent(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+}
+
+// resolveLintRules 计算开启的规则 不传时全部开启
+// 传入规则名只开启这些规则 规则名前加 ! 表示在默认规则中关闭该规则
+func resolveLintRules(rules []string) (map[string]Severity, error) {
+	var all = make(map[string]Severity, len(lintRules))
+	for _, rule := range lintRules {
+		all[rule.ID] = rule.Severity
+	}
+	if len(rules) == 0 {
+		return all, nil
+	}
+
+	var enabled = make(map[string]Severity)
+	var disabled = make(map[string]struct{})
+	for _, rule := range rules {
+		id := strings.TrimPrefix(rule, "!")
+		severity, ok := all[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown lint rule: %s", id)
+		}
+		if id != rule {
+			disabled[id] = struct{}{}
+			continue
+		}
+		enabled[id] = severity
+	}
+	if len(enabled) == 0 {
+		enabled = all
+	}
+	for id := range disabled {
+		delete(enabled, id)
+	}
+	return enabled, nil
+}
+
+// linter 一次 lint 的上下文
+type linter struct {
+	rules  map[string]Severity
+	issues LintResult
+	routes *RouteTable
+}
+
+func (l *linter) report(rule string, pos scanner.Position, format string, args ...interface{}) {
+	severity, ok := l.rules[rule]
+	if !ok {
+		return
+	}
+	l.issues = append(l.issues, &LintIssue{
+		Rule:     rule,
+		Severity: severity.String(),
+		File:     pos.Filename,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Lint 按项目的注解约定检查 proto 文件
+func (g *Generator) Lint(pbFiles []string, rules ...string) (LintResult, error) {
+	g.reset()
+
+	enabled, err := resolveLintRules(rules)
+	if err != nil {
+		logrus.Errorf("err: %+v", err)
+		return nil, err
+	}
+	var l = &linter{rules: enabled, routes: NewRouteTable()}
+
+	for _, pbFile := range pbFiles {
+		definition, err := openProtoFile(pbFile)
+		if err != nil {
+			return nil, err
+		}
+		g.Visitor = &ProtoVisitor{}
+		g.diags = nil
+		g.lintFile(l, definition)
+	}
+
+	sort.SliceStable(l.issues, func(i, j int) bool {
+		if l.issues[i].File != l.issues[j].File {
+			return l.issues[i].File < l.issues[j].File
+		}
+		return l.issues[i].Line < l.issues[j].Line
+	})
+	return l.issues, nil
+}
+
+var lintIndexReg = regexp.MustCompile(`@(index|unique_index|ttl_index):\s*(\w+)`)
+
+func (g *Generator) lintFile(l *linter, definition *proto.Proto) {
+	var services []*proto.Service
+	var models []*proto.Message
+	proto.Walk(definition,
+		proto.WithPackage(g.loadPackage),
+		proto.WithMessage(g.collectIndex),
+		proto.WithService(g.injectFreqMap),
+		proto.WithService(g.parseSrvGenRouter),
+		proto.WithService(func(srv *proto.Service) {
+			services = append(services, srv)
+		}),
+		proto.WithMessage(func(m *proto.Message) {
+			if strings.HasPrefix(m.Name, NameModel) {
+				models = append(models, m)
+			}
+		}),
+	)
+
+	// 注解格式
+	for _, d := range g.diags {
+		l.report(LintRuleAnnotation, d.Pos, "%s", d.Msg)
+	}
+
+	// model 字段与索引
+	for _, m := range models {
+		// 索引按 model 收集 不同 model 可以使用相同的索引名
+		var indexes = make(map[string]string)
+		var hasID bool
+		for _, elem := range m.Elements {
+			field, ok := elem.(*proto.NormalField)
+			if !ok {
+				continue
+			}
+			if field.Name == "id" {
+				hasID = true
+			}
+			if field.Comment == nil {
+				continue
+			}
+			for i, line := range field.Comment.Lines {
+				res := lintIndexReg.FindStringSubmatch(line)
+				if len(res) != 3 {
+					continue
+				}
+				kind, ok := indexes[res[2]]
+				if !ok {
+					indexes[res[2]] = res[1]
+					continue
+				}
+				if kind != res[1] {
+					l.report(LintRuleIndexName, commentLinePos(field.Comment, i), "index %s is declared as both @%s and @%s", res[2], kind, res[1])
+				}
+			}
+		}
+		if !hasID {
+			l.report(LintRuleModelID, m.Position, "model %s has no id field", m.Name)
+		}
+	}
+
+	// 请求响应命名与文档只检查路由组
+	for _, srv := range services {
+		group, ok := g.Visitor.GroupRouterMap[srv.Name]
+		if !ok {
+			continue
+		}
+		for _, elem := range srv.Elements {
+			rpc, ok := elem.(*proto.RPC)
+			if !ok {
+				continue
+			}
+			if !lintMsgName(rpc.RequestType, rpc.Name+"Req") {
+				l.report(LintRuleRpcMsgName, rpc.Position, "rpc %s request should be %sReq, got %s", rpc.Name, rpc.Name, rpc.RequestType)
+			}
+			if !lintMsgName(rpc.ReturnsType, rpc.Name+"Resp") {
+				l.report(LintRuleRpcMsgName, rpc.Position, "rpc %s response should be %sResp, got %s", rpc.Name, rpc.Name, rpc.ReturnsType)
+			}
+		}
+
+		for _, node := range group.Apis {
+			if node.rpc == nil {
+				continue
+			}
+			for _, tag := range []string{"@desc:", "@author:", "@method:"} {
+				if !lintHasTag(node.rpc.Comment, tag) {
+					l.report(LintRuleRpcDoc, node.rpc.Position, "rpc %s.%s missing %s", srv.Name, node.FuncName, strings.TrimSuffix(tag, ":"))
+				}
+			}
+		}
+	}
+
+	// 路由冲突 跨文件检查
+	for _, route := range g.routeEntries() {
+		if d := l.routes.Add(route); d != nil {
+			l.report(LintRuleRouteConflict, d.Pos, "%s", d.Msg)
+		}
+	}
+}
+
+// lintMsgName 比较 message 名 忽略包名
+func lintMsgName(typ, want string) bool {
+	if idx := strings.LastIndex(typ, "."); idx >= 0 {
+		typ = typ[idx+1:]
+	}
+	return typ == want
+}
+
+func lintHasTag(c *proto.Comment, tag string) bool {
+	if c == nil {
+		return false
+	}
+	for _, line := range c.Lines {
+		if strings.Contains(line, tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package proto_parser
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pbFile := filepath.Join(dir, "user.proto")
+	src := `syntax = "proto3";
+package user;
+
+message ModelUser {
+    // @index: user_name asc
+    string name = 1;
+}
+
+// @route_group: true
+service UserAPI {
+    // @desc: get
+    // @author: a
+    // @method: GET
+    rpc Get (GetReq) returns (GetResp);
+    // @api: get
+    // @method: GET
+    rpc Fetch (FetchReq) returns (GetResp);
+}
+`
+	if err := ioutil.WriteFile(pbFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Lint([]string{pbFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count = make(map[string]int)
+	for _, issue := range res {
+		count[issue.Rule]++
+	}
+	// Fetch 缺少 @desc @author; 响应命名不对; 路由与 Get 冲突; Model 缺少 id
+	if count[LintRuleRpcDoc] != 2 || count[LintRuleRpcMsgName] != 1 ||
+		count[LintRuleRouteConflict] != 1 || count[LintRuleModelID] != 1 {
+		t.Errorf("unexpected issues: %+v", count)
+	}
+
+	res, err = Lint([]string{pbFile}, "!"+LintRuleRpcDoc, "!"+LintRuleModelID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, issue := range res {
+		if issue.Rule == LintRuleRpcDoc || issue.Rule == LintRuleModelID {
+			t.Errorf("rule %s should be disabled", issue.Rule)
+		}
+	}
+
+	data, err := res.SARIF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sarif map[string]interface{}
+	if err := json.Unmarshal(data, &sarif); err != nil || sarif["version"] != "2.1.0" {
+		t.Errorf("bad sarif: %s", data)
+	}
+
+	if _, err := Lint([]string{pbFile}, "no-such-rule"); err == nil {
+		t.Errorf("expect unknown rule error")
+	}
+}
+
+func TestLintServiceWithoutComment(t *testing.T) {
+	dir := t.TempDir()
+	pbFile := filepath.Join(dir, "order.proto")
+	src := `syntax = "proto3";
+package order;
+
+service OrderAPI {
+    rpc Get (GetReq) returns (GetResp);
+    rpc Pay (PayRequest) returns (PayResp);
+}
+
+service OrderInner {
+    rpc Sync (SyncRequest) returns (SyncReply);
+}
+`
+	if err := ioutil.WriteFile(pbFile, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有注释的 XxxAPI 也是路由组 普通 grpc service 不检查请求响应命名
+	res, err := Lint([]string{pbFile}, LintRuleRpcMsgName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Line != 6 {
+		t.Errorf("unexpected issues: %+v", res)
+	}
+}
@@ -0,0 +1,160 @@
+package proto_parser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/actorbuf/proto-parser/internal/gengrpc"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	log "github.com/sirupsen/logrus"
+	gengo "google.golang.org/protobuf/cmd/protoc-gen-go/internal_gengo"
+	"google.golang.org/protobuf/compiler/protogen"
+	pbproto "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// nativeCompile 不依赖 protoc 使用 protoparse 编译 proto
+// PATH 中有 protoc-gen-go 时优先调用 否则与 protoc-gen-go-grpc 一样在进程内执行 不需要安装任何插件
+func nativeCompile(config *CodeGenConfig, importPaths []string, fileName, outputPath string, out io.Writer) error {
+	p := protoparse.Parser{
+		ImportPaths:           importPaths,
+		IncludeSourceCodeInfo: true,
+	}
+	fds, err := p.ParseFiles(fileName)
+	if err != nil {
+		log.Errorf("err: %+v", err)
+		return err
+	}
+
+	req := newCodeGeneratorRequest(fds)
+
+	_, _ = fmt.Fprintf(out, "native compile: %s --go_out=%s\n", fileName, outputPath)
+	resp, err := runGenGo(req)
+	if err != nil {
+		log.Errorf("err: %+v", err)
+		return err
+	}
+	if err := writeCodeGeneratorResponse(outputPath, resp); err != nil {
+		return fmt.Errorf("protoc-gen-go: %w", err)
+	}
+
+	// grpc 开启
+	if config.GrpcOutputPath != "" {
+		_, _ = fmt.Fprintf(out, "native compile: %s --go-grpc_out=%s\n", fileName, config.GrpcOutputPath)
+		resp, err := runGenGRPC(req)
+		if err != nil {
+			log.Errorf("err: %+v", err)
+			return err
+		}
+		if err := writeCodeGeneratorResponse(config.GrpcOutputPath, resp); err != nil {
+			return fmt.Errorf("protoc-gen-go-grpc: %w", err)
+		}
+	}
+	return nil
+}
+
+// newCodeGeneratorRequest 构造插件请求 依赖的文件按 import 顺序排在前面
+func newCodeGeneratorRequest(fds []*desc.FileDescriptor) *pluginpb.CodeGeneratorRequest {
+	var req = &pluginpb.CodeGeneratorRequest{}
+	var seen = make(map[string]struct{})
+	var add func(fd *desc.FileDescriptor)
+	add = func(fd *desc.FileDescriptor) {
+		if _, ok := seen[fd.GetName()]; ok {
+			return
+		}
+		seen[fd.GetName()] = struct{}{}
+		for _, dep := range fd.GetDependencies() {
+			add(dep)
+		}
+		req.ProtoFile = append(req.ProtoFile, fd.AsFileDescriptorProto())
+	}
+	for _, fd := range fds {
+		add(fd)
+		req.FileToGenerate = append(req.FileToGenerate, fd.GetName())
+	}
+	return req
+}
+
+// runGenGo 执行 protoc-gen-go PATH 中有安装时调用插件 生成结果与本机 protoc 一致
+// 没有安装时进程内执行 internal_gengo 不是 protobuf 的公开 API go.mod 中固定了 google.golang.org/protobuf 的版本 升级时需要验证生成结果
+func runGenGo(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	if pluginPath, err := exec.LookPath("protoc-gen-go"); err == nil {
+		return runPlugin(pluginPath, req)
+	}
+	plugin, err := protogen.Options{}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range plugin.Files {
+		if f.Generate {
+			gengo.GenerateFile(plugin, f)
+		}
+	}
+	plugin.SupportedFeatures = gengo.SupportedFeatures
+	return plugin.Response(), nil
+}
+
+// runPlugin 按 protoc 的插件协议调用外部插件 请求从 stdin 写入 响应从 stdout 读取
+func runPlugin(pluginPath string, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	in, err := pbproto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(pluginPath)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", pluginPath, err, stderr.String())
+	}
+	var resp = &pluginpb.CodeGeneratorResponse{}
+	if err := pbproto.Unmarshal(stdout.Bytes(), resp); err != nil {
+		return nil, fmt.Errorf("%s: %w", pluginPath, err)
+	}
+	return resp, nil
+}
+
+// runGenGRPC 进程内执行 protoc-gen-go-grpc 见 internal/gengrpc
+func runGenGRPC(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	plugin, err := protogen.Options{}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range plugin.Files {
+		if f.Generate {
+			gengrpc.GenerateFile(plugin, f)
+		}
+	}
+	plugin.SupportedFeatures = gengrpc.SupportedFeatures
+	return plugin.Response(), nil
+}
+
+// writeCodeGeneratorResponse 将插件输出的文件写入输出目录
+func writeCodeGeneratorResponse(outputPath string, resp *pluginpb.CodeGeneratorResponse) error {
+	if resp.Error != nil {
+		return fmt.Errorf("%s", resp.GetError())
+	}
+	for _, f := range resp.File {
+		if f.GetInsertionPoint() != "" {
+			log.Warnf("insertion point %s not supported, skip %s", f.GetInsertionPoint(), f.GetName())
+			continue
+		}
+		p := filepath.Join(outputPath, f.GetName())
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			log.Errorf("err: %+v", err)
+			return err
+		}
+		if err := ioutil.WriteFile(p, []byte(f.GetContent()), 0666); err != nil {
+			log.Errorf("err: %+v", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package proto_parser
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNativeCompile(t *testing.T) {
+	dir := t.TempDir()
+	src := `syntax = "proto3";
+package svc;
+option go_package = "./;svc";
+
+import "google/protobuf/timestamp.proto";
+
+message ModelUser {
+    // @gotags: json:"nick"
+    string name = 1;
+    google.protobuf.Timestamp created_at = 2;
+}
+
+message GetUserReq {
+    int64 id = 1;
+}
+
+service User {
+    rpc GetUser (GetUserReq) returns (ModelUser);
+}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "user.proto"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	grpcDir := filepath.Join(dir, "grpc")
+	err := NewGenerator().CodeGen(&CodeGenConfig{
+		PbFilePath:     filepath.Join(dir, "user.proto"),
+		IncludePbFiles: []string{dir},
+		GrpcOutputPath: grpcDir,
+		NativeCompile:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pb, err := ioutil.ReadFile(filepath.Join(dir, "user.pb.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"package svc",
+		"type ModelUser struct",
+		"timestamppb.Timestamp",
+		`json:"nick"`,
+		"func (x *GetUserReq) GetId() int64",
+	} {
+		if !strings.Contains(string(pb), want) {
+			t.Errorf("user.pb.go missing %q", want)
+		}
+	}
+
+	// grpc 在进程内生成 不依赖 PATH 中的 protoc-gen-go-grpc
+	grpc, err := ioutil.ReadFile(filepath.Join(grpcDir, "user_grpc.pb.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"func RegisterUserServer(", "type UnimplementedUserServer struct", "mustEmbedUnimplementedUserServer"} {
+		if !strings.Contains(string(grpc), want) {
+			t.Errorf("user_grpc.pb.go missing %q", want)
+		}
+	}
+}
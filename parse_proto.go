@@ -25,6 +25,7 @@ func openProtoFile(pbFile string) (*proto.Proto, error) {
 	defer reader.Close()
 
 	parser := proto.NewParser(reader)
+	parser.Filename(pbFile)
 	definition, err := parser.Parse()
 	if err != nil {
 		log.Errorf("parse pb file err: %+v", err)
@@ -34,84 +35,193 @@ func openProtoFile(pbFile string) (*proto.Proto, error) {
 }
 
 // ParseProto 传入proto文件 返回中间proto文件和错误信息
-func ParseProto(pbFile string) (midFile string, err error) {
+// 中间文件写在源文件同目录 文件名去掉 origin_ 前缀 CodeGen 使用临时目录 不经过这里
+func (g *Generator) ParseProto(pbFile string) (midFile string, err error) {
+	if g.Visitor == nil {
+		g.Visitor = &ProtoVisitor{}
+	}
+
+	baseName := strings.ReplaceAll(path.Base(pbFile), "origin_", "")
+	midFile = fmt.Sprintf("%s/%s", path.Dir(pbFile), baseName)
+	if err := g.parseProtoTo(pbFile, midFile); err != nil {
+		return "", err
+	}
+	return midFile, nil
+}
+
+// loadProto 解析 proto 并注入 tag 收集注解诊断 不写任何文件
+func (g *Generator) loadProto(pbFile string) (*proto.Proto, error) {
 	definition, err := openProtoFile(pbFile)
 	if err != nil {
-		return midFile, err
+		return nil, err
+	}
+
+	// 当前文件注册到符号表 并递归加载所有 import
+	if err := g.Visitor.GetSymbols().AddFile(pbFile, definition); err != nil {
+		log.Errorf("err: %+v", err)
 	}
 
 	// 第一轮 数据初始化
 	proto.Walk(definition,
-		proto.WithImport(loadImportPackage),
-		proto.WithPackage(loadPackage),
-		proto.WithMessage(loadMessage),
-		proto.WithService(injectFreqMap),
-		proto.WithEnum(loadErrCodeEnum),
+		proto.WithImport(g.loadImportPackage),
+		proto.WithPackage(g.loadPackage),
+		proto.WithMessage(g.loadMessage),
+		proto.WithService(g.injectFreqMap),
+		proto.WithEnum(g.loadErrCodeEnum),
 	)
 
-	for _, message := range Visitor.ModelMsgMap {
-		// 基于不同的数据库驱动 生成不同的代码
-		switch Visitor.dbDriver {
-		case "gdbc":
-			injectGormModelTag(message)
-		default:
-			// 默认 mongodb 贴合一下小黑屋的技术栈
-			injectMongoModelTag(message)
-		}
+	for _, message := range g.Visitor.ModelMsgMap {
+		g.injectModelTag(message)
 		// 生成表
-		genModelTableName(message)
+		g.genModelTableName(message)
 	}
 
 	// 注册非model的message 索引收集
 	proto.Walk(definition,
-		proto.WithMessage(injectBsonMessage),
-		proto.WithMessage(collectIndex),
+		proto.WithMessage(g.injectBsonMessage),
+		proto.WithMessage(g.collectIndex),
 	)
 	// 合并tag
 	proto.Walk(definition, proto.WithMessage(injectTagMessage))
 
-	baseName := strings.ReplaceAll(path.Base(pbFile), "origin_", "")
-	dirName := path.Dir(pbFile)
-	midFile = fmt.Sprintf("%s/%s", dirName, baseName)
+	return definition, nil
+}
+
+// checkProto 按生成的流程检查 proto 的注解 路由与错误码 不写任何文件
+// CodeGen 在写入之前对所有文件执行一次 有错误时不改动任何输出
+func (g *Generator) checkProto(pbFile string) error {
+	if _, err := g.loadProto(pbFile); err != nil {
+		return err
+	}
+
+	definition, err := openProtoFile(pbFile)
+	if err != nil {
+		return err
+	}
+	g.pbFilePath = pbFile
+	proto.Walk(definition,
+		proto.WithService(g.parseSrvGenRouter),
+		proto.WithService(g.parseSrvGenTask),
+	)
+	return nil
+}
+
+// parseProtoTo 解析 proto 生成代码 注入后的 proto 写入 midFile
+func (g *Generator) parseProtoTo(pbFile, midFile string) error {
+	definition, err := g.loadProto(pbFile)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(midFile), os.ModePerm); err != nil {
+		log.Errorf("err: %+v", err)
+		return err
+	}
 	if err := parserFormatWrite(midFile, definition); err != nil {
 		log.Errorf("parse pb file err: %+v", err)
-		return midFile, err
+		return err
 	}
 
-	if err := GenModelCode(Visitor.PackageName, midFile); err != nil {
+	if err := g.GenModelCode(g.Visitor.PackageName, pbFile); err != nil {
 		log.Errorf("err: %+v", err)
 	}
 
-	if err := parseProtoRouter(pbFile); err != nil {
+	if err := g.parseProtoRouter(pbFile); err != nil {
 		log.Errorf("err: %+v", err)
 	}
 
-	return
+	return nil
+}
+
+// coreIndexMap ModelTpl 中输出为 core.IndexInfo 的索引
+// core.IndexInfo 只能表达升降序的普通 唯一与 ttl 索引 text 2dsphere hashed 索引以及 sparse partial collation 选项无法表达
+// 这些索引不输出 避免被当作普通升序索引创建 完整的定义由 OutputMongo 输出
+func coreIndexMap(indexMap map[string]map[string]*IndexInfo) map[string]map[string]*IndexInfo {
+	var res = make(map[string]map[string]*IndexInfo)
+	for modelName, indexes := range indexMap {
+		for name, info := range indexes {
+			if !info.coreCompatible() {
+				continue
+			}
+			if res[modelName] == nil {
+				res[modelName] = make(map[string]*IndexInfo)
+			}
+			res[modelName][name] = info
+		}
+	}
+	return res
+}
+
+// indexAlias 只有一个 model 声明的索引名 => model 用于生成兼容旧版本的 IndexName_ 变量
+func indexAlias(indexMap map[string]map[string]*IndexInfo) map[string]string {
+	var res = make(map[string]string)
+	var dup = make(map[string]bool)
+	for modelName, indexes := range indexMap {
+		for name := range indexes {
+			if _, ok := res[name]; ok {
+				dup[name] = true
+			}
+			res[name] = modelName
+		}
+	}
+	for name := range dup {
+		delete(res, name)
+	}
+	return res
+}
+
+// injectModelTag 基于不同的数据库驱动 注入 model 字段的 tag
+func (g *Generator) injectModelTag(message *proto.Message) {
+	switch g.Visitor.dbDriver {
+	case "gdbc":
+		g.injectGormModelTag(message)
+	default:
+		// 默认 mongodb 贴合一下小黑屋的技术栈
+		g.injectMongoModelTag(message)
+	}
 }
 
 // parseProtoRouter 解析proto路由相关
-func parseProtoRouter(pbFile string) error {
+func (g *Generator) parseProtoRouter(pbFile string) error {
 	definition, err := openProtoFile(pbFile)
 	if err != nil {
 		return err
 	}
 
-	PbFilePath = pbFile
+	g.pbFilePath = pbFile
 
 	proto.Walk(definition,
-		proto.WithImport(loadImportPackage),
-		proto.WithPackage(loadPackage),
-		proto.WithService(loadService),
-		proto.WithEnum(loadErrCodeEnum),
+		proto.WithImport(g.loadImportPackage),
+		proto.WithPackage(g.loadPackage),
+		proto.WithService(g.loadService),
+		proto.WithEnum(g.loadErrCodeEnum),
 	)
 
+	// 生成task相关
+	if len(g.Visitor.Tasks) != 0 {
+		if err := g.TaskCodeGenTo(); err != nil {
+			log.Errorf("err: %+v", err)
+		}
+	}
+
 	// 注册路由组
-	if err := genGroupRouterTemplate(pbFile); err != nil {
+	if err := g.genGroupRouterTemplate(pbFile); err != nil {
 		log.Errorf("err: %+v", err)
 	}
 
-	// 注册rpc的错误码
-	proto.Walk(definition, proto.WithService(checkRouterErrorCode))
+	// 注册rpc的错误码 开启 RewriteErrorCode 且 @error 有变化时才改写源文件
+	if !g.rewriteErrCode {
+		return nil
+	}
+	var changed bool
+	proto.Walk(definition, proto.WithService(func(srv *proto.Service) {
+		if g.checkRouterErrorCode(srv) {
+			changed = true
+		}
+	}))
+	if !changed {
+		return nil
+	}
 
 	if err := parserFormatWrite(pbFile, definition); err != nil {
 		log.Errorf("parse pb file err: %+v", err)
@@ -121,31 +231,47 @@ func parseProtoRouter(pbFile string) error {
 	return nil
 }
 
-var Visitor *ProtoVisitor
-
-func init() {
-	if Visitor == nil {
-		Visitor = &ProtoVisitor{}
+// loadImportPackage 递归加载 import 的文件到符号表 并注册其中的错误码
+func (g *Generator) loadImportPackage(i *proto.Import) {
+	if err := g.Visitor.GetSymbols().LoadImport(path.Dir(i.Position.Filename), i.Filename); err != nil {
+		log.Errorf("load import err: %+v", err)
+		return
 	}
-}
 
-func loadImportPackage(pkg *proto.Import) {
-	// todo
+	for _, sym := range g.Visitor.Symbols.ErrCodeEnums() {
+		if sym.File.Package == g.Visitor.ProtoPackage {
+			continue
+		}
+		// 代码中以 go 包名引用 import 的错误码 与 @gen_to 中分析出的错误码一致
+		pkgName := sym.File.GoPackageName()
+		for _, elem := range sym.Enum.Elements {
+			ef, ok := elem.(*proto.EnumField)
+			if !ok {
+				continue
+			}
+			var v = MDocsErrCodeField{Code: ef.Integer, Pkg: pkgName}
+			if ef.InlineComment != nil {
+				v.Desc = trim(ef.InlineComment.Message())
+			}
+			g.Visitor.AddErrCodeEnumField(fmt.Sprintf("%s.%s", pkgName, ef.Name), v)
+		}
+	}
 }
 
-func loadService(srv *proto.Service) {
-	parseSrvGenRouter(srv)
-	parseSrvGenRPC(srv)
+func (g *Generator) loadService(srv *proto.Service) {
+	g.parseSrvGenRouter(srv)
+	g.parseSrvGenRPC(srv)
 	// 生成task相关
-	parseSrvGenTask(srv)
+	g.parseSrvGenTask(srv)
 }
 
-func loadPackage(p *proto.Package) {
+func (g *Generator) loadPackage(p *proto.Package) {
 	pkgName := strings.ReplaceAll(p.Name, ".", "_")
-	Visitor.PackageName = pkgName
+	g.Visitor.PackageName = pkgName
+	g.Visitor.ProtoPackage = p.Name
 }
 
-func loadMessage(m *proto.Message) {
+func (g *Generator) loadMessage(m *proto.Message) {
 	// 注册 validator
 	injectMsgValidatorTag(m)
 
@@ -153,41 +279,38 @@ func loadMessage(m *proto.Message) {
 	injectMsgJsonTag(m)
 
 	// 将该msg加入全局msg池子
-	Visitor.AddMsg(getOuterForefathersNameJoin(m), m)
+	g.Visitor.AddMsg(getOuterForefathersNameJoin(m), m)
 
 	// 找所有的model
-	addInformalModelMsg(m)
+	g.addInformalModelMsg(m)
 }
 
-// todo 这里需要对同级目录下的所有 ErrCode 进行合并
-func loadErrCodeEnum(e *proto.Enum) {
+// loadErrCodeEnum 加载错误码 同一目录下所有文件的错误码由 CodeGen 合并输出
+func (g *Generator) loadErrCodeEnum(e *proto.Enum) {
 	if e.Name != ErrCodeName {
 		return
 	}
 
+	g.Visitor.ErrCodeRange = g.errCodeRange(e)
 	for _, obj := range e.Elements {
 		field, ok := obj.(*proto.EnumField)
 		if !ok {
 			continue
 		}
-		if field.InlineComment == nil {
-			Visitor.AddErrCode(field.Integer, trim(field.Name), trim(field.Name))
-			continue
+		eci := g.errCodeInfo(field)
+		// 0 为 proto3 枚举的默认值 不受范围限制
+		if r := g.Visitor.ErrCodeRange; r != nil && eci.ErrCode != 0 && (eci.ErrCode < r[0] || eci.ErrCode > r[1]) {
+			g.errorf(field.Position, "errcode %s: %d 不在 @errcode_range %d %d 范围内", field.Name, eci.ErrCode, r[0], r[1])
 		}
-
-		if len(field.InlineComment.Lines) == 0 {
-			Visitor.AddErrCode(field.Integer, trim(field.Name), trim(field.Name))
-			continue
-		}
-		Visitor.AddErrCode(field.Integer, trim(field.Name), trim(field.InlineComment.Message()))
+		g.Visitor.AddErrCodeInfo(eci)
 	}
 }
 
 // addInformalModelMsg 添加非正式的message到model map中
 // 需要依赖 @model: true
-func addInformalModelMsg(msg *proto.Message) {
+func (g *Generator) addInformalModelMsg(msg *proto.Message) {
 	if strings.HasPrefix(msg.Name, NameModel) {
-		Visitor.AddModelMsg(msg.Name, msg)
+		g.Visitor.AddModelMsg(msg.Name, msg)
 		return
 	}
 
@@ -201,13 +324,13 @@ func addInformalModelMsg(msg *proto.Message) {
 	docs := msg.Comment.Lines
 	for _, doc := range docs {
 		if reg.MatchString(doc) {
-			Visitor.AddModelMsg(msg.Name, msg)
+			g.Visitor.AddModelMsg(msg.Name, msg)
 		}
 	}
 }
 
 // genModelTableName 收集表名
-func genModelTableName(msg *proto.Message) {
+func (g *Generator) genModelTableName(msg *proto.Message) {
 	modelName := trim(msg.Name)
 
 	var tableName string
@@ -215,7 +338,7 @@ func genModelTableName(msg *proto.Message) {
 	if msg.Comment == nil {
 		tableName = calm2Case(modelName)
 		tableName = strings.Replace(tableName, "model_", "", 1)
-		Visitor.AddModelTableName(modelName, tableName)
+		g.Visitor.AddModelTableName(modelName, tableName)
 		return
 	}
 
@@ -223,35 +346,37 @@ func genModelTableName(msg *proto.Message) {
 		var reg = regexp.MustCompile(`@table_name:\s*(\w+)\s*`)
 		res := reg.FindAllStringSubmatch(doc, -1)
 		if len(res) == 1 && len(res[0]) == 2 {
-			Visitor.AddModelTableName(modelName, trim(res[0][1]))
+			g.Visitor.AddModelTableName(modelName, trim(res[0][1]))
 			return
 		}
 	}
 	tableName = strings.Replace(calm2Case(modelName), "model_", "", 1)
-	Visitor.AddModelTableName(modelName, tableName)
+	g.Visitor.AddModelTableName(modelName, tableName)
 }
 
-func GenModelCode(packageName, srcPath string) error {
+func (g *Generator) GenModelCode(packageName, srcPath string) error {
 	var KV = struct {
 		PackageName string
 		FileName    string
 		FieldStruct map[string]map[string]ModelFieldStruct
 		TableName   map[string]string
 		ErrCodeList []*ErrCodeInfo
-		IndexMap    map[string]*IndexInfo
+		IndexMap    map[string]map[string]*IndexInfo
+		IndexAlias  map[string]string
 		NoScope     bool
 		DbType      string
 		FreqMap     core.FreqMap
 	}{
 		PackageName: packageName,
 		FileName:    path.Base(srcPath),
-		FieldStruct: Visitor.ModelFieldStructMap,
-		TableName:   Visitor.ModelTableNameMap,
-		ErrCodeList: Visitor.ErrCodeList,
-		IndexMap:    Visitor.ModelIndexMap,
-		NoScope:     ModelTplNotGenerateGetScopeFunc,
-		DbType:      Visitor.dbDriver,
-		FreqMap:     Visitor.FreqMap,
+		FieldStruct: g.Visitor.ModelFieldStructMap,
+		TableName:   g.Visitor.ModelTableNameMap,
+		ErrCodeList: g.Visitor.ErrCodeList,
+		IndexMap:    coreIndexMap(g.Visitor.ModelIndexMap),
+		IndexAlias:  indexAlias(coreIndexMap(g.Visitor.ModelIndexMap)),
+		NoScope:     g.noGetScopeFunc,
+		DbType:      g.Visitor.dbDriver,
+		FreqMap:     g.Visitor.FreqMap,
 	}
 	// model 字段 表名 生成
 	{
@@ -311,21 +436,11 @@ GenModelField:
 		}
 	}
 GenFreqRule:
-	// 生成限频数据
+	// 生成限频数据 指定了输出路径时 由 CodeGen 合并所有文件后统一输出
 	{
-		if len(Visitor.FreqMap) == 0 {
+		if len(g.Visitor.FreqMap) == 0 || g.freqRuleOutput != "" {
 			goto GenErrCode
 		}
-		oldPkgName := KV.PackageName
-
-		if FreqRuleOutput != "" {
-			FreqRuleOutput = strings.TrimSuffix(FreqRuleOutput, "/")
-			// if strings.HasSuffix(FreqRuleOutput, "/") {
-			// 	FreqRuleOutput = FreqRuleOutput[:len(FreqRuleOutput)-1]
-			// }
-			basename := filepath.Base(FreqRuleOutput)
-			KV.PackageName = basename
-		}
 
 		t, err := template.New("freq_tpl").Parse(FreqTpl)
 		if err != nil {
@@ -340,50 +455,30 @@ GenFreqRule:
 		}
 
 		// 空输出路径 源目录输出
-		if FreqRuleOutput == "" {
-			fileDir := path.Dir(srcPath)
-			fileName := path.Base(srcPath)
-			fileSuffix := path.Ext(fileName)
-			filePrefix := fileName[0 : len(fileName)-len(fileSuffix)]
-
-			if err := ioutil.WriteFile(fmt.Sprintf("%s/autogen_freq_rule_%s.go", fileDir, filePrefix), buf.Bytes(), 0666); err != nil {
-				log.Errorf("err: %+v", err)
-				return err
-			}
-		} else {
-			if err := ioutil.WriteFile(fmt.Sprintf("%s/freq_rule.go", FreqRuleOutput), buf.Bytes(), 0666); err != nil {
-				log.Errorf("err: %+v", err)
-				return err
-			}
+		fileDir := path.Dir(srcPath)
+		fileName := path.Base(srcPath)
+		fileSuffix := path.Ext(fileName)
+		filePrefix := fileName[0 : len(fileName)-len(fileSuffix)]
+
+		if err := ioutil.WriteFile(fmt.Sprintf("%s/autogen_freq_rule_%s.go", fileDir, filePrefix), buf.Bytes(), 0666); err != nil {
+			log.Errorf("err: %+v", err)
+			return err
 		}
-		KV.PackageName = oldPkgName
 	}
 GenErrCode:
-	// err_code 生成
+	// err_code 生成 合并输出时由 CodeGen 统一生成
 	{
 		// 没有错误代码 不要生成文件
-		if len(KV.ErrCodeList) == 0 {
+		if len(KV.ErrCodeList) == 0 || g.mergeErrCode {
 			goto FINALLY
 		}
-		t, err := template.New("errcode").Parse(ErrCodeTpl)
-		if err != nil {
-			log.Errorf("err: %+v", err)
-			return err
-		}
-		t.DefinedTemplates()
-		var buf bytes.Buffer
-		if err = t.Execute(&buf, KV); err != nil {
-			log.Errorf("err: %+v", err)
-			return err
-		}
 
 		fileDir := path.Dir(srcPath)
 		fileName := path.Base(srcPath)
 		fileSuffix := path.Ext(fileName)
 		filePrefix := fileName[0 : len(fileName)-len(fileSuffix)]
 
-		if err := ioutil.WriteFile(fmt.Sprintf("%s/autogen_errcode_%s.go", fileDir, filePrefix), buf.Bytes(), 0666); err != nil {
-			log.Errorf("err: %+v", err)
+		if err := writeErrCodeFile(fmt.Sprintf("%s/autogen_errcode_%s.go", fileDir, filePrefix), packageName, KV.ErrCodeList); err != nil {
 			return err
 		}
 	}
@@ -392,6 +487,35 @@ FINALLY:
 	return nil
 }
 
+// genFreqRuleOutput 限频规则输出到指定路径 包名取输出目录名
+func (g *Generator) genFreqRuleOutput(freqMap core.FreqMap) error {
+	output := strings.TrimSuffix(g.freqRuleOutput, "/")
+	var KV = struct {
+		PackageName string
+		FreqMap     core.FreqMap
+	}{
+		PackageName: filepath.Base(output),
+		FreqMap:     freqMap,
+	}
+
+	t, err := template.New("freq_tpl").Parse(FreqTpl)
+	if err != nil {
+		log.Errorf("err: %+v", err)
+		return err
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, KV); err != nil {
+		log.Errorf("err: %+v", err)
+		return err
+	}
+
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/freq_rule.go", output), buf.Bytes(), 0666); err != nil {
+		log.Errorf("err: %+v", err)
+		return err
+	}
+	return nil
+}
+
 // 递归查找父节点是否是 Model
 // func isForefatherModelForeachChild(m *proto.Message) bool {
 // 	if m == nil {
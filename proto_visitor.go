@@ -2,6 +2,7 @@ package proto_parser
 
 import (
 	"fmt"
+	"text/scanner"
 
 	"github.com/emicklei/proto"
 	"github.com/actorbuf/iota/core"
@@ -13,14 +14,32 @@ type StructField struct {
 }
 
 type ErrCodeInfo struct {
-	ErrCode int
-	ErrName string
-	ErrMsg  string
+	ErrCode    int
+	ErrName    string
+	ErrMsg     string
+	HTTPStatus int               // @http 声明的 http 状态码 0 为未声明
+	GRPCCode   string            // @grpc 声明的 grpc 状态码 codes 包中的名字 如 NotFound
+	Msgs       map[string]string // @msg_<locale> 声明的各语言说明
+	pos        scanner.Position
 }
 
 type IndexField struct {
-	Field string // 字段
+	Field string // bson 字段名 子文档的字段用 . 连接
 	Sort  int    // 排序 1 升序 -1倒叙
+	Kind  string // 索引类型 text 2dsphere hashed 为空时按 Sort 排序
+}
+
+// IndexCollation 索引的排序规则
+type IndexCollation struct {
+	Locale   string `json:"locale"`
+	Strength int    `json:"strength,omitempty"`
+}
+
+// IndexOptions 索引选项 联合索引的多个字段上声明的选项合并
+type IndexOptions struct {
+	Sparse        bool            // 稀疏索引
+	PartialFilter string          // partialFilterExpression 的 JSON
+	Collation     *IndexCollation // 排序规则
 }
 
 type IndexInfo struct {
@@ -29,6 +48,20 @@ type IndexInfo struct {
 	TTLIndex           bool          // 是否是TTL索引
 	ExpireAfterSeconds int64         // 指定一个以秒为单位的数值，完成 TTL设定，设定集合的生存时间
 	Fields             []*IndexField // 联合索引
+	Options            IndexOptions  // 索引选项
+}
+
+// coreCompatible 索引能否用 core.IndexInfo 表达 字段都是升降序且没有选项
+func (i *IndexInfo) coreCompatible() bool {
+	if i.Options.Sparse || i.Options.PartialFilter != "" || i.Options.Collation != nil {
+		return false
+	}
+	for _, f := range i.Fields {
+		if f.Kind != "" {
+			return false
+		}
+	}
+	return true
 }
 
 // GroupRouterNode 组路由节点
@@ -54,11 +87,16 @@ type GroupRouter struct {
 
 // MDocsField 字段描述
 type MDocsField struct {
-	FieldName  string // 字段名 对应json字段
-	FieldType  string // 字段类型
-	FieldDesc  string // 字段说明
-	IsRequire  bool   // 是否必须
-	FieldValue int    // 字段值
+	FieldName  string        // 字段名 对应json字段
+	FieldType  string        // 字段类型
+	FieldDesc  string        // 字段说明
+	IsRequire  bool          // 是否必须
+	FieldValue int           // 字段值
+	MapKey     string        // map 字段的 key 类型
+	MapValue   string        // map 字段的 value 类型
+	Oneof      string        // 字段所属的 oneof 名
+	Children   []*MDocsField // message 类型字段的子字段
+	Level      int           // 嵌套层级 由 doc_rows 展开时设置
 }
 
 // MDocsErrCodeField rpc文档的错误码说明
@@ -126,10 +164,12 @@ type ProtoVisitor struct {
 	ModelTableNameMap map[string]string
 	// 注入err_code
 	ErrCodeList []*ErrCodeInfo
+	// @errcode_range 声明的错误码范围 [最小值, 最大值] 未声明时为 nil
+	ErrCodeRange []int
 	// model字段映射
 	ModelFieldStructMap map[string]map[string]ModelFieldStruct
-	// 索引
-	ModelIndexMap map[string]*IndexInfo
+	// 索引 model=>索引名=>索引 不同 model 可以使用相同的索引名
+	ModelIndexMap map[string]map[string]*IndexInfo
 	// 路由注册 srvName:GroupRouter
 	GroupRouterMap map[string]*GroupRouter
 	// 路由注册 导入哪些包
@@ -155,6 +195,44 @@ type ProtoVisitor struct {
 	FreqMap core.FreqMap
 	// 任务配置
 	Tasks map[string]TaskConfig
+	// proto 包名 保留 . 分隔 用于符号表查找
+	ProtoPackage string
+	// import 的查找路径
+	IncludePaths []string
+	// 递归 import 的所有 proto 文件的符号表
+	Symbols *SymbolTable
+}
+
+// GetSymbols 获取符号表 不存在则创建
+func (p *ProtoVisitor) GetSymbols() *SymbolTable {
+	if p.Symbols == nil {
+		p.Symbols = NewSymbolTable(p.IncludePaths)
+	}
+	return p.Symbols
+}
+
+// LookupMessage 在符号表中查找当前包引用的 message
+func (p *ProtoVisitor) LookupMessage(typ string) *proto.Message {
+	return p.Symbols.LookupMessage(p.ProtoPackage, typ)
+}
+
+// LookupEnum 在符号表中查找当前包引用的 enum
+func (p *ProtoVisitor) LookupEnum(typ string) *proto.Enum {
+	if e, ok := p.AllEnumMap[typ]; ok {
+		return e
+	}
+	return p.Symbols.LookupEnum(p.ProtoPackage, typ)
+}
+
+// scalarType 枚举按 int32 处理 其余类型原样返回
+func (p *ProtoVisitor) scalarType(typ string) string {
+	if isBuiltInType(typ) {
+		return typ
+	}
+	if p.LookupEnum(typ) != nil {
+		return "int32"
+	}
+	return typ
 }
 
 // AddTask 添加任务
@@ -280,18 +358,26 @@ func (p *ProtoVisitor) AddRouterGroup(srvName string, gr *GroupRouter) {
 	p.GroupRouterMap[srvName] = gr
 }
 
-func (p *ProtoVisitor) AddIndexField(name string, field *IndexField) error {
+// modelIndexes model 的索引 不存在时创建
+func (p *ProtoVisitor) modelIndexes(model string) map[string]*IndexInfo {
 	if len(p.ModelIndexMap) == 0 {
-		p.ModelIndexMap = make(map[string]*IndexInfo)
+		p.ModelIndexMap = make(map[string]map[string]*IndexInfo)
 	}
+	if p.ModelIndexMap[model] == nil {
+		p.ModelIndexMap[model] = make(map[string]*IndexInfo)
+	}
+	return p.ModelIndexMap[model]
+}
 
-	var info = p.ModelIndexMap[name]
+func (p *ProtoVisitor) AddIndexField(model, name string, field *IndexField) error {
+	var indexes = p.modelIndexes(model)
+	var info = indexes[name]
 	if info == nil {
 		info = &IndexInfo{
 			Name: name,
 		}
 		info.Fields = append(info.Fields, field)
-		p.ModelIndexMap[name] = info
+		indexes[name] = info
 		return nil
 	}
 
@@ -307,23 +393,20 @@ func (p *ProtoVisitor) AddIndexField(name string, field *IndexField) error {
 
 	info.Fields = append(info.Fields, field)
 
-	p.ModelIndexMap[name] = info
+	indexes[name] = info
 	return nil
 }
 
-func (p *ProtoVisitor) AddUniqueIndexField(name string, field *IndexField) error {
-	if len(p.ModelIndexMap) == 0 {
-		p.ModelIndexMap = make(map[string]*IndexInfo)
-	}
-
-	var info = p.ModelIndexMap[name]
+func (p *ProtoVisitor) AddUniqueIndexField(model, name string, field *IndexField) error {
+	var indexes = p.modelIndexes(model)
+	var info = indexes[name]
 	if info == nil {
 		info = &IndexInfo{
 			Unique: true,
 			Name:   name,
 		}
 		info.Fields = append(info.Fields, field)
-		p.ModelIndexMap[name] = info
+		indexes[name] = info
 		return nil
 	}
 
@@ -339,16 +422,13 @@ func (p *ProtoVisitor) AddUniqueIndexField(name string, field *IndexField) error
 
 	info.Fields = append(info.Fields, field)
 
-	p.ModelIndexMap[name] = info
+	indexes[name] = info
 	return nil
 }
 
-func (p *ProtoVisitor) AddTTLIndexField(name string, expiredTime int64, field *IndexField) error {
-	if len(p.ModelIndexMap) == 0 {
-		p.ModelIndexMap = make(map[string]*IndexInfo)
-	}
-
-	var info = p.ModelIndexMap[name]
+func (p *ProtoVisitor) AddTTLIndexField(model, name string, expiredTime int64, field *IndexField) error {
+	var indexes = p.modelIndexes(model)
+	var info = indexes[name]
 	if info == nil {
 		info = &IndexInfo{
 			Name:               name,
@@ -356,7 +436,7 @@ func (p *ProtoVisitor) AddTTLIndexField(name string, expiredTime int64, field *I
 			ExpireAfterSeconds: expiredTime,
 		}
 		info.Fields = append(info.Fields, field)
-		p.ModelIndexMap[name] = info
+		indexes[name] = info
 		return nil
 	}
 
@@ -372,16 +452,41 @@ func (p *ProtoVisitor) AddTTLIndexField(name string, expiredTime int64, field *I
 
 	info.Fields = append(info.Fields, field)
 
-	p.ModelIndexMap[name] = info
+	indexes[name] = info
+	return nil
+}
+
+// SetIndexOptions 合并索引选项 同一个索引的 partial 与 collation 定义前后不一致时报错
+func (p *ProtoVisitor) SetIndexOptions(model, name string, opt *IndexOptions) error {
+	var info = p.modelIndexes(model)[name]
+	if info == nil {
+		return fmt.Errorf("index not found: %s", name)
+	}
+	if opt.PartialFilter != "" {
+		if info.Options.PartialFilter != "" && info.Options.PartialFilter != opt.PartialFilter {
+			return fmt.Errorf("partial unified: %s", name)
+		}
+		info.Options.PartialFilter = opt.PartialFilter
+	}
+	if opt.Collation != nil {
+		if info.Options.Collation != nil && *info.Options.Collation != *opt.Collation {
+			return fmt.Errorf("collation unified: %s", name)
+		}
+		info.Options.Collation = opt.Collation
+	}
+	info.Options.Sparse = info.Options.Sparse || opt.Sparse
 	return nil
 }
 
 func (p *ProtoVisitor) AddErrCode(code int, name, msg string) {
-	var eci = &ErrCodeInfo{
+	p.AddErrCodeInfo(&ErrCodeInfo{
 		ErrCode: code,
 		ErrName: name,
 		ErrMsg:  msg,
-	}
+	})
+}
+
+func (p *ProtoVisitor) AddErrCodeInfo(eci *ErrCodeInfo) {
 	p.ErrCodeList = append(p.ErrCodeList, eci)
 }
 
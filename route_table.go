@@ -0,0 +1,177 @@
+package proto_parser
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/scanner"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RouteEntry 路由表中的一条路由
+type RouteEntry struct {
+	Method  string           `json:"method"`
+	Path    string           `json:"path"`
+	Service string           `json:"service"`
+	Handler string           `json:"handler"`
+	Author  string           `json:"author"`
+	Mws     []string         `json:"middleware"`
+	Pos     scanner.Position `json:"-"`
+}
+
+// RouteTable 一次运行中所有 proto 文件的 http 路由
+type RouteTable struct {
+	routes []*RouteEntry
+}
+
+// NewRouteTable 创建路由表
+func NewRouteTable() *RouteTable {
+	return &RouteTable{}
+}
+
+// routeFullPath 拼接路由组前缀与路由路径
+func routeFullPath(prefix, p string) string {
+	full := path.Join("/", prefix, p)
+	if strings.HasSuffix(p, "/") && full != "/" {
+		full += "/"
+	}
+	return full
+}
+
+// routePattern 路径参数只按位置匹配 /user/:id 与 /user/:uid 是同一条 gin 路由
+func routePattern(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			segs[i] = seg[:1]
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// routeOverlap 同一路径下 方法相同或任一方为 ANY 时冲突
+func routeOverlap(a, b *RouteEntry) bool {
+	if routePattern(a.Path) != routePattern(b.Path) {
+		return false
+	}
+	return a.Method == b.Method || a.Method == "ANY" || b.Method == "ANY"
+}
+
+// Add 添加路由 与已有路由冲突时返回诊断 冲突的路由不会加入路由表
+func (t *RouteTable) Add(route *RouteEntry) *Diagnostic {
+	for _, exist := range t.routes {
+		if !routeOverlap(exist, route) {
+			continue
+		}
+		var reason = "duplicate route"
+		if exist.Method != route.Method {
+			reason = "ambiguous ANY route"
+		}
+		return &Diagnostic{
+			Pos:      route.Pos,
+			Severity: SeverityError,
+			Msg: fmt.Sprintf("%s: %s %s of %s.%s conflicts with %s %s of %s.%s (%s:%d)",
+				reason, route.Method, route.Path, route.Service, route.Handler,
+				exist.Method, exist.Path, exist.Service, exist.Handler, exist.Pos.Filename, exist.Pos.Line),
+		}
+	}
+	t.routes = append(t.routes, route)
+	return nil
+}
+
+// Routes 按路径与方法排序的所有路由
+func (t *RouteTable) Routes() []*RouteEntry {
+	var res = append([]*RouteEntry(nil), t.routes...)
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Path != res[j].Path {
+			return res[i].Path < res[j].Path
+		}
+		return res[i].Method < res[j].Method
+	})
+	return res
+}
+
+// WriteManifest 输出路由清单 按文件后缀选择 json 或 csv
+func (t *RouteTable) WriteManifest(file string) error {
+	var data []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		routes := t.Routes()
+		if routes == nil {
+			routes = []*RouteEntry{}
+		}
+		data, err = json.MarshalIndent(routes, "", "  ")
+	case ".csv":
+		data, err = t.manifestCSV()
+	default:
+		err = fmt.Errorf("unsupported route manifest format: %s", file)
+	}
+	if err != nil {
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+	if err := ioutil.WriteFile(file, data, 0666); err != nil {
+		logrus.Errorf("err: %+v", err)
+		return err
+	}
+	return nil
+}
+
+func (t *RouteTable) manifestCSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"method", "path", "service", "handler", "author", "middleware", "file", "line"})
+	for _, r := range t.Routes() {
+		_ = w.Write([]string{r.Method, r.Path, r.Service, r.Handler, r.Author,
+			strings.Join(r.Mws, " "), r.Pos.Filename, strconv.Itoa(r.Pos.Line)})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// newRouteEntry 路由组节点对应的路由
+func newRouteEntry(srvName string, group *GroupRouter, node *GroupRouterNode) *RouteEntry {
+	var route = &RouteEntry{
+		Method:  node.Method,
+		Path:    routeFullPath(group.RouterPrefix, node.RouterPath),
+		Service: srvName,
+		Handler: node.FuncName,
+		Author:  node.Author,
+		Mws:     append(append([]string(nil), group.Mws...), node.Mws...),
+	}
+	if node.rpc != nil {
+		route.Pos = node.rpc.Position
+	}
+	return route
+}
+
+// groupRouterNames 当前文件的路由组 按 service 名排序
+func (g *Generator) groupRouterNames() []string {
+	var srvNames []string
+	for name := range g.Visitor.GroupRouterMap {
+		srvNames = append(srvNames, name)
+	}
+	sort.Strings(srvNames)
+	return srvNames
+}
+
+// routeEntries 当前文件路由组中的所有路由 按 service 名排序
+func (g *Generator) routeEntries() []*RouteEntry {
+	var res []*RouteEntry
+	for _, srvName := range g.groupRouterNames() {
+		group := g.Visitor.GroupRouterMap[srvName]
+		for _, node := range group.Apis {
+			res = append(res, newRouteEntry(srvName, group, node))
+		}
+	}
+	return res
+}
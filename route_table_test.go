@@ -0,0 +1,97 @@
+package proto_parser
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRouteTable(t *testing.T) {
+	var table = NewRouteTable()
+	if d := table.Add(&RouteEntry{Method: "POST", Path: routeFullPath("api/user", "/info"), Service: "UserAPI", Handler: "Info"}); d != nil {
+		t.Fatal(d)
+	}
+	if d := table.Add(&RouteEntry{Method: "GET", Path: "/api/user/info", Service: "UserAPI", Handler: "GetInfo"}); d != nil {
+		t.Fatal(d)
+	}
+	// 跨 service 的重复路由
+	if d := table.Add(&RouteEntry{Method: "POST", Path: "/api/user/info", Service: "AdminAPI", Handler: "Info"}); d == nil {
+		t.Errorf("expect duplicate route")
+	}
+	// ANY 与已有方法重叠
+	if d := table.Add(&RouteEntry{Method: "ANY", Path: "/api/user/info", Service: "AdminAPI", Handler: "Any"}); d == nil || !strings.Contains(d.Msg, "ANY") {
+		t.Errorf("expect ambiguous ANY route, got %v", d)
+	}
+
+	// 路径参数名不同仍是同一条路由
+	if d := table.Add(&RouteEntry{Method: "GET", Path: "/api/user/:id", Service: "UserAPI", Handler: "Get"}); d != nil {
+		t.Fatal(d)
+	}
+	if d := table.Add(&RouteEntry{Method: "GET", Path: "/api/user/:uid", Service: "AdminAPI", Handler: "Get"}); d == nil {
+		t.Errorf("expect duplicate route with renamed param")
+	}
+
+	dir, err := ioutil.TempDir("", "route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "routes.csv")
+	if err := table.WriteManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(manifest)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "GET,/api/user/:id") {
+		t.Errorf("unexpected manifest:\n%s", data)
+	}
+}
+
+func TestCodeGenRouteConflict(t *testing.T) {
+	dir := t.TempDir()
+	for i, param := range []string{":id", ":uid"} {
+		src := fmt.Sprintf(`syntax = "proto3";
+package svc;
+option go_package = "./;svc";
+
+message Get%[1]dReq {
+    int64 id = 1;
+}
+
+message Get%[1]dResp {
+    string name = 1;
+}
+
+// @route_group: true
+// @route_api: /api/user
+// @gen_to: %[3]s
+service S%[1]dAPI {
+    // @method: GET
+    // @api: /get/%[2]s
+    rpc Get (Get%[1]dReq) returns (Get%[1]dResp);
+}
+`, i, param, filepath.Join(dir, "controller", fmt.Sprintf("s%d.go", i)))
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("s%d.proto", i)), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := NewGenerator().CodeGen(&CodeGenConfig{
+		PbFilePath:     dir,
+		IncludePbFiles: []string{dir},
+		NativeCompile:  true,
+	})
+	var errs CodeGenErrors
+	if !errors.As(err, &errs) || len(errs.Diagnostics()) != 1 || !strings.Contains(errs.Diagnostics()[0].Msg, "duplicate route") {
+		t.Fatalf("expect duplicate route, got: %v", err)
+	}
+	// 冲突在写入之前检查 不生成任何文件
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("expect no output on route conflict, got %d files", len(files))
+	}
+}
@@ -34,8 +34,8 @@ import ({{if not $.NoScope}}{{if eq $.DbType "gdbc"}}
 	"github.com/actorbuf/iota/gdbc"{{else}}"github.com/actorbuf/iota/mdbc"{{end}}{{end}}
 	"github.com/actorbuf/iota/core"
 )
-{{range $indexName, $indexValue := .IndexMap }}
-var IndexName_{{$indexName}} = core.IndexInfo{
+{{range $modelName, $indexes := .IndexMap }}{{range $indexName, $indexValue := $indexes }}
+var {{$modelName}}Index_{{$indexName}} = core.IndexInfo{
 	{{if $indexValue.Unique}}Type:	core.IndexTypeUnique,
 	{{else if $indexValue.TTLIndex}}Type:	core.IndexTypeTTLIdx,
 	{{else}}Type:	core.IndexTypeNormal,
@@ -48,6 +48,10 @@ var IndexName_{{$indexName}} = core.IndexInfo{
 		},{{end}}
 	},
 }
+{{end}}{{end}}
+{{range $indexName, $modelName := .IndexAlias }}
+// Deprecated: 使用 {{$modelName}}Index_{{$indexName}}
+var IndexName_{{$indexName}} = {{$modelName}}Index_{{$indexName}}
 {{end}}
 {{range $modelName, $tableName := .TableName }}
 // Auto Generated {{$modelName}} Table Name. DO NOT EDIT.
@@ -55,6 +59,13 @@ const TableName{{$modelName}} = "{{$tableName}}"
 func (t *{{$modelName}}) TableName() string {
 	return "{{$tableName}}"
 }
+
+// Indexes {{$modelName}} 声明的索引 text 2dsphere hashed 以及带 sparse partial collation 选项的索引不在其中 见 OutputMongo
+func (t *{{$modelName}}) Indexes() []core.IndexInfo {
+	return {{with index $.IndexMap $modelName}}[]core.IndexInfo{ {{range $indexName, $indexValue := .}}
+		{{$modelName}}Index_{{$indexName}},{{end}}
+	}{{else}}nil{{end}}
+}
 {{if not $.NoScope}}
 func (t *{{$modelName}}) GetScope() *{{if eq $.DbType "gdbc"}}gdbc{{else}}mdbc{{end}}.Scope {
 	return {{if eq $.DbType "gdbc"}}gdbc{{else}}mdbc{{end}}.NewModel(&{{$modelName}}{})
@@ -81,7 +92,13 @@ const ErrCodeTpl = `// Code generated by proto-parser. DO NOT EDIT.
 
 package {{.PackageName}}
 
-import "github.com/actorbuf/iota/core"
+import (
+	"fmt"
+
+	"github.com/actorbuf/iota/core"{{if .ErrHasGRPC}}
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"{{end}}
+)
 
 const ({{range $index, $info := .ErrCodeList }}
 	// {{$info.ErrName}} {{$info.ErrMsg}}
@@ -90,14 +107,85 @@ const ({{range $index, $info := .ErrCodeList }}
 
 var (
 	errCodeMap = map[int32]string{ {{range $index, $info := .ErrCodeList }}
-		{{$info.ErrName}}: "{{$info.ErrMsg}}",{{end}}
+		{{$info.ErrName}}: {{printf "%q" $info.ErrMsg}},{{end}}
+	}
+	errCodeHTTPStatusMap = map[int32]int{ {{range $index, $info := .ErrCodeList }}{{if $info.HTTPStatus}}
+		{{$info.ErrName}}: {{$info.HTTPStatus}},{{end}}{{end}}
+	}{{if .ErrHasGRPC}}
+	errCodeGRPCCodeMap = map[int32]codes.Code{ {{range $index, $info := .ErrCodeList }}{{if $info.GRPCCode}}
+		{{$info.ErrName}}: codes.{{$info.GRPCCode}},{{end}}{{end}}
+	}{{end}}
+	errCodeMsgMap = map[string]map[int32]string{ {{range $locale := .ErrLocales}}
+		"{{$locale}}": { {{range $info := $.ErrCodeList}}{{with index $info.Msgs $locale}}
+			{{$info.ErrName}}: {{printf "%q" .}},{{end}}{{end}}
+		},{{end}}
 	}
 )
 
 // auto register errcode
 func RegisterError() {
 	core.RegisterError(errCodeMap)
-}`
+}
+
+// HTTPStatus 错误码对应的 http 状态码 未声明 @http 时为 200
+func (x ErrCode) HTTPStatus() int {
+	if s, ok := errCodeHTTPStatusMap[int32(x)]; ok {
+		return s
+	}
+	return 200
+}
+{{if .ErrHasGRPC}}
+// GRPCCode 错误码对应的 grpc 状态码 未声明 @grpc 时为 Unknown
+func (x ErrCode) GRPCCode() codes.Code {
+	if c, ok := errCodeGRPCCodeMap[int32(x)]; ok {
+		return c
+	}
+	return codes.Unknown
+}
+{{end}}
+// Msg 错误码在指定语言下的说明 没有声明该语言时返回默认说明
+func (x ErrCode) Msg(locale string) string {
+	if msg, ok := errCodeMsgMap[locale][int32(x)]; ok {
+		return msg
+	}
+	return errCodeMap[int32(x)]
+}
+
+// ErrCodeError 携带错误码的业务错误
+type ErrCodeError struct {
+	Code ErrCode
+	Msg  string
+}
+
+func (e *ErrCodeError) Error() string {
+	return fmt.Sprintf("errcode %d: %s", e.Code, e.Msg)
+}
+
+// HTTPStatus 错误对应的 http 状态码
+func (e *ErrCodeError) HTTPStatus() int {
+	return e.Code.HTTPStatus()
+}
+{{if .ErrHasGRPC}}
+// GRPCCode 错误对应的 grpc 状态码
+func (e *ErrCodeError) GRPCCode() codes.Code {
+	return e.Code.GRPCCode()
+}
+
+// GRPCStatus 供 status.FromError 转换成 grpc 状态
+func (e *ErrCodeError) GRPCStatus() *status.Status {
+	return status.New(e.GRPCCode(), e.Msg)
+}
+{{end}}
+// WithLocale 使用指定语言的错误说明
+func (e *ErrCodeError) WithLocale(locale string) *ErrCodeError {
+	return &ErrCodeError{Code: e.Code, Msg: e.Code.Msg(locale)}
+}
+{{range $index, $info := .ErrCodeList }}
+// New{{$info.ErrName}} {{$info.ErrMsg}}
+func New{{$info.ErrName}}() *ErrCodeError {
+	return &ErrCodeError{Code: {{$info.ErrName}}, Msg: errCodeMap[{{$info.ErrName}}]}
+}
+{{end}}`
 
 const GroupRouterTpl = `// Code generated by proto-parser. DO NOT EDIT.
 
@@ -138,92 +226,304 @@ var (
 `
 
 const OutputMDTpl = `
-**简要描述:**
+**{{i18n "summary"}}:**
 
 - {{.Node.Describe}}
 
-**请求URL:**
+**{{i18n "url"}}:**
 - ` + "`" + `{{.Node.RouterPath}}` + "`" + `
 
-**请求方式:**
+**{{i18n "method"}}:**
 - {{.Node.Method}}
 
-**对接人:**
+**{{i18n "author"}}:**
 - {{.Node.Author}}
 
-**参数:**
+**{{i18n "params"}}:**
 
 {{if not_body_empty .ReqBody}}
-|参数名|必选|类型|说明|
-| :---- | :--- | :----- | ----- |{{range $field := .ReqFields}}
-| {{$field.FieldName}} | {{if $field.IsRequire}}是{{else}}否{{end}} | {{$field.FieldType}} | {{$field.FieldDesc}} |{{end}}
-{{else}}> 该接口没有请求参数{{end}}
+|{{i18n "name"}}|{{i18n "required"}}|{{i18n "type"}}|{{i18n "desc"}}|
+| :---- | :--- | :----- | ----- |{{range $field := doc_rows .ReqFields}}
+| {{indent $field.Level}}{{$field.FieldName}} | {{if $field.IsRequire}}{{i18n "yes"}}{{else}}{{i18n "no"}}{{end}} | {{$field.FieldType}}{{if $field.Oneof}} (oneof {{$field.Oneof}}){{end}} | {{$field.FieldDesc}} |{{end}}
+{{else}}> {{i18n "no_params"}}{{end}}
 
-**请求示例**
+**{{i18n "req_example"}}**
 ` + "```json" + `
 {{.ReqBody}}
 ` + "```" + `
 
-**返回示例**
+**{{i18n "resp_example"}}**
 ` + "```json" + `
 {{.RespBody}}
 ` + "```" + `
 
-**返回参数说明**
+**{{i18n "resp_fields"}}**
 {{if not_body_empty .RespBody}}
-|参数名|类型|说明|
-| :---- | :---- | ----- |{{range $field := .RespFields}}
-| {{$field.FieldName}} | {{$field.FieldType}} | {{$field.FieldDesc}} |{{end}}
+|{{i18n "name"}}|{{i18n "type"}}|{{i18n "desc"}}|
+| :---- | :---- | ----- |{{range $field := doc_rows .RespFields}}
+| {{indent $field.Level}}{{$field.FieldName}} | {{$field.FieldType}}{{if $field.Oneof}} (oneof {{$field.Oneof}}){{end}} | {{$field.FieldDesc}} |{{end}}
 {{$enums := len .EnumFields}}
-{{if ne $enums 0}}**枚举说明**
+{{if ne $enums 0}}**{{i18n "enums"}}**
 {{range $enumType, $enumFields := .EnumFields}}
-|枚举类型|枚举参数|枚举数值|枚举说明|
+|{{i18n "enum_type"}}|{{i18n "enum_name"}}|{{i18n "enum_value"}}|{{i18n "enum_desc"}}|
 | :---- | :--- | :----- | ----- |{{range $fieldInfo := $enumFields}}
 | {{$enumType}} | {{$fieldInfo.FieldName}} | {{$fieldInfo.FieldValue}} | {{$fieldInfo.FieldDesc}} |{{end}}
 {{end}}
-{{end}}{{else}}> 该接口不需要关注输出而应该关注错误码{{end}}
-{{$errcs := len .ErrCodeList}}{{if ne $errcs 0}}**接口返回错误码**
+{{end}}{{else}}> {{i18n "resp_empty"}}{{end}}
+{{$errcs := len .ErrCodeList}}{{if ne $errcs 0}}**{{i18n "errcodes"}}**
 
-|错误标注|错误码|说明|
+|{{i18n "err_name"}}|{{i18n "err_code"}}|{{i18n "desc"}}|
 | :---- | :---- | ---- |{{range $e := .ErrCodeList}}{{$defCode := eq $e.Code 0}}{{$defDesc := eq $e.Desc ""}}
-| {{$e.Name}} | {{if and $defCode $defDesc}}-{{else}}{{$e.Code}}{{end}} | {{if and $defCode $defDesc}}其他项目中的错误码{{else}}{{$e.Desc}}{{end}} |{{end}}
+| {{$e.Name}} | {{if and $defCode $defDesc}}-{{else}}{{$e.Code}}{{end}} | {{if and $defCode $defDesc}}{{i18n "outer_errcode"}}{{else}}{{$e.Desc}}{{end}} |{{end}}
 {{end}}`
 
-const CompleteRouteGenerateAndPackageTpl = `package {{if ne $.pkgName $.defaultControllerPkgName}}{{$.pkgName}}_controller
-	{{else}}{{$.defaultControllerPkgName}}
-	{{end}}
+// docLabels 内置模板(markdown html asciidoc 文档站)中按语言切换的文案 模板中通过 i18n 函数获取
+var docLabels = map[string]map[string]string{
+	DocLocaleZh: {
+		"summary":       "简要描述",
+		"url":           "请求URL",
+		"method":        "请求方式",
+		"author":        "对接人",
+		"params":        "参数",
+		"no_params":     "该接口没有请求参数",
+		"req_example":   "请求示例",
+		"resp_example":  "返回示例",
+		"resp_fields":   "返回参数说明",
+		"resp_empty":    "该接口不需要关注输出而应该关注错误码",
+		"name":          "参数名",
+		"required":      "必选",
+		"type":          "类型",
+		"desc":          "说明",
+		"yes":           "是",
+		"no":            "否",
+		"enums":         "枚举说明",
+		"enum_type":     "枚举类型",
+		"enum_name":     "枚举参数",
+		"enum_value":    "枚举数值",
+		"enum_desc":     "枚举说明",
+		"errcodes":      "接口返回错误码",
+		"err_name":      "错误标注",
+		"err_code":      "错误码",
+		"outer_errcode": "其他项目中的错误码",
+		// 文档站页面
+		"site_title":       "接口文档",
+		"api":              "接口",
+		"back":             "返回目录",
+		"errcode_page":     "错误码说明",
+		"related_enums":    "相关枚举",
+		"related_errcodes": "相关错误码",
+		"used_by":          "使用方",
+	},
+	DocLocaleEn: {
+		"summary":       "Summary",
+		"url":           "URL",
+		"method":        "Method",
+		"author":        "Contact",
+		"params":        "Parameters",
+		"no_params":     "This API has no request parameters",
+		"req_example":   "Request example",
+		"resp_example":  "Response example",
+		"resp_fields":   "Response fields",
+		"resp_empty":    "This API returns no data, check the error codes instead",
+		"name":          "Name",
+		"required":      "Required",
+		"type":          "Type",
+		"desc":          "Description",
+		"yes":           "yes",
+		"no":            "no",
+		"enums":         "Enums",
+		"enum_type":     "Enum",
+		"enum_name":     "Name",
+		"enum_value":    "Value",
+		"enum_desc":     "Description",
+		"errcodes":      "Error codes",
+		"err_name":      "Error",
+		"err_code":      "Code",
+		"outer_errcode": "error code of another project",
+		// 文档站页面
+		"site_title":       "API Docs",
+		"api":              "API",
+		"back":             "Back to index",
+		"errcode_page":     "Error codes",
+		"related_enums":    "Related enums",
+		"related_errcodes": "Related error codes",
+		"used_by":          "Used by",
+	},
+}
 
-import (
-    "github.com/actorbuf/iota/core"
-    {{$.importPackage}}
-)
+// OutputHTMLTpl html 文档模板 使用 html/template 渲染
+const OutputHTMLTpl = `<section class="api-doc">
+<h3>{{i18n "summary"}}</h3>
+<p>{{.Node.Describe}}</p>
+<h3>{{i18n "url"}}</h3>
+<p><code>{{.Node.RouterPath}}</code></p>
+<h3>{{i18n "method"}}</h3>
+<p>{{.Node.Method}}</p>
+<h3>{{i18n "author"}}</h3>
+<p>{{.Node.Author}}</p>
+<h3>{{i18n "params"}}</h3>
+{{if not_body_empty .ReqBody}}<table>
+<tr><th>{{i18n "name"}}</th><th>{{i18n "required"}}</th><th>{{i18n "type"}}</th><th>{{i18n "desc"}}</th></tr>{{range $field := doc_rows .ReqFields}}
+<tr><td>{{indent $field.Level}}{{$field.FieldName}}</td><td>{{if $field.IsRequire}}{{i18n "yes"}}{{else}}{{i18n "no"}}{{end}}</td><td>{{$field.FieldType}}{{if $field.Oneof}} (oneof {{$field.Oneof}}){{end}}</td><td>{{$field.FieldDesc}}</td></tr>{{end}}
+</table>
+{{else}}<blockquote>{{i18n "no_params"}}</blockquote>
+{{end}}<h3>{{i18n "req_example"}}</h3>
+<pre><code class="language-json">{{.ReqBody}}</code></pre>
+<h3>{{i18n "resp_example"}}</h3>
+<pre><code class="language-json">{{.RespBody}}</code></pre>
+<h3>{{i18n "resp_fields"}}</h3>
+{{if not_body_empty .RespBody}}<table>
+<tr><th>{{i18n "name"}}</th><th>{{i18n "type"}}</th><th>{{i18n "desc"}}</th></tr>{{range $field := doc_rows .RespFields}}
+<tr><td>{{indent $field.Level}}{{$field.FieldName}}</td><td>{{$field.FieldType}}{{if $field.Oneof}} (oneof {{$field.Oneof}}){{end}}</td><td>{{$field.FieldDesc}}</td></tr>{{end}}
+</table>
+{{if .EnumFields}}<h3>{{i18n "enums"}}</h3>
+{{range $enumType, $enumFields := .EnumFields}}<table>
+<tr><th>{{i18n "enum_type"}}</th><th>{{i18n "enum_name"}}</th><th>{{i18n "enum_value"}}</th><th>{{i18n "enum_desc"}}</th></tr>{{range $fieldInfo := $enumFields}}
+<tr><td>{{$enumType}}</td><td>{{$fieldInfo.FieldName}}</td><td>{{$fieldInfo.FieldValue}}</td><td>{{$fieldInfo.FieldDesc}}</td></tr>{{end}}
+</table>
+{{end}}{{end}}{{else}}<blockquote>{{i18n "resp_empty"}}</blockquote>
+{{end}}{{if .ErrCodeList}}<h3>{{i18n "errcodes"}}</h3>
+<table>
+<tr><th>{{i18n "err_name"}}</th><th>{{i18n "err_code"}}</th><th>{{i18n "desc"}}</th></tr>{{range $e := .ErrCodeList}}{{$defCode := eq $e.Code 0}}{{$defDesc := eq $e.Desc ""}}
+<tr><td>{{$e.Name}}</td><td>{{if and $defCode $defDesc}}-{{else}}{{$e.Code}}{{end}}</td><td>{{if and $defCode $defDesc}}{{i18n "outer_errcode"}}{{else}}{{$e.Desc}}{{end}}</td></tr>{{end}}
+</table>
+{{end}}</section>
+`
 
-type {{$.srvName}} struct {}
+// OutputAsciiDocTpl asciidoc 文档模板
+const OutputAsciiDocTpl = `
+*{{i18n "summary"}}:*
 
-// IDE: {{$.srvName}} implemented {{if ne $.implPkgName $.pkgName}}{{$.implPkgName}}.{{end}}{{$.srvName}}Impl interface
-var _ {{$.importPackagePart}}.{{$.srvName}}Impl = (*{{$.srvName}})(nil)
+* {{.Node.Describe}}
 
-// Bind 绑定路由组名称 默认service名称 请不要擅自修改
-func (receiver *{{$.srvName}}) Bind() string {
-	return "{{$.srvName}}"
-}
-{{range $route := $.routerNode}}
-// {{$route.FuncName}} {{$route.Describe}}
-func (receiver *{{$.srvName}}) {{$route.FuncName}}(ctx *core.Context, req *{{$.importPackagePart}}.{{$route.ReqName}}) (resp *{{$.importPackagePart}}.{{$route.RespName}}, err error) {
-	resp = new({{$.importPackagePart}}.{{$route.RespName}})
-	
-	// TODO impl...
+*{{i18n "url"}}:*
 
-	return resp, nil
-}
+* ` + "`" + `{{.Node.RouterPath}}` + "`" + `
+
+*{{i18n "method"}}:*
+
+* {{.Node.Method}}
+
+*{{i18n "author"}}:*
+
+* {{.Node.Author}}
+
+*{{i18n "params"}}:*
+
+{{if not_body_empty .ReqBody}}[cols="2,1,2,3",options="header"]
+|===
+|{{i18n "name"}} |{{i18n "required"}} |{{i18n "type"}} |{{i18n "desc"}}
+{{range $field := doc_rows .ReqFields}}
+|{{indent $field.Level}}{{$field.FieldName}} |{{if $field.IsRequire}}{{i18n "yes"}}{{else}}{{i18n "no"}}{{end}} |{{$field.FieldType}}{{if $field.Oneof}} (oneof {{$field.Oneof}}){{end}} |{{$field.FieldDesc}}
+{{end}}|===
+{{else}}NOTE: {{i18n "no_params"}}
 {{end}}
-`
+*{{i18n "req_example"}}:*
+
+[source,json]
+----
+{{.ReqBody}}
+----
+
+*{{i18n "resp_example"}}:*
+
+[source,json]
+----
+{{.RespBody}}
+----
+
+*{{i18n "resp_fields"}}:*
+
+{{if not_body_empty .RespBody}}[cols="2,2,3",options="header"]
+|===
+|{{i18n "name"}} |{{i18n "type"}} |{{i18n "desc"}}
+{{range $field := doc_rows .RespFields}}
+|{{indent $field.Level}}{{$field.FieldName}} |{{$field.FieldType}}{{if $field.Oneof}} (oneof {{$field.Oneof}}){{end}} |{{$field.FieldDesc}}
+{{end}}|===
+{{if .EnumFields}}
+*{{i18n "enums"}}:*
+{{range $enumType, $enumFields := .EnumFields}}
+[options="header"]
+|===
+|{{i18n "enum_type"}} |{{i18n "enum_name"}} |{{i18n "enum_value"}} |{{i18n "enum_desc"}}
+{{range $fieldInfo := $enumFields}}
+|{{$enumType}} |{{$fieldInfo.FieldName}} |{{$fieldInfo.FieldValue}} |{{$fieldInfo.FieldDesc}}
+{{end}}|===
+{{end}}{{end}}{{else}}NOTE: {{i18n "resp_empty"}}
+{{end}}{{if .ErrCodeList}}
+*{{i18n "errcodes"}}:*
+
+[options="header"]
+|===
+|{{i18n "err_name"}} |{{i18n "err_code"}} |{{i18n "desc"}}
+{{range $e := .ErrCodeList}}{{$defCode := eq $e.Code 0}}{{$defDesc := eq $e.Desc ""}}
+|{{$e.Name}} |{{if and $defCode $defDesc}}-{{else}}{{$e.Code}}{{end}} |{{if and $defCode $defDesc}}{{i18n "outer_errcode"}}{{else}}{{$e.Desc}}{{end}}
+{{end}}|===
+{{end}}`
+
+// MDSiteIndexTpl 文档站目录页
+const MDSiteIndexTpl = `# {{i18n "site_title"}}
+
+- [{{i18n "enums"}}](enums.md)
+- [{{i18n "errcode_page"}}](errcode.md)
+{{range $srv := .}}
+## [{{$srv.Name}}]({{$srv.File}})
+
+> {{$srv.Proto}}
+
+|{{i18n "api"}}|{{i18n "method"}}|{{i18n "url"}}|{{i18n "summary"}}|
+| :---- | :---- | :---- | ----- |{{range $rpc := $srv.Rpcs}}
+| [{{$rpc.Node.FuncName}}]({{$srv.File}}#{{anchor $rpc.Node.FuncName}}) | {{$rpc.Node.Method}} | ` + "`" + `{{$rpc.Path}}` + "`" + ` | {{$rpc.Node.Describe}} |{{end}}
+{{end}}`
+
+// MDSiteServiceTpl 文档站中一个 service 的页面
+const MDSiteServiceTpl = `# {{.Name}}
+
+[{{i18n "back"}}](index.md)
+
+> {{.Proto}}
+{{range $rpc := .Rpcs}}
+- [{{$rpc.Node.FuncName}}](#{{anchor $rpc.Node.FuncName}}) {{$rpc.Node.Describe}}{{end}}
+{{range $rpc := .Rpcs}}
+## {{$rpc.Node.FuncName}}
+{{$rpc.Doc}}
+{{if $rpc.Enums}}
+**{{i18n "related_enums"}}:** {{range $i, $e := $rpc.Enums}}{{if $i}} {{end}}[{{$e}}](enums.md#{{anchor $e}}){{end}}
+{{end}}{{if $rpc.ErrCodes}}
+**{{i18n "related_errcodes"}}:** {{range $i, $e := $rpc.ErrCodes}}{{if $i}} {{end}}[{{$e}}](errcode.md#{{anchor $e}}){{end}}
+{{end}}{{end}}`
+
+// MDSiteEnumTpl 文档站枚举说明页
+const MDSiteEnumTpl = `# {{i18n "enums"}}
+
+[{{i18n "back"}}](index.md)
+{{range $enum := .}}
+## {{$enum.Name}}
+
+|{{i18n "enum_name"}}|{{i18n "enum_value"}}|{{i18n "enum_desc"}}|
+| :---- | :----- | ----- |{{range $field := $enum.Fields}}
+| {{$field.FieldName}} | {{$field.FieldValue}} | {{$field.FieldDesc}} |{{end}}
+
+**{{i18n "used_by"}}:** {{range $i, $rpc := $enum.UsedBy}}{{if $i}} {{end}}[{{$rpc.Service}}.{{$rpc.Node.FuncName}}]({{$rpc.File}}#{{anchor $rpc.Node.FuncName}}){{end}}
+{{end}}`
+
+// MDSiteErrCodeTpl 文档站错误码说明页
+const MDSiteErrCodeTpl = `# {{i18n "errcode_page"}}
+
+[{{i18n "back"}}](index.md)
+{{range $e := .}}{{$defCode := eq $e.Code 0}}{{$defDesc := eq $e.Desc ""}}
+## {{$e.Name}}
+
+- {{i18n "err_code"}}: {{if and $defCode $defDesc}}-{{else}}{{$e.Code}}{{end}}
+- {{i18n "desc"}}: {{if and $defCode $defDesc}}{{i18n "outer_errcode"}}{{else}}{{$e.Desc}}{{end}}
+- {{i18n "used_by"}}: {{range $i, $rpc := $e.UsedBy}}{{if $i}} {{end}}[{{$rpc.Service}}.{{$rpc.Node.FuncName}}]({{$rpc.File}}#{{anchor $rpc.Node.FuncName}}){{end}}
+{{end}}`
 
 const CompleteRouteGenerateTpl = `
 type {{$.srvName}} struct {}
 
-// IDE: {{$.srvName}} implemented {{if ne $.implPkgName $.pkgName}}{{$.implPkgName}}.{{end}}{{$.srvName}}Impl interface
-var _ {{if ne $.implPkgName $.pkgName}}{{$.implPkgName}}.{{end}}{{$.srvName}}Impl = (*{{$.srvName}})(nil)
+// IDE: {{$.srvName}} implemented {{$.qualifier}}{{$.srvName}}Impl interface
+var _ {{$.qualifier}}{{$.srvName}}Impl = (*{{$.srvName}})(nil)
 
 // Bind 绑定路由组名称 默认service名称 请不要擅自修改
 func (receiver *{{$.srvName}}) Bind() string {
@@ -231,8 +531,8 @@ func (receiver *{{$.srvName}}) Bind() string {
 }
 {{range $route := $.routerNode}}
 // {{$route.FuncName}} {{$route.Describe}}
-func (receiver *{{$.srvName}}) {{$route.FuncName}}(ctx *core.Context, req *{{if ne $.implPkgName $.pkgName}}{{$.implPkgName}}.{{end}}{{$route.ReqName}}) (resp *{{if ne $.implPkgName $.pkgName}}{{$.implPkgName}}.{{end}}{{$route.RespName}}, err error) {
-	resp = new({{if ne $.implPkgName $.pkgName}}{{$.implPkgName}}.{{end}}{{$route.RespName}})
+func (receiver *{{$.srvName}}) {{$route.FuncName}}(ctx *{{$.coreName}}.Context, req *{{$.qualifier}}{{$route.ReqName}}) (resp *{{$.qualifier}}{{$route.RespName}}, err error) {
+	resp = new({{$.qualifier}}{{$route.RespName}})
 	
 	// TODO impl...
 
@@ -243,8 +543,8 @@ func (receiver *{{$.srvName}}) {{$route.FuncName}}(ctx *core.Context, req *{{if
 
 const FuncRouteGenerateTpl = `{{range $route := $.routerNode}}
 // {{$route.FuncName}} {{$route.Describe}}
-func (receiver *{{$.srvName}}) {{$route.FuncName}}(ctx *core.Context, req *{{if ne $.implPkgName $.pkgName}}{{$.implPkgName}}.{{end}}{{$route.ReqName}}) (resp *{{if ne $.implPkgName $.pkgName}}{{$.implPkgName}}.{{end}}{{$route.RespName}}, err error) {
-	resp = new({{if ne $.implPkgName $.pkgName}}{{$.implPkgName}}.{{end}}{{$route.RespName}})
+func (receiver *{{$.srvName}}) {{$route.FuncName}}(ctx *{{$.coreName}}.Context, req *{{$.qualifier}}{{$route.ReqName}}) (resp *{{$.qualifier}}{{$route.RespName}}, err error) {
+	resp = new({{$.qualifier}}{{$route.RespName}})
 	
 	// TODO impl...
 
@@ -300,3 +600,105 @@ const GenerateTaskFuncTemplate = `package {{.PackageName}}
 }
 {{end}}
 `
+
+const CompleteRpcServiceGenerateAndPackageTpl = `package {{$.pkgName}}
+
+import ({{if $.needContext}}
+	"context"
+{{end}}
+	"google.golang.org/grpc"{{if ne $.importPackage ""}}
+	{{$.importPackage}}{{end}}{{range $.typeImports}}
+	"{{.}}"{{end}}
+)
+` + CompleteRpcServiceGenerateTpl
+
+const CompleteRpcServiceGenerateTpl = `
+// {{$.srvName}} {{$.srvDesc}}
+type {{$.srvName}} struct {
+	{{$.qualifier}}Unimplemented{{$.srvName}}Server
+}
+` + FuncRpcServiceGenerateTpl + FuncRpcServiceRegisterTpl
+
+const FuncRpcServiceGenerateTpl = `{{range $rpc := $.rpcNode}}
+// {{$rpc.FuncName}} {{$rpc.Describe}}
+{{if $rpc.StreamsRequest}}func (s *{{$.srvName}}) {{$rpc.FuncName}}(stream {{$.qualifier}}{{$.srvName}}_{{$rpc.FuncName}}Server) error {
+	// TODO impl...
+
+	return nil
+}
+{{else if $rpc.StreamsReturns}}func (s *{{$.srvName}}) {{$rpc.FuncName}}(req *{{$rpc.ReqType}}, stream {{$.qualifier}}{{$.srvName}}_{{$rpc.FuncName}}Server) error {
+	// TODO impl...
+
+	return nil
+}
+{{else}}func (s *{{$.srvName}}) {{$rpc.FuncName}}(ctx context.Context, req *{{$rpc.ReqType}}) (*{{$rpc.RespType}}, error) {
+	resp := new({{$rpc.RespType}})
+
+	// TODO impl...
+
+	return resp, nil
+}
+{{end}}{{end}}`
+
+const FuncRpcServiceRegisterTpl = `
+// Register{{$.srvName}} 注册 {{$.srvName}} 到 grpc server 请不要擅自修改
+func Register{{$.srvName}}(s *grpc.Server) {
+	{{$.qualifier}}Register{{$.srvName}}Server(s, &{{$.srvName}}{})
+}
+`
+
+const FieldRpcServiceEmbedTpl = `
+	{{$.qualifier}}Unimplemented{{$.srvName}}Server
+`
+
+const MongoEnsureTpl = `// Code generated by proto-parser. DO NOT EDIT.
+// source: {{.FileName}}
+
+package {{.PackageName}}
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+{{range $c := .Collections}}
+// EnsureIndexes 在 {{$c.Name}} 上创建 {{$c.Model}} 声明的索引 已存在且定义相同的索引不会重复创建
+func (t *{{$c.Model}}) EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+{{- if $c.Indexes}}
+{{- range $i, $idx := $c.Indexes}}{{if $idx.PartialFilter}}
+	var partial{{$i}} bson.D
+	if err := bson.UnmarshalExtJSON([]byte({{printf "%q" $idx.PartialFilter}}), false, &partial{{$i}}); err != nil {
+		return err
+	}{{end}}{{end}}
+	_, err := db.Collection({{printf "%q" $c.Name}}).Indexes().CreateMany(ctx, []mongo.IndexModel{ {{range $i, $idx := $c.Indexes}}
+		{
+			Keys:    bson.D{ {{range $j, $k := $idx.Keys}}{{if $j}}, {{end}}{Key: {{printf "%q" $k.Key}}, Value: {{printf "%#v" $k.Value}}}{{end}} },
+			Options: options.Index().SetName({{printf "%q" $idx.Name}}){{if $idx.Unique}}.SetUnique(true){{end}}{{if $idx.TTL}}.SetExpireAfterSeconds({{$idx.ExpireAfterSeconds}}){{end -}}
+				{{if $idx.Sparse}}.SetSparse(true){{end}}{{if $idx.PartialFilter}}.SetPartialFilterExpression(partial{{$i}}){{end -}}
+				{{with $idx.Collation}}.SetCollation(&options.Collation{Locale: {{printf "%q" .Locale}}{{if .Strength}}, Strength: {{.Strength}}{{end}}}){{end}},
+		},{{end}}
+	})
+	return err
+{{- else}}
+	return nil
+{{- end}}
+}
+
+// EnsureValidator 设置 {{$c.Name}} 的 $jsonSchema 校验 collection 不存在时创建
+func (t *{{$c.Model}}) EnsureValidator(ctx context.Context, db *mongo.Database) error {
+	var validator bson.D
+	if err := bson.UnmarshalExtJSON([]byte({{printf "%q" $c.ValidatorJSON}}), false, &validator); err != nil {
+		return err
+	}
+	names, err := db.ListCollectionNames(ctx, bson.D{ {Key: "name", Value: {{printf "%q" $c.Name}}} })
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return db.CreateCollection(ctx, {{printf "%q" $c.Name}}, options.CreateCollection().SetValidator(validator))
+	}
+	return db.RunCommand(ctx, bson.D{ {Key: "collMod", Value: {{printf "%q" $c.Name}}}, {Key: "validator", Value: validator} }).Err()
+}
+{{end}}`
@@ -38,3 +38,26 @@ func MD5(a, b string) string {
 	h.Write([]byte(a + "_" + b))
 	return hex.EncodeToString(h.Sum(nil))[8:24]
 }
+
+// DocRows 将嵌套的字段文档按先序展开成表格行 并设置每行的嵌套层级
+func DocRows(fields []*MDocsField) []*MDocsField {
+	var rows []*MDocsField
+	var walk func(fields []*MDocsField, level int)
+	walk = func(fields []*MDocsField, level int) {
+		for _, field := range fields {
+			field.Level = level
+			rows = append(rows, field)
+			walk(field.Children, level+1)
+		}
+	}
+	walk(fields, 0)
+	return rows
+}
+
+// DocIndent 按嵌套层级缩进字段名
+func DocIndent(level int) string {
+	if level == 0 {
+		return ""
+	}
+	return strings.Repeat("\u2003", level-1) + "└ "
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -14,6 +15,7 @@ import (
 	"strings"
 	"unicode"
 
+	"github.com/elliotchance/pie/pie"
 	"github.com/emicklei/proto"
 	"github.com/emicklei/proto-contrib/pkg/protofmt"
 )
@@ -96,77 +98,12 @@ func calm2KebabCaseBSON(src string) string {
 	return buffer.String()
 }
 
-// GormTagGenerate gorm注入tag
+// GormTagGenerate gorm注入tag 合并 tag 中声明的项与按字段名 类型推导的 column type 主键
+// tag 中不认识或重复的项被忽略
 func GormTagGenerate(field, tag, typ string) string {
-	var res strings.Builder
-	docSplit := strings.Split(tag, ";")
-
-	var getType = func(t string) string {
-		switch t {
-		case "string":
-			return "type:string;"
-		case "float", "double":
-			return "type:float;"
-		case "bool":
-			return "type:bool;"
-		case "uint32", "uint64", "fixed32", "fixed64":
-			return "type:uint;"
-		case "int32", "int64", "sint32", "sint64", "sfixed32", "sfixed64":
-			return "type:int;"
-		case "bytes":
-			return "type:bytes;"
-		}
-		return ""
-	}
-
-	// 没有声明 gorm 的标记的时候 注入 column
-	if len(docSplit) <= 1 {
-		if field == "Id" || field == "ID" || field == "id" {
-			res.WriteString("primaryKey;autoIncrement;")
-		}
-		// 注入字段名
-		column := calm2Case(field)
-		res.WriteString("column:" + column + ";")
-		// 注入默认的字段类型
-		switch typ {
-		case "string":
-			res.WriteString("type:string;")
-		case "float", "double":
-			res.WriteString("type:float;")
-		case "bool":
-			res.WriteString("type:bool;")
-		case "uint32", "uint64", "fixed32", "fixed64":
-			res.WriteString("type:uint;")
-		case "int32", "int64", "sint32", "sint64", "sfixed32", "sfixed64":
-			res.WriteString("type:int;")
-		case "bytes":
-			res.WriteString("type:bytes;")
-		}
-		return res.String()
-	}
-
-	var docMap = make(map[string]string)
-	for _, tt := range docSplit {
-		// 再拆分一下
-		if strings.Contains(tt, ":") {
-			kv := strings.Split(tt, ":")
-			docMap[kv[0]] = kv[1]
-			continue
-		}
-		docMap[tt] = ""
-	}
-
-	// 检测是否配置了 column
-	if _, exist := docMap["column"]; !exist {
-		docMap["column"] = GormTagGenerate(field, "", typ)
-	}
-
-	// 检测是否配置了 type
-	if _, exist := docMap["type"]; !exist {
-		docMap["type"] = getType(typ)
-	}
-
-	return ""
+	t, _ := parseGormTag(tag)
+	t.infer(field, typ)
+	return t.String()
 }
 
 // calm2CaseBSON 驼峰转bson下划线
@@ -227,11 +164,31 @@ func parserFormatWrite(filename string, parserProto *proto.Proto) error {
 	protofmt.NewFormatter(buf, "    ").Format(parserProto) // 1 tab
 
 	// write back to input
-	if err := os.WriteFile(filename, buf.Bytes(), os.ModePerm); err != nil {
+	return writeFileAtomic(filename, buf.Bytes())
+}
+
+// writeFileAtomic 先写同目录下的临时文件再重命名 写入中断时不会留下不完整的文件
+func writeFileAtomic(filename string, data []byte) error {
+	var mode os.FileMode = 0666
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
 		return err
 	}
-
-	return nil
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
 }
 
 // isBuiltInType 是否是内置类型
@@ -264,25 +221,41 @@ func trim(src string) string {
 }
 
 // getErrorCodeField 基于 *proto.Enum 获取某个字段
-func getErrorCodeField(e *proto.Enum) {
+func (g *Generator) getErrorCodeField(e *proto.Enum) {
 	for _, element := range e.Elements {
 		ef, ok := element.(*proto.EnumField)
 		if !ok {
 			continue
 		}
-		if Visitor.MDocDepPkgName == "" {
+		if g.Visitor.MDocDepPkgName == "" {
 			continue
 		}
-		var v = MDocsErrCodeField{Code: ef.Integer, Pkg: Visitor.MDocDepPkgName}
+		var v = MDocsErrCodeField{Code: ef.Integer, Pkg: g.Visitor.MDocDepPkgName}
 		if ef.InlineComment != nil {
 			v.Desc = trim(ef.InlineComment.Message())
 		}
-		Visitor.AddErrCodeEnumField(fmt.Sprintf("%s.%s", Visitor.MDocDepPkgName, ef.Name), v)
+		g.Visitor.AddErrCodeEnumField(fmt.Sprintf("%s.%s", g.Visitor.MDocDepPkgName, ef.Name), v)
 	}
 }
 
 // getRpcErrCodeMap 获取rpc的错误码map
-func getRpcErrCodeMap(doc []string) {
+func (g *Generator) getRpcErrCodeMap(doc []string) {
+	names := rpcErrCodeNames(doc)
+	if len(names) == 0 {
+		return
+	}
+	var errMap = make(map[string]MDocsErrCodeField)
+	for _, name := range names {
+		errMap[name] = MDocsErrCodeField{}
+	}
+	if g.Visitor.MDoc == nil {
+		g.Visitor.MDoc = new(MDocs)
+	}
+	g.Visitor.MDoc.ErrCodeMap = errMap
+}
+
+// rpcErrCodeNames 按顺序获取 rpc 注释中 @error 下的错误码名
+func rpcErrCodeNames(doc []string) []string {
 	var segStart = -1
 	for i, s := range doc {
 		if !strings.Contains(s, "@error") {
@@ -293,7 +266,7 @@ func getRpcErrCodeMap(doc []string) {
 	}
 	// 没有错误码
 	if segStart == -1 {
-		return
+		return nil
 	}
 	var segEnd = segStart
 	for i := segStart; i < len(doc); i++ {
@@ -306,20 +279,19 @@ func getRpcErrCodeMap(doc []string) {
 	}
 	// 没有错误码 只有 @error标签
 	if segStart == segEnd {
-		return
+		return nil
 	}
-	var errMap = make(map[string]MDocsErrCodeField)
+	var names []string
 	for i := segStart; i <= segEnd; i++ {
 		// 包含了 @ 标记的 过滤掉
 		if strings.Contains(doc[i], "@") {
 			continue
 		}
-		errMap[trim(doc[i])] = MDocsErrCodeField{}
-	}
-	if Visitor.MDoc == nil {
-		Visitor.MDoc = new(MDocs)
+		if name := trim(doc[i]); !pie.Strings(names).Contains(name) {
+			names = append(names, name)
+		}
 	}
-	Visitor.MDoc.ErrCodeMap = errMap
+	return names
 }
 
 // getInlineComment 获取行内注释
@@ -331,7 +303,7 @@ func getInlineComment(field *proto.NormalField) string {
 }
 
 // prepareMiddleware 准备中间件处理
-func prepareMiddleware(doc string) []string {
+func (g *Generator) prepareMiddleware(doc string) []string {
 	var mw = regexp.MustCompile(RegexpMiddlewareContent)
 	res := mw.FindAllStringSubmatch(doc, -1)
 	if len(res) != 1 {
@@ -354,7 +326,7 @@ func prepareMiddleware(doc string) []string {
 		if len(v) != 3 {
 			continue
 		}
-		Visitor.GroupRouterImportPkg = append(Visitor.GroupRouterImportPkg, v[1])
+		g.Visitor.GroupRouterImportPkg = append(g.Visitor.GroupRouterImportPkg, v[1])
 		pkg := filepath.Base(v[1])
 		mws := strings.Split(v[2], ",")
 		for _, mw := range mws {
@@ -365,7 +337,7 @@ func prepareMiddleware(doc string) []string {
 
 	// 导入中间件所需要的依赖包
 	if len(upkgs) != 0 {
-		Visitor.GroupRouterImportPkg = append(Visitor.GroupRouterImportPkg, "github.com/gin-gonic/gin")
+		g.Visitor.GroupRouterImportPkg = append(g.Visitor.GroupRouterImportPkg, "github.com/gin-gonic/gin")
 	}
 
 	return upkgs
@@ -489,4 +461,34 @@ func GetCurrentModuleName() (modName string, err error) {
 	modName = strings.Trim(modName, "\n")
 
 	return
-}
\ No newline at end of file
+}
+// getGoPackageOption 获取 proto 文件中 option go_package 的值
+func getGoPackageOption(pbFile string) string {
+	text, _ := GetLineWithchars(pbFile, "option go_package = ")
+	if text == "" {
+		return ""
+	}
+	protoGoPkgOption := "option go_package = "
+	importPackage := strings.Replace(text, protoGoPkgOption, "", -1)
+	importPackage = strings.Replace(importPackage, ";", "", -1)
+	importPackage = strings.Replace(importPackage, "\n", "", -1)
+	importPackage = strings.Replace(importPackage, "\r", "", -1)
+	return importPackage
+}
+
+// fixImportPackage 基于 go.mod 的模块名补全 go_package 返回带引号的导入路径和包名
+func fixImportPackage(importPackage string) (importPath string, importPackagePart string) {
+	importPackage = strings.Trim(importPackage, "\"")
+	sli := strings.Split(importPackage, "/")
+	if len(sli) > 0 {
+		importPackagePart = sli[len(sli)-1]
+	}
+	// 外面有判断err，这里就不判断了
+	modName, _ := GetCurrentModuleName()
+	if strings.HasSuffix(modName, sli[0]) {
+		importPath = "\"" + strings.Trim(modName, sli[0]) + importPackage + "\""
+	} else {
+		importPath = "\"" + modName + "/" + importPackage + "\""
+	}
+	return importPath, importPackagePart
+}